@@ -0,0 +1,98 @@
+package objcache
+
+import "sync"
+
+// sketchDepth is the number of rows of a frequency sketch.
+const sketchDepth = 4
+
+// sketchMax is the count a counter of the sketch saturates at.
+const sketchMax = 15
+
+// sketch is a count-min sketch estimating how often keys were used
+// recently, for Config.Admission. The counters are halved after every
+// 10 times MaxEntryLimit Gets, so old popularity fades.
+type sketch struct {
+	mu        sync.Mutex
+	rows      [sketchDepth][]uint8
+	mask      uint64
+	additions int
+	resetAt   int
+}
+
+// newSketch makes a sketch sized for limit items.
+func newSketch(limit int) *sketch {
+	width := 16
+	for width < 4*limit {
+		width = width * 2
+	}
+	s := &sketch{mask: uint64(width - 1), resetAt: 10 * limit}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+// index returns the counter of the hash h in row i.
+func (s *sketch) index(h uint64, i int) uint64 {
+	h = h + uint64(i)*0x9e3779b97f4a7c15
+	h = (h ^ h>>31) * 0xbf58476d1ce4e5b9
+	return (h ^ h>>29) & s.mask
+}
+
+// add counts one use of k.
+func (s *sketch) add(k string) {
+	h := fnvHash(k)
+	s.mu.Lock()
+	for i := range s.rows {
+		j := s.index(h, i)
+		if s.rows[i][j] < sketchMax {
+			s.rows[i][j] = s.rows[i][j] + 1
+		}
+	}
+	s.additions = s.additions + 1
+	if s.additions >= s.resetAt {
+		for i := range s.rows {
+			for j := range s.rows[i] {
+				s.rows[i][j] = s.rows[i][j] / 2
+			}
+		}
+		s.additions = 0
+	}
+	s.mu.Unlock()
+}
+
+// estimate returns how often k was used recently.
+func (s *sketch) estimate(k string) uint8 {
+	h := fnvHash(k)
+	s.mu.Lock()
+	min := uint8(sketchMax)
+	for i := range s.rows {
+		if n := s.rows[i][s.index(h, i)]; n < min {
+			min = n
+		}
+	}
+	s.mu.Unlock()
+	return min
+}
+
+// admit reports whether Config.Admission lets k in as a new key: either
+// the cache has room, or k was read at least as often as the item it
+// would evict. Expired items are removed first to make room. The caller
+// must hold the write lock.
+func (c *ObjCache) admit(k string) bool {
+	if c.sketch == nil {
+		return true
+	}
+	if _, ok := c.items[k]; ok || c.counted() < c.config.MaxEntryLimit {
+		return true
+	}
+	c.removeExpired()
+	if c.counted() < c.config.MaxEntryLimit {
+		return true
+	}
+	victim := c.victim(nil)
+	if victim == nil {
+		return true
+	}
+	return c.sketch.estimate(k) >= c.sketch.estimate(victim.Value.(*pair).key)
+}
@@ -0,0 +1,79 @@
+package objcache
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestAdmission(t *testing.T) {
+	for _, admission := range []bool{false, true} {
+		cache, err := New(Config{MaxEntryLimit: 100, Admission: admission})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 100; i = i + 1 {
+			k := "hot" + strconv.Itoa(i)
+			cache.Set(k, i, 0)
+			for j := 0; j < 3; j = j + 1 {
+				cache.Get(k)
+			}
+		}
+
+		// A scan reads many cold keys once each.
+		rejected := 0
+		for i := 0; i < 500; i = i + 1 {
+			k := "cold" + strconv.Itoa(i)
+			if _, ok := cache.Get(k); !ok {
+				if err := cache.Set(k, i, 0); errors.Is(err, ErrNotAdmitted) {
+					rejected = rejected + 1
+				}
+			}
+		}
+
+		hot := 0
+		for i := 0; i < 100; i = i + 1 {
+			if cache.Has("hot" + strconv.Itoa(i)) {
+				hot = hot + 1
+			}
+		}
+		// The sketch is approximate, so a cold key may collide with hot ones.
+		if admission && (hot < 90 || rejected < 450) {
+			t.Fatalf("expected the hot keys to survive the scan, got %d hot, %d rejected", hot, rejected)
+		}
+		if !admission && hot != 0 {
+			t.Fatalf("expected the scan to flush the hot keys without Admission, got %d", hot)
+		}
+		cache.Close()
+	}
+}
+
+func TestAdmissionAdmitsPopularKeys(t *testing.T) {
+	cache, err := New(Config{MaxEntryLimit: 2, Admission: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	cache.Set("a", 1, 0)
+	cache.Set("b", 2, 0)
+	cache.Get("a")
+	cache.Get("b")
+	if err := cache.Set("c", 3, 0); !errors.Is(err, ErrNotAdmitted) {
+		t.Fatalf("expected ErrNotAdmitted, got %v", err)
+	}
+	// c is now read more often than a, the item it would evict.
+	for i := 0; i < 2; i = i + 1 {
+		cache.Get("c")
+	}
+	if err := cache.Set("c", 3, 0); err != nil {
+		t.Fatalf("expected c to be admitted, got %v", err)
+	}
+	if cache.Has("a") || !cache.Has("b") || !cache.Has("c") {
+		t.Fatal("expected a to be evicted for c")
+	}
+	// Existing keys are always set.
+	if err := cache.Set("b", 4, 0); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,125 +1,2060 @@
 package objcache
 
 import (
+	"container/heap"
 	"container/list"
+	"math"
+	"math/rand"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// NoExpiration is a duration for items that never expire. Such items are
+// only removed by Del or capacity eviction. Any negative duration is
+// treated the same way.
+const NoExpiration time.Duration = -1
+
+// neverExpire is the expire of items set with NoExpiration.
+const neverExpire = math.MaxInt64
+
+// maxExpire is the latest expire of an item that expires. Durations
+// reaching past it are clamped to it, so they do not overflow, and the
+// item is still told apart from one set with NoExpiration.
+const maxExpire = neverExpire - 1
+
 type pair struct {
 	Object interface{}
 	expire int64
 	key    string
+	index  int
+	size   int64
+	cost   int64
+
+	// ttl is the duration the item was set for, used by
+	// SlidingExpiration.
+	ttl time.Duration
+
+	// freq counts the Get hits for PolicyLFU and AccessCount. It is
+	// updated atomically under the read lock.
+	freq int64
+
+	// tags are the tags given by SetWithTags.
+	tags []string
+
+	// version is the value of ObjCache.version when the object was set.
+	version uint64
+
+	// skey is the secondary key given by Config.IndexFunc, if indexed.
+	skey    string
+	indexed bool
+
+	// vhash is the hash of the object shared with Config.DedupValues, if
+	// interned.
+	vhash    uint64
+	interned bool
+
+	// lastAccess is the time of the last set or Get hit in nanoseconds,
+	// used by sampled eviction and LastAccess. It is updated atomically
+	// under the read lock.
+	lastAccess int64
+
+	// created is the time the object was set in nanoseconds, for
+	// OldestAge and NewestAge.
+	created int64
+
+	// seq is the value of ObjCache.seq when the key was inserted. It
+	// breaks ties between items expiring or sampled for eviction at the
+	// same time, in insertion order.
+	seq uint64
+
+	// soft is the soft deadline of SetWithSoftHard, after which the item
+	// is stale until expire, or 0. softTTL is its duration.
+	soft    int64
+	softTTL time.Duration
+
+	// pinned is whether Pin exempts the item from expiration and
+	// eviction. A pinned item never expires, and ttl is applied again by
+	// Unpin.
+	pinned bool
+}
+
+// pairPool recycles the pairs of removed items, so a Set after an
+// eviction does not need to allocate one. The list elements cannot be
+// recycled, as container/list makes a new one for each insertion.
+var pairPool = sync.Pool{
+	New: func() interface{} { return new(pair) },
+}
+
+// eviction is a removed item waiting for the OnEvicted callback.
+type eviction struct {
+	key    string
+	value  interface{}
+	reason EvictReason
+	expire int64
+	ttl    time.Duration
+
+	// expired is whether Config.OnExpired is called for the item.
+	expired bool
 }
 
+// reasonMoved is for an item moved to another cache. OnEvicted is not
+// called for it.
+const reasonMoved EvictReason = -1
+
 // ObjCache is a struct for managing cache.
 // If a user call objcache.New(), returns an instance of this struct.
 type ObjCache struct {
 	mu        sync.RWMutex
 	items     map[string]*list.Element
 	list      *list.List
+	heap      expireHeap
 	itemCount int
+	bytes     int64
+	cost      int64
 	config    Config
+	clock     Clock
+	rand      *rand.Rand
+	stats     counters
+	events    events
+
+	// buckets count the recent hits and misses for RecentHitRatio. They
+	// are nil unless Config.StatsBucket is set.
+	buckets []bucket
+
+	// evicted is filled under the write lock and handed to OnEvicted by
+	// unlock once the lock is released.
+	evicted []eviction
+
+	// published are the keys written under the write lock, published to
+	// Config.Invalidator by unlock once the lock is released.
+	published []string
+
+	// logs are the records logged under the write lock, handed to
+	// Config.Logger by unlock.
+	logs []logRecord
+
+	// free holds the pairs removed under the write lock. unlock clears
+	// them and puts them back into pairPool, so they can be read until
+	// then.
+	free []*pair
+
+	// demote receives the items evicted for capacity instead of
+	// OnEvicted. It is used by TieredCache.
+	demote func(e eviction)
+
+	// tags maps each tag of SetWithTags to its keys.
+	tags map[string]map[string]struct{}
+
+	// secondary maps the secondary keys of Config.IndexFunc to keys.
+	secondary map[string]string
+
+	// version counts the objects set, for GetVersioned.
+	version uint64
+
+	// seq counts the keys inserted.
+	seq uint64
+
+	// shared are the objects shared by keys with Config.DedupValues, by
+	// their hash.
+	shared map[uint64]*shared
+
+	// notified is the time of the last OnExpired call of each key, for
+	// Config.ExpiredDebounce. It is cleaned up when it grows past
+	// notifiedMax.
+	notified    map[string]int64
+	notifiedMax int
+
+	// result collects what SetWithResult removes while it holds the
+	// write lock. It is nil otherwise.
+	result *SetResult
+
+	// sketch counts the uses of keys for Config.Admission. It is nil
+	// without it.
+	sketch *sketch
+
+	// space is closed when an item is removed, to wake the callers of
+	// WaitForSpace. It is nil while nobody waits.
+	space chan struct{}
+
+	// readOnly rejects writes and pauses the removal of expired items.
+	// See SetReadOnly.
+	readOnly bool
+
+	// aboveHigh is whether the item count was at or above the high-water
+	// mark when last checked.
+	aboveHigh bool
+
+	// loads are the loads in flight of GetWithLoader.
+	loadMu sync.Mutex
+	loads  map[string]*call
+
+	// negatives are the loader errors cached by GetOrCompute. They are
+	// guarded by loadMu.
+	negatives map[string]negativeEntry
+
+	// loadSlots holds a value for each loader running, up to
+	// Config.MaxConcurrentLoads. It is nil without a limit.
+	loadSlots chan struct{}
+
+	// writeBehind is the queue of writes for Config.WriteBehind.
+	writeBehind writeBehind
+
+	// coarseNow is the time of the clock in nanoseconds, updated every
+	// Config.ClockResolution. It is only used with a ClockResolution.
+	coarseNow int64
+
+	// pinned is the number of pinned items, which do not count against
+	// Config.MaxEntryLimit.
+	pinned int
+
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
-func (c *ObjCache) removeExpired() {
-	e := time.Now().UnixNano()
-	for {
-		elem := c.list.Front()
-		if elem == nil {
-			break
+// removeExpired removes all expired items past Config.StaleGrace and
+// returns how many.
+func (c *ObjCache) removeExpired() int {
+	if c.readOnly {
+		return 0
+	}
+	e := c.now() - int64(c.config.StaleGrace)
+	n := 0
+	for len(c.heap) > 0 && c.heap[0].expire < e {
+		k := c.heap[0].key
+		c.remove(c.items[k], ReasonExpired)
+		atomic.AddInt64(&c.stats.expirations, 1)
+		if c.result != nil {
+			c.result.ExpiredSwept = c.result.ExpiredSwept + 1
 		}
-		v := elem.Value.(pair)
-		if v.expire < e {
-			c.itemCount = c.itemCount - 1
-			delete(c.items, v.key)
-			c.list.Remove(elem)
-		} else {
+		c.emit(EventExpire, k)
+		n = n + 1
+	}
+	return n
+}
+
+// MaxEvictScan is the number of items at the front of the LRU list
+// tried for eviction when Config.CanEvict keeps them.
+const MaxEvictScan = 64
+
+// removeOldest evicts one item chosen by Config.Policy, other than keep
+// and the items Config.CanEvict keeps. Ties are broken deterministically:
+// PolicyLFU evicts the least recently used of the least frequent items,
+// and SampleSize the first inserted of the sampled items accessed last at
+// the same time. It returns false if there is no item to evict.
+func (c *ObjCache) removeOldest(keep *list.Element) bool {
+	elem := c.victim(keep)
+	if elem == nil {
+		return false
+	}
+	c.remove(elem, ReasonCapacity)
+	c.countEviction()
+	if c.result != nil {
+		if c.result.Evicted == 0 {
+			c.result.EvictedKey = elem.Value.(*pair).key
+		}
+		c.result.Evicted = c.result.Evicted + 1
+	}
+	c.emit(EventEvict, elem.Value.(*pair).key)
+	return true
+}
+
+// victim returns the item removeOldest would evict, or nil.
+func (c *ObjCache) victim(keep *list.Element) *list.Element {
+	if c.config.Policy == PolicyLFU {
+		return c.leastFrequent(keep)
+	} else if c.config.SampleSize > 0 {
+		return c.oldestSample(keep)
+	}
+	return c.oldest(keep)
+}
+
+// oldest returns the front element of the LRU list other than keep that
+// can be evicted, trying at most MaxEvictScan elements.
+func (c *ObjCache) oldest(keep *list.Element) *list.Element {
+	n := 0
+	for elem := c.list.Front(); elem != nil && n < MaxEvictScan; elem = elem.Next() {
+		if elem == keep || elem.Value.(*pair).pinned {
+			continue
+		}
+		if c.canEvict(elem.Value.(*pair)) {
+			return elem
+		}
+		n = n + 1
+	}
+	return nil
+}
+
+// canEvict reports whether v is not pinned and Config.CanEvict lets it be
+// evicted. An item is kept if CanEvict panics.
+func (c *ObjCache) canEvict(v *pair) bool {
+	if v.pinned {
+		return false
+	}
+	if c.config.CanEvict == nil {
+		return true
+	}
+	ok := false
+	c.guard(func() { ok = c.config.CanEvict(v.key, v.Object) })
+	return ok
+}
+
+// leastFrequent returns the least frequently used element other than
+// keep. Among equal frequencies the least recently used one is chosen.
+// It scans the whole list, so eviction with PolicyLFU is O(n).
+func (c *ObjCache) leastFrequent(keep *list.Element) *list.Element {
+	var min *list.Element
+	var minFreq int64
+	for elem := c.list.Front(); elem != nil; elem = elem.Next() {
+		if elem == keep || !c.canEvict(elem.Value.(*pair)) {
+			continue
+		}
+		freq := atomic.LoadInt64(&elem.Value.(*pair).freq)
+		if min == nil || freq < minFreq {
+			min = elem
+			minFreq = freq
+		}
+	}
+	return min
+}
+
+// oldestSample returns the least recently accessed of Config.SampleSize
+// elements other than keep. The sample is taken in map order, which is
+// random, so this only approximates LRU. Of elements accessed at the same
+// time, the one inserted first is returned.
+func (c *ObjCache) oldestSample(keep *list.Element) *list.Element {
+	var oldest *list.Element
+	var oldestAccess int64
+	n := 0
+	for _, elem := range c.items {
+		if elem == keep || !c.canEvict(elem.Value.(*pair)) {
+			continue
+		}
+		access := atomic.LoadInt64(&elem.Value.(*pair).lastAccess)
+		if oldest == nil || access < oldestAccess || (access == oldestAccess && elem.Value.(*pair).seq < oldest.Value.(*pair).seq) {
+			oldest = elem
+			oldestAccess = access
+		}
+		n = n + 1
+		if n >= c.config.SampleSize {
 			break
 		}
 	}
+	return oldest
 }
 
-func (c *ObjCache) removeOldest() {
+// remove deletes elem from the map, the list and the heap.
+func (c *ObjCache) remove(elem *list.Element, reason EvictReason) {
+	v := elem.Value.(*pair)
 	c.itemCount = c.itemCount - 1
-	elem := c.list.Front()
-	v := elem.Value.(pair)
+	if v.pinned {
+		c.pinned = c.pinned - 1
+	}
+	c.bytes = c.bytes - v.size
+	c.cost = c.cost - v.cost
 	delete(c.items, v.key)
+	c.untag(v)
+	c.unindex(v)
+	c.unintern(v)
 	c.list.Remove(elem)
+	heap.Remove(&c.heap, v.index)
+	c.evicting(v, reason)
+	c.free = append(c.free, v)
+	c.notifySpace()
+}
+
+// evicting queues the OnEvicted callback for the object of v leaving the
+// cache.
+func (c *ObjCache) evicting(v *pair, reason EvictReason) {
+	if reason == reasonMoved || (c.config.OnEvicted == nil && c.demote == nil && c.config.OnExpired == nil && c.config.Logger == nil) {
+		return
+	}
+	c.evicted = append(c.evicted, eviction{
+		key:     v.key,
+		value:   v.Object,
+		reason:  reason,
+		expire:  v.expire,
+		ttl:     v.ttl,
+		expired: reason == ReasonExpired && c.notifyExpired(v.key),
+	})
+}
+
+// evict calls OnEvicted for e, or demote for an item evicted for
+// capacity if it is set.
+func (c *ObjCache) evict(e eviction) {
+	defer c.recoverCallback()
+	if e.reason == ReasonCapacity && c.demote != nil {
+		c.demote(e)
+	} else if c.config.OnEvicted != nil {
+		c.config.OnEvicted(e.key, e.value, e.reason)
+	}
+}
+
+// unlock releases the write lock, then calls OnEvicted for the items
+// removed while it was held. Items evicted for capacity are handed to
+// demote instead if it is set. OnHighWater or OnLowWater is called last
+// if the item count crossed the high-water mark.
+func (c *ObjCache) unlock() {
+	evicted := c.evicted
+	c.evicted = nil
+	logs := c.logs
+	c.logs = nil
+	published := c.published
+	c.published = nil
+	water := c.water()
+	for i, p := range c.free {
+		*p = pair{}
+		pairPool.Put(p)
+		c.free[i] = nil
+	}
+	c.free = c.free[:0]
+	c.mu.Unlock()
+	for _, r := range logs {
+		c.log(r.level, r.msg, r.fields)
+	}
+	for _, e := range evicted {
+		if e.reason == ReasonCapacity {
+			c.log(LevelDebug, "objcache: evicted", map[string]interface{}{"key": e.key})
+		}
+		c.evict(e)
+		if e.expired {
+			c.guard(func() { c.config.OnExpired(e.key, e.value) })
+		}
+	}
+	for _, k := range published {
+		c.publish(k)
+	}
+	if water != nil {
+		c.guard(water)
+	}
 }
 
 // Set a value for key. if d is 0, the Expiration time would be default time.
 func (c *ObjCache) Set(k string, x interface{}, d time.Duration) error {
-	if d == 0 {
-		d = c.config.Expiration
+	if err := c.checkKey(k); err != nil {
+		return err
 	}
 	c.mu.Lock()
+	_, err := c.setThrough(k, x, c.sizeOf(x), c.expireAt(d), d)
+	c.unlock()
+	return err
+}
 
-	if _, ok := c.items[k]; !ok {
+// SetResult is what SetWithResult did.
+type SetResult struct {
+	// Inserted is whether the key was new, or had expired. Updated is
+	// whether a live item was overwritten. Both are false if Err is set.
+	Inserted bool
+	Updated  bool
 
-		c.removeExpired()
+	// EvictedKey is the first key evicted for capacity to make room, and
+	// Evicted is how many were, as with Config.LowWaterMark it may be more
+	// than one.
+	EvictedKey string
+	Evicted    int
+
+	// ExpiredSwept is the number of expired items removed.
+	ExpiredSwept int
+
+	// Err is the error Set would have returned.
+	Err error
+}
 
-		if c.itemCount >= c.config.MaxEntryLimit {
-			c.removeOldest()
+// SetWithResult is Set, but it reports what was inserted or removed by
+// the call.
+func (c *ObjCache) SetWithResult(k string, x interface{}, d time.Duration) SetResult {
+	var r SetResult
+	if r.Err = c.checkKey(k); r.Err != nil {
+		return r
+	}
+	c.mu.Lock()
+	elem, existed := c.items[k]
+	existed = existed && !c.expired(elem.Value.(*pair))
+	c.result = &r
+	_, r.Err = c.setThrough(k, x, c.sizeOf(x), c.expireAt(d), d)
+	c.result = nil
+	c.unlock()
+	if r.Err != nil {
+		return r
+	}
+	r.Inserted = !existed
+	r.Updated = existed
+	return r
+}
+
+// MSet sets all items with the same duration under one lock. Keys longer
+// than Config.MaxKeyLen and items Config.Store fails to set are skipped.
+// If the new items do not fit, room is made for all of them at once, down
+// to Config.LowWaterMark.
+func (c *ObjCache) MSet(items map[string]interface{}, d time.Duration) {
+	c.mu.Lock()
+	if c.writable() != nil {
+		c.unlock()
+		return
+	}
+	c.removeExpired()
+	n := 0
+	for k := range items {
+		if _, ok := c.items[k]; !ok && c.checkKey(k) == nil {
+			n = n + 1
+		}
+	}
+	c.makeRoom(n)
+	for k, x := range items {
+		if c.checkKey(k) != nil {
+			continue
 		}
+		c.setThrough(k, x, c.sizeOf(x), c.expireAt(d), d)
+	}
+	c.unlock()
+}
+
+// Entry is an object with its own duration, as for Set. Key is filled
+// in by ItemsInOrder; SetMany and ReplaceAll take the keys of their map
+// instead.
+type Entry struct {
+	Key   string
+	Value interface{}
+	TTL   time.Duration
+}
 
-		p := pair{
-			Object: x,
-			key:    k,
-			expire: time.Now().Add(d).UnixNano(),
+// SetMany sets all entries with their own durations under one lock. Keys
+// longer than Config.MaxKeyLen and entries Config.Store fails to set are
+// skipped. Room is made like for MSet.
+func (c *ObjCache) SetMany(entries map[string]Entry) {
+	c.mu.Lock()
+	if c.writable() != nil {
+		c.unlock()
+		return
+	}
+	c.removeExpired()
+	n := 0
+	for k := range entries {
+		if _, ok := c.items[k]; !ok && c.checkKey(k) == nil {
+			n = n + 1
 		}
-		c.items[k] = c.list.PushBack(p)
-		c.itemCount = c.itemCount + 1
-	} else {
-		c.list.MoveToBack(c.items[k])
 	}
+	c.makeRoom(n)
+	for k, e := range entries {
+		if c.checkKey(k) != nil {
+			continue
+		}
+		c.setThrough(k, e.Value, c.sizeOf(e.Value), c.expireAt(e.TTL), e.TTL)
+	}
+	c.unlock()
+}
 
-	c.mu.Unlock()
+// ReplaceAll replaces all items of the cache with entries under one lock,
+// so readers see either all the old items or all the new ones. OnEvicted
+// is called for the old items, with ReasonReplaced for the keys set again
+// and ReasonDeleted for the others. If the entries do not fit, some are
+// evicted right away. Keys longer than Config.MaxKeyLen are skipped, and
+// the entries are not written to Config.Store. While the cache is
+// read-only, nothing is replaced.
+func (c *ObjCache) ReplaceAll(entries map[string]Entry) {
+	c.mu.Lock()
+	if c.writable() != nil {
+		c.unlock()
+		return
+	}
+	for elem := c.list.Front(); elem != nil; elem = elem.Next() {
+		v := elem.Value.(*pair)
+		if _, ok := entries[v.key]; c.expired(v) {
+			c.evicting(v, ReasonExpired)
+		} else if ok {
+			c.evicting(v, ReasonReplaced)
+		} else {
+			c.evicting(v, ReasonDeleted)
+		}
+	}
+	c.clear()
+	for k, e := range entries {
+		if c.checkKey(k) != nil || c.full(k) {
+			continue
+		}
+		c.set(k, e.Value, c.sizeOf(e.Value), e.TTL)
+	}
+	c.unlock()
+}
+
+// WarmEntry is an item to seed the cache with by Warm.
+type WarmEntry struct {
+	Key   string
+	Value interface{}
+	TTL   time.Duration
+}
+
+// Warm sets entries in order under one lock, as for Set, but without
+// writing them to Config.Store. If they do not fit in MaxEntryLimit, the
+// items are evicted as usual, so with PolicyLRU the last entries are
+// kept. Keys longer than Config.MaxKeyLen are skipped.
+func (c *ObjCache) Warm(entries []WarmEntry) {
+	c.mu.Lock()
+	if c.writable() != nil {
+		c.unlock()
+		return
+	}
+	for _, e := range entries {
+		if c.checkKey(e.Key) != nil || c.full(e.Key) {
+			continue
+		}
+		c.set(e.Key, e.Value, c.sizeOf(e.Value), e.TTL)
+	}
+	c.unlock()
+}
+
+// SetWithSize sets a value for key like Set, with size bytes counted
+// against Config.MaxBytes instead of the result of Config.Sizer.
+func (c *ObjCache) SetWithSize(k string, x interface{}, size int64, d time.Duration) error {
+	if err := c.checkKey(k); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	_, err := c.setThrough(k, x, size, c.expireAt(d), d)
+	c.unlock()
+	return err
+}
+
+// SetWithCost sets a value for key like Set, with cost counted against
+// Config.MaxCost. Items are evicted until the total cost fits. It returns
+// ErrCostTooHigh without changing the cache if cost alone exceeds MaxCost.
+func (c *ObjCache) SetWithCost(k string, x interface{}, cost int64, d time.Duration) error {
+	if err := c.checkKey(k); err != nil {
+		return err
+	}
+	if c.config.MaxCost > 0 && cost > c.config.MaxCost {
+		return ErrCostTooHigh
+	}
+	c.mu.Lock()
+	elem, err := c.setThrough(k, x, c.sizeOf(x), c.expireAt(d), d)
+	if err != nil {
+		c.unlock()
+		return err
+	}
+	p := elem.Value.(*pair)
+	p.cost = cost
+	c.cost = c.cost + cost
+	for c.config.MaxCost > 0 && c.cost > c.config.MaxCost && c.removeOldest(elem) {
+	}
+	c.unlock()
 	return nil
 }
 
-// Get the object of key.
-func (c *ObjCache) Get(k string) (interface{}, bool) {
-	c.mu.RLock()
+// SetWithDeadline sets a value for key that expires at deadline. It
+// returns ErrPastDeadline without changing the cache if deadline has
+// passed.
+func (c *ObjCache) SetWithDeadline(k string, x interface{}, deadline time.Time) error {
+	if err := c.checkKey(k); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	now := time.Unix(0, c.now())
+	if !deadline.After(now) {
+		c.unlock()
+		return ErrPastDeadline
+	}
+	expire := int64(maxExpire)
+	if deadline.Before(time.Unix(0, maxExpire)) {
+		expire = deadline.UnixNano()
+	}
+	_, err := c.setThrough(k, x, c.sizeOf(x), expire, deadline.Sub(now))
+	c.unlock()
+	return err
+}
+
+// SetWithSoftHard sets a value for key that goes stale after soft and
+// expires after hard, which is like d of Set. Between the two, Get still
+// returns it, GetAllowStale reports it as stale, and with Config.Reload
+// it is reloaded in the background like with RefreshAhead. If soft is not
+// positive or not shorter than hard, it is Set for hard.
+func (c *ObjCache) SetWithSoftHard(k string, x interface{}, soft, hard time.Duration) error {
+	if err := c.checkKey(k); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	expire := c.expireAt(hard)
+	elem, err := c.setThrough(k, x, c.sizeOf(x), expire, hard)
+	if err == nil && soft > 0 && addExpire(c.now(), soft) < expire {
+		p := elem.Value.(*pair)
+		p.soft = addExpire(c.now(), soft)
+		p.softTTL = soft
+	}
+	c.unlock()
+	return err
+}
+
+// stale reports whether v is past its soft deadline.
+func (c *ObjCache) stale(v *pair) bool {
+	return v.soft != 0 && v.soft < c.now()
+}
+
+// Add a value for key only if the key is not in the cache or has expired.
+// Otherwise it returns ErrKeyExists.
+func (c *ObjCache) Add(k string, x interface{}, d time.Duration) error {
+	if err := c.checkKey(k); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	if err := c.writable(); err != nil {
+		c.unlock()
+		return err
+	}
+	if elem, ok := c.items[k]; ok && !c.expired(elem.Value.(*pair)) {
+		c.unlock()
+		return ErrKeyExists
+	}
+	_, err := c.setThrough(k, x, c.sizeOf(x), c.expireAt(d), d)
+	c.unlock()
+	return err
+}
+
+// Replace the value for key only if the key is in the cache and has not
+// expired. Otherwise it returns ErrKeyNotFound.
+func (c *ObjCache) Replace(k string, x interface{}, d time.Duration) error {
+	if err := c.checkKey(k); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	if err := c.writable(); err != nil {
+		c.unlock()
+		return err
+	}
+	if elem, ok := c.items[k]; !ok || c.expired(elem.Value.(*pair)) {
+		c.unlock()
+		return ErrKeyNotFound
+	}
+	_, err := c.setThrough(k, x, c.sizeOf(x), c.expireAt(d), d)
+	c.unlock()
+	return err
+}
+
+// CompareAndSwap sets new for key only if its current object is deeply
+// equal to old. It returns false if the objects differ or the key is not
+// in the cache, or if Config.Store fails.
+func (c *ObjCache) CompareAndSwap(k string, old, new interface{}, d time.Duration) bool {
+	c.mu.Lock()
 	elem, ok := c.items[k]
+	if !ok || c.expired(elem.Value.(*pair)) || !reflect.DeepEqual(elem.Value.(*pair).Object, old) {
+		c.unlock()
+		return false
+	}
+	_, err := c.setThrough(k, new, c.sizeOf(new), c.expireAt(d), d)
+	c.unlock()
+	return err == nil
+}
+
+// Update calls fn with the object of key and whether it is in the cache,
+// then stores the object fn returns if keep is true, or deletes the key
+// if keep is false, all under the write lock. An existing item keeps its
+// expiration, a new one gets Config.Expiration. It returns whether the
+// key holds the object of fn afterwards, which is false if keep is false
+// or the write failed. fn must not use the cache.
+func (c *ObjCache) Update(k string, fn func(old interface{}, found bool) (new interface{}, keep bool)) bool {
+	if c.checkKey(k) != nil || c.closed() {
+		return false
+	}
+	c.mu.Lock()
+	var old interface{}
+	expire, ttl := int64(0), time.Duration(0)
+	elem, found := c.items[k]
+	if found && c.expired(elem.Value.(*pair)) {
+		found = false
+	} else if found {
+		p := elem.Value.(*pair)
+		old, expire, ttl = c.copyOut(p.Object), p.expire, p.ttl
+	}
+
+	var x interface{}
+	keep, ok := false, false
+	c.guard(func() {
+		x, keep = fn(old, found)
+		ok = true
+	})
 	if !ok {
-		c.mu.RUnlock()
-		return nil, false
+		c.unlock()
+		return false
+	}
+	if !keep {
+		if found {
+			ok, err := c.delThrough(k)
+			c.observeDelete(k, ok && err == nil)
+		}
+		c.unlock()
+		return false
+	}
+	if !found {
+		expire = c.expireAt(0)
+	}
+	_, err := c.setThrough(k, x, c.sizeOf(x), expire, ttl)
+	c.unlock()
+	return err == nil
+}
+
+// Increment adds n to the int64 object of key and returns the new value.
+// The expiration of the item is not changed. It returns ErrKeyNotFound if
+// the key is not in the cache and ErrNotInt64 if the object is not int64,
+// unless Config.OnTypeMismatch says otherwise.
+func (c *ObjCache) Increment(k string, n int64) (int64, error) {
+	c.mu.Lock()
+	if err := c.writable(); err != nil {
+		c.unlock()
+		return 0, err
+	}
+	elem, ok := c.items[k]
+	if !ok || c.expired(elem.Value.(*pair)) {
+		c.unlock()
+		return 0, ErrKeyNotFound
+	}
+	v := elem.Value.(*pair)
+	i, ok := v.Object.(int64)
+	if !ok {
+		x := v.Object
+		c.unlock()
+		if c.mismatch(k, x, "int64") {
+			return 0, ErrNotInt64
+		}
+		return 0, ErrKeyNotFound
 	}
-	v := elem.Value.(pair)
+	i = i + n
+	if c.config.Store != nil {
+		if err := c.writeBack(write{key: k, value: i}); err != nil {
+			c.unlock()
+			return 0, err
+		}
+	}
+	c.unindex(v)
+	c.unintern(v)
+	v.Object = i
+	c.version = c.version + 1
+	v.version = c.version
+	c.intern(v)
+	c.index(v)
+	c.invalidated(k)
+	c.unlock()
+	return i, nil
+}
 
-	if v.expire < time.Now().UnixNano() {
-		c.itemCount = c.itemCount - 1
-		delete(c.items, k)
-		c.list.Remove(elem)
-		c.mu.RUnlock()
-		return nil, false
+// SetIfGreater sets x for key only if the key is missing or has expired,
+// or its int64 object is less than x. It returns the object of key after
+// the call and whether x was set. If the object is not an int64, nothing
+// is set and it returns 0 and false, unless Config.OnTypeMismatch says
+// otherwise.
+func (c *ObjCache) SetIfGreater(k string, x int64, d time.Duration) (int64, bool) {
+	if c.checkKey(k) != nil {
+		return 0, false
 	}
-	c.mu.RUnlock()
-	return v.Object, true
+	c.mu.Lock()
+	var old int64
+	if elem, ok := c.items[k]; ok && !c.expired(elem.Value.(*pair)) {
+		v := elem.Value.(*pair)
+		i, ok := v.Object.(int64)
+		if !ok && c.config.OnTypeMismatch != TypeMismatchMiss {
+			y := v.Object
+			c.unlock()
+			c.mismatch(k, y, "int64")
+			return 0, false
+		}
+		if ok && i >= x {
+			c.unlock()
+			return i, false
+		}
+		old = i
+	}
+	if _, err := c.setThrough(k, x, c.sizeOf(x), c.expireAt(d), d); err != nil {
+		c.unlock()
+		return old, false
+	}
+	c.unlock()
+	return x, true
 }
 
-// Del delete an item for some key.
-func (c *ObjCache) Del(k string) bool {
+// Decrement subtracts n from the int64 object of key. See Increment.
+func (c *ObjCache) Decrement(k string, n int64) (int64, error) {
+	return c.Increment(k, -n)
+}
+
+// Touch sets the expiration of key to d from now without changing its
+// object, and moves it to the back of the LRU list. It returns false if
+// the key is not in the cache or has expired.
+func (c *ObjCache) Touch(k string, d time.Duration) bool {
+	c.mu.Lock()
+	elem, ok := c.items[k]
+	if !ok || c.writable() != nil || c.expired(elem.Value.(*pair)) {
+		c.unlock()
+		return false
+	}
+	p := elem.Value.(*pair)
+	if !p.pinned {
+		p.expire = c.expireAt(d)
+	}
+	p.ttl = d
+	heap.Fix(&c.heap, p.index)
+	c.list.MoveToBack(elem)
+	c.unlock()
+	return true
+}
+
+// ExtendAll sets the expiration of all live items to d from now like
+// Touch, without changing the LRU order, and returns how many. Expired
+// items are left to expire. It returns 0 while the cache is read-only.
+func (c *ObjCache) ExtendAll(d time.Duration) int {
 	c.mu.Lock()
-	item, ok := c.items[k]
+	if c.writable() != nil {
+		c.unlock()
+		return 0
+	}
+	n := 0
+	for elem := c.list.Front(); elem != nil; elem = elem.Next() {
+		p := elem.Value.(*pair)
+		if c.expired(p) {
+			continue
+		}
+		if !p.pinned {
+			p.expire = c.expireAt(d)
+		}
+		p.ttl = d
+		n = n + 1
+	}
+	heap.Init(&c.heap)
+	c.unlock()
+	return n
+}
+
+// Refresh is Touch with the default expiration, Config.Expiration. It
+// returns false if the key is not in the cache or has expired.
+func (c *ObjCache) Refresh(k string) bool {
+	return c.Touch(k, 0)
+}
+
+// set stores x of size bytes for k and returns its element. The cost and
+// the access count of an overwritten item are reset to 0. The caller
+// must hold the write lock.
+func (c *ObjCache) set(k string, x interface{}, size int64, d time.Duration) *list.Element {
+	return c.setAt(k, x, size, c.expireAt(d), d)
+}
+
+// setAt is set with the expiration time given. d is kept for
+// SlidingExpiration.
+func (c *ObjCache) setAt(k string, x interface{}, size int64, expire int64, d time.Duration) *list.Element {
+	if c.config.CopyOnGet {
+		x = deepCopy(x)
+	}
+
+	elem, ok := c.items[k]
 	if ok {
-		c.itemCount = c.itemCount - 1
-		delete(c.items, k)
-		c.list.Remove(item)
+		p := elem.Value.(*pair)
+		if c.expired(p) {
+			c.evicting(p, ReasonExpired)
+		} else {
+			c.evicting(p, ReasonReplaced)
+		}
+		c.bytes = c.bytes - p.size + size
+		c.cost = c.cost - p.cost
+		p.cost = 0
+		atomic.StoreInt64(&p.freq, 0)
+		c.unindex(p)
+		c.unintern(p)
+		if p.pinned {
+			expire = neverExpire
+		}
+		p.Object = x
+		p.expire = expire
+		p.size = size
+		p.ttl = d
+		p.created = c.now()
+		p.soft = 0
+		p.softTTL = 0
+		atomic.StoreInt64(&p.lastAccess, c.now())
+		heap.Fix(&c.heap, p.index)
+		c.list.MoveToBack(elem)
+	} else {
+		c.removeExpired()
+
+		// Make room before the item is added, so it is not a candidate.
+		c.makeRoom(1)
+
+		p := pairPool.Get().(*pair)
+		p.Object = x
+		p.key = k
+		p.expire = expire
+		p.size = size
+		p.ttl = d
+		p.lastAccess = c.now()
+		p.created = p.lastAccess
+		c.seq = c.seq + 1
+		p.seq = c.seq
+		elem = c.list.PushBack(p)
+		c.items[k] = elem
+		heap.Push(&c.heap, p)
+		c.itemCount = c.itemCount + 1
+		c.bytes = c.bytes + size
 	}
-	c.mu.Unlock()
-	return ok
+	c.version = c.version + 1
+	elem.Value.(*pair).version = c.version
+	c.intern(elem.Value.(*pair))
+	c.index(elem.Value.(*pair))
+
+	c.emit(EventSet, k)
+	c.observeSet(k)
+
+	// The item just set is never evicted to make room for itself.
+	for c.overLimit() && c.removeOldest(elem) {
+	}
+	return elem
 }
 
-// New makes an cache object and returns it.
-func New(config Config) (*ObjCache, error) {
-	l := list.New()
-	cache := &ObjCache{
-		items:     make(map[string]*list.Element),
-		itemCount: 0,
-		list:      l,
-		config:    config,
+// overLimit reports whether the cache holds more items than
+// Config.MaxEntryLimit or more bytes than Config.MaxBytes.
+func (c *ObjCache) overLimit() bool {
+	if c.config.MaxEntryLimit > 0 && c.counted() > c.config.MaxEntryLimit {
+		return true
+	}
+	return c.config.MaxBytes > 0 && c.bytes > c.config.MaxBytes
+}
+
+// expireAt returns the expiration time of an item set now for d. If d is
+// 0, Config.Expiration is used. d is raised to Config.MinTTL. The caller
+// must hold the write lock.
+func (c *ObjCache) expireAt(d time.Duration) int64 {
+	if d == 0 {
+		d = c.config.Expiration
+	}
+	if d < 0 {
+		return neverExpire
+	}
+	if d < c.config.MinTTL {
+		d = c.config.MinTTL
+	}
+	expire := addExpire(c.now(), d)
+	if c.config.ExpirationJitter > 0 {
+		expire = addExpire(expire, time.Duration(c.rand.Int63n(int64(c.config.ExpirationJitter))))
+	}
+	return expire
+}
+
+// addExpire returns t plus d in nanoseconds, clamped to maxExpire.
+func addExpire(t int64, d time.Duration) int64 {
+	if int64(d) > maxExpire-t {
+		return maxExpire
+	}
+	return t + int64(d)
+}
+
+// checkKey returns ErrKeyTooLong if k is longer than Config.MaxKeyLen.
+func (c *ObjCache) checkKey(k string) error {
+	if c.config.MaxKeyLen > 0 && len(k) > c.config.MaxKeyLen {
+		return ErrKeyTooLong
+	}
+	return nil
+}
+
+// sizeOf returns the size of x given by Config.Sizer, or 0 without Sizer.
+func (c *ObjCache) sizeOf(x interface{}) int64 {
+	if c.config.Sizer == nil {
+		return 0
+	}
+	var size int64
+	c.guard(func() { size = c.config.Sizer(x) })
+	return size
+}
+
+func (c *ObjCache) expired(v *pair) bool {
+	return v.expire < c.now()
+}
+
+// Get the object of key. A nil object is cached like any other and
+// returned with true, so setting nil can record that a lookup found
+// nothing, and a miss is still told apart by the false.
+func (c *ObjCache) Get(k string) (interface{}, bool) {
+	if c.sketch != nil {
+		c.sketch.add(k)
+	}
+	v, ok := c.lookup(k, true)
+	c.observeGet(k, ok)
+	c.count(ok)
+	if !ok {
+		c.emit(EventMiss, k)
+		return nil, false
+	}
+	c.emit(EventHit, k)
+	c.refreshAhead(k, &v)
+	return c.copyOut(v.Object), true
+}
+
+// GetAllowStale returns the object of key even if it has expired, as long
+// as it is still in the cache, with stale true for an expired one. With
+// Config.StaleGrace expired items stay that long. Like Peek, it does not
+// count as an access.
+func (c *ObjCache) GetAllowStale(k string) (value interface{}, stale bool, ok bool) {
+	if c.closed() {
+		return nil, false, false
+	}
+	c.mu.RLock()
+	elem, ok := c.items[k]
+	if !ok {
+		c.mu.RUnlock()
+		return nil, false, false
+	}
+	p := elem.Value.(*pair)
+	x, stale := p.Object, c.expired(p) || c.stale(p)
+	c.mu.RUnlock()
+	return c.copyOut(x), stale, true
+}
+
+// GetOrDefault returns the object of key like Get, or def if the key is
+// missing or has expired. def is not set in the cache. A cached nil is
+// returned as nil, not def.
+func (c *ObjCache) GetOrDefault(k string, def interface{}) interface{} {
+	x, ok := c.Get(k)
+	if !ok {
+		return def
+	}
+	return x
+}
+
+// GetBytes returns the []byte object of key like Get. It returns nil and
+// false if the key is missing or its object is not a []byte, or panics
+// with TypeMismatchPanic.
+func (c *ObjCache) GetBytes(k string) ([]byte, bool) {
+	x, ok := c.Get(k)
+	if !ok {
+		return nil, false
+	}
+	b, ok := x.([]byte)
+	if !ok {
+		c.mismatch(k, x, "[]byte")
+	}
+	return b, ok
+}
+
+// SetBytes sets b for key like Set.
+func (c *ObjCache) SetBytes(k string, b []byte, d time.Duration) error {
+	return c.Set(k, b, d)
+}
+
+// MGet returns the objects of keys under one lock. Missing and expired
+// keys are left out of the result.
+func (c *ObjCache) MGet(keys []string) map[string]interface{} {
+	found, _ := c.GetMulti(keys)
+	return found
+}
+
+// GetMulti is MGet that also returns the missing and expired keys, in the
+// order of keys. With Config.TouchOnGet or SlidingExpiration the hits are
+// moved or extended as by Get.
+func (c *ObjCache) GetMulti(keys []string) (found map[string]interface{}, missing []string) {
+	found = make(map[string]interface{}, len(keys))
+	if c.closed() {
+		return found, append(missing, keys...)
+	}
+	// SlidingExpiration and TouchOnGet need the write lock, as for Get.
+	locked := c.config.SlidingExpiration || c.config.TouchOnGet
+	now := c.now()
+	if locked {
+		c.mu.Lock()
+	} else {
+		c.mu.RLock()
+	}
+	for _, k := range keys {
+		var x interface{}
+		hit := false
+		if locked {
+			var v pair
+			v, hit = c.lookupHeld(k)
+			x = v.Object
+		} else if elem, ok := c.items[k]; ok && elem.Value.(*pair).expire >= now {
+			p := elem.Value.(*pair)
+			c.accessed(p)
+			x, hit = p.Object, true
+		}
+		c.observeGet(k, hit)
+		c.count(hit)
+		if !hit {
+			missing = append(missing, k)
+			continue
+		}
+		found[k] = c.copyOut(x)
+	}
+	if locked {
+		c.unlock()
+	} else {
+		c.mu.RUnlock()
+	}
+	return found, missing
+}
+
+// GetWithExpiration returns the object of key and its expiration time.
+// The expiration time is zero for an item set with NoExpiration.
+func (c *ObjCache) GetWithExpiration(k string) (interface{}, time.Time, bool) {
+	v, ok := c.lookup(k, true)
+	if !ok {
+		return nil, time.Time{}, false
+	}
+	if v.expire == neverExpire {
+		return c.copyOut(v.Object), time.Time{}, true
+	}
+	return c.copyOut(v.Object), time.Unix(0, v.expire), true
+}
+
+// GetVersioned returns the object of key with its version. Each object
+// set gets a new, greater version, so a changed version tells that the
+// object was set again between two reads. Touch and Get keep the
+// version.
+func (c *ObjCache) GetVersioned(k string) (interface{}, uint64, bool) {
+	v, ok := c.lookup(k, true)
+	if !ok {
+		return nil, 0, false
+	}
+	return c.copyOut(v.Object), v.version, true
+}
+
+// SetVersioned sets a value for key like Set and returns its version.
+func (c *ObjCache) SetVersioned(k string, x interface{}, d time.Duration) (uint64, error) {
+	if err := c.checkKey(k); err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	elem, err := c.setThrough(k, x, c.sizeOf(x), c.expireAt(d), d)
+	if err != nil {
+		c.unlock()
+		return 0, err
+	}
+	version := elem.Value.(*pair).version
+	c.unlock()
+	return version, nil
+}
+
+// GetOrSet returns the object of key if it is in the cache. Otherwise it
+// calls fn, stores the result and returns it. The returned bool is true
+// when the object was already cached. If fn fails nothing is stored.
+// fn is called with the write lock held, so it must not use the cache.
+func (c *ObjCache) GetOrSet(k string, d time.Duration, fn func() (interface{}, error)) (interface{}, bool, error) {
+	if err := c.checkKey(k); err != nil {
+		return nil, false, err
+	}
+	if c.closed() {
+		return nil, false, ErrCacheClosed
+	}
+	c.mu.Lock()
+	if elem, ok := c.items[k]; ok {
+		v := elem.Value.(*pair)
+		if !c.expired(v) {
+			x := c.copyOut(v.Object)
+			c.unlock()
+			return x, true, nil
+		}
+	}
+	if err := c.writable(); err != nil {
+		c.unlock()
+		return nil, false, err
+	}
+
+	x, err := c.call(fn)
+	if err != nil {
+		c.unlock()
+		return nil, false, err
+	}
+	if _, err := c.setThrough(k, x, c.sizeOf(x), c.expireAt(d), d); err != nil {
+		c.unlock()
+		return nil, false, err
+	}
+	c.unlock()
+	return x, false, nil
+}
+
+// AccessCount returns the number of Get hits of key since it was set, and
+// whether it is in the cache. The count is kept by Touch, but reset to 0
+// when the key is set again.
+func (c *ObjCache) AccessCount(k string) (int64, bool) {
+	now := c.now()
+	c.mu.RLock()
+	elem, ok := c.items[k]
+	if !ok || c.closed() || elem.Value.(*pair).expire < now {
+		c.mu.RUnlock()
+		return 0, false
+	}
+	n := atomic.LoadInt64(&elem.Value.(*pair).freq)
+	c.mu.RUnlock()
+	return n, true
+}
+
+// Peek returns the object of key like Get, but does not count the access
+// for the eviction policy or the stats.
+func (c *ObjCache) Peek(k string) (interface{}, bool) {
+	v, ok := c.lookup(k, false)
+	if !ok {
+		return nil, false
+	}
+	return c.copyOut(v.Object), true
+}
+
+// TTL returns the remaining time to live of key like the Redis TTL
+// command: NoExpiration (-1) for an item that never expires and -2 if the
+// key is not in the cache. It does not count as an access.
+func (c *ObjCache) TTL(k string) time.Duration {
+	v, ok := c.lookup(k, false)
+	if !ok {
+		return -2
+	}
+	if v.expire == neverExpire {
+		return NoExpiration
+	}
+	return time.Duration(v.expire - c.now())
+}
+
+// Has reports whether k is in the cache and not expired.
+func (c *ObjCache) Has(k string) bool {
+	_, ok := c.lookup(k, false)
+	return ok
+}
+
+// lookup returns a copy of the object and expiration of k. An expired
+// pair is removed lazily. If access is true, the access is counted for
+// the eviction policy.
+func (c *ObjCache) lookup(k string, access bool) (pair, bool) {
+	if c.closed() {
+		return pair{}, false
+	}
+	if access && (c.config.SlidingExpiration || c.config.TouchOnGet) {
+		return c.lookupLocked(k)
+	}
+
+	c.mu.RLock()
+	elem, ok := c.items[k]
+	if !ok {
+		c.mu.RUnlock()
+		return pair{}, false
+	}
+	p := elem.Value.(*pair)
+	v := pair{
+		Object:  p.Object,
+		key:     p.key,
+		expire:  p.expire,
+		ttl:     p.ttl,
+		version: p.version,
+		soft:    p.soft,
+		softTTL: p.softTTL,
+	}
+	if access && !c.expired(&v) {
+		c.accessed(p)
+	}
+	c.mu.RUnlock()
+
+	if c.expired(&v) {
+		if !c.config.KeepExpiredOnGet && c.reclaimable(&v) {
+			c.deleteExpired(k)
+		}
+		return pair{}, false
+	}
+	return v, true
+}
+
+// lookupLocked is lookup for SlidingExpiration and TouchOnGet. It takes
+// the write lock to extend the expiration of the item or to move it to
+// the back of the LRU list.
+func (c *ObjCache) lookupLocked(k string) (pair, bool) {
+	c.mu.Lock()
+	v, ok := c.lookupHeld(k)
+	c.unlock()
+	return v, ok
+}
+
+// lookupHeld is lookupLocked for a caller that holds the write lock.
+func (c *ObjCache) lookupHeld(k string) (pair, bool) {
+	elem, ok := c.items[k]
+	if !ok {
+		return pair{}, false
+	}
+	p := elem.Value.(*pair)
+	if c.expired(p) {
+		if !c.readOnly && !c.config.KeepExpiredOnGet && c.reclaimable(p) {
+			c.remove(elem, ReasonExpired)
+			atomic.AddInt64(&c.stats.expirations, 1)
+			c.emit(EventExpire, k)
+		}
+		return pair{}, false
+	}
+	if c.config.SlidingExpiration && !c.readOnly && !p.pinned {
+		p.expire = c.expireAt(p.ttl)
+		heap.Fix(&c.heap, p.index)
+	}
+	if c.config.TouchOnGet && !c.readOnly {
+		c.list.MoveToBack(elem)
+	}
+	c.accessed(p)
+	v := pair{
+		Object:  p.Object,
+		key:     p.key,
+		expire:  p.expire,
+		ttl:     p.ttl,
+		version: p.version,
+		soft:    p.soft,
+		softTTL: p.softTTL,
+	}
+	return v, true
+}
+
+// accessed records a Get hit of p for the eviction policy and
+// LastAccess. It only needs the read lock.
+func (c *ObjCache) accessed(p *pair) {
+	atomic.AddInt64(&p.freq, 1)
+	atomic.StoreInt64(&p.lastAccess, c.now())
+}
+
+// LastAccess returns the time of the last Get hit of key on the cache
+// clock, or of its set if it was not read since. It returns false if the
+// key is not in the cache or has expired. It does not count as an access.
+func (c *ObjCache) LastAccess(k string) (time.Time, bool) {
+	c.mu.RLock()
+	elem, ok := c.items[k]
+	if !ok || c.closed() || c.expired(elem.Value.(*pair)) {
+		c.mu.RUnlock()
+		return time.Time{}, false
+	}
+	t := atomic.LoadInt64(&elem.Value.(*pair).lastAccess)
+	c.mu.RUnlock()
+	return time.Unix(0, t), true
+}
+
+// reclaimable reports whether v has expired for longer than
+// Config.StaleGrace, so it can be removed.
+func (c *ObjCache) reclaimable(v *pair) bool {
+	return v.expire < c.now()-int64(c.config.StaleGrace)
+}
+
+// deleteExpired removes k under the write lock if it is still expired.
+// The item may have been replaced between releasing the read lock and
+// acquiring the write lock, so it is checked again.
+func (c *ObjCache) deleteExpired(k string) {
+	c.mu.Lock()
+	elem, ok := c.items[k]
+	if ok && !c.readOnly && c.reclaimable(elem.Value.(*pair)) {
+		c.remove(elem, ReasonExpired)
+		atomic.AddInt64(&c.stats.expirations, 1)
+		c.emit(EventExpire, k)
+	}
+	c.unlock()
+}
+
+// Del delete an item for some key.
+// With Config.Store set, the key is also deleted from the Store, and if
+// that fails, the item is kept and Del returns false.
+func (c *ObjCache) Del(k string) bool {
+	c.mu.Lock()
+	ok, err := c.delThrough(k)
+	ok = ok && err == nil
+	c.observeDelete(k, ok)
+	c.unlock()
+	return ok
+}
+
+// DelValue deletes the item of key like Del and returns its object. An
+// expired item is deleted too, but like for Get, nil and false are
+// returned for it.
+func (c *ObjCache) DelValue(k string) (interface{}, bool) {
+	c.mu.Lock()
+	var x interface{}
+	live := false
+	if elem, ok := c.items[k]; ok {
+		v := elem.Value.(*pair)
+		x, live = v.Object, !c.expired(v)
+	}
+	ok, err := c.delThrough(k)
+	ok = ok && err == nil
+	c.observeDelete(k, ok)
+	c.unlock()
+	if !ok || !live {
+		return nil, false
+	}
+	return x, true
+}
+
+// DelMulti deletes the items of keys like Del under one lock and returns
+// how many of them were live. Expired items are deleted too but not
+// counted. OnEvicted is called with ReasonDeleted for each item.
+func (c *ObjCache) DelMulti(keys ...string) int {
+	c.mu.Lock()
+	n := 0
+	for _, k := range keys {
+		live := false
+		if elem, ok := c.items[k]; ok {
+			live = !c.expired(elem.Value.(*pair))
+		}
+		ok, err := c.delThrough(k)
+		ok = ok && err == nil
+		c.observeDelete(k, ok)
+		if ok && live {
+			n = n + 1
+		}
+	}
+	c.unlock()
+	return n
+}
+
+// DeletePrefix deletes all items whose key starts with prefix under one
+// lock, from Config.Store too like Del, and returns how many. It scans
+// all items, so it is O(n).
+func (c *ObjCache) DeletePrefix(prefix string) int {
+	return c.deleteKeys(func(k string) bool {
+		return strings.HasPrefix(k, prefix)
+	})
+}
+
+// DeletePattern deletes all items whose key matches the path.Match
+// pattern like DeletePrefix and returns how many. It scans all items, so
+// it is O(n). It returns path.ErrBadPattern for a malformed pattern.
+func (c *ObjCache) DeletePattern(pattern string) (int, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return 0, err
+	}
+	return c.deleteKeys(func(k string) bool {
+		ok, _ := path.Match(pattern, k)
+		return ok
+	}), nil
+}
+
+// DeleteFunc deletes all live items for which pred returns true like
+// DeletePrefix and returns how many. OnEvicted is called for them. It
+// scans all items, so it is O(n). pred is called with the write lock
+// held, so it must not use the cache.
+func (c *ObjCache) DeleteFunc(pred func(key string, value interface{}) bool) int {
+	now := c.now()
+	return c.deleteItems(func(v *pair) bool {
+		match := false
+		if v.expire >= now {
+			c.guard(func() { match = pred(v.key, v.Object) })
+		}
+		return match
+	})
+}
+
+// deleteKeys deletes all items whose key matches and returns how many.
+func (c *ObjCache) deleteKeys(match func(k string) bool) int {
+	return c.deleteItems(func(v *pair) bool {
+		return match(v.key)
+	})
+}
+
+// deleteItems deletes all items that match with delThrough and returns
+// how many. Items Config.Store fails to delete are kept.
+func (c *ObjCache) deleteItems(match func(v *pair) bool) int {
+	c.mu.Lock()
+	if c.writable() != nil {
+		c.unlock()
+		return 0
+	}
+	n := 0
+	for elem := c.list.Front(); elem != nil; {
+		next := elem.Next()
+		if match(elem.Value.(*pair)) {
+			if ok, err := c.delThrough(elem.Value.(*pair).key); ok && err == nil {
+				n = n + 1
+			}
+		}
+		elem = next
+	}
+	c.unlock()
+	return n
+}
+
+// Keys returns the keys of all live items, from the least to the most
+// recently used.
+func (c *ObjCache) Keys() []string {
+	now := c.now()
+	c.mu.RLock()
+	keys := make([]string, 0, c.itemCount)
+	for elem := c.list.Front(); elem != nil; elem = elem.Next() {
+		v := elem.Value.(*pair)
+		if v.expire >= now {
+			keys = append(keys, v.key)
+		}
+	}
+	c.mu.RUnlock()
+	return keys
+}
+
+// ItemsInOrder returns all live items from the least to the most recently
+// used, with their remaining TTL or NoExpiration. Setting them in this
+// order on another cache gives it the same LRU order.
+func (c *ObjCache) ItemsInOrder() []Entry {
+	now := c.now()
+	c.mu.RLock()
+	entries := make([]Entry, 0, c.itemCount)
+	for elem := c.list.Front(); elem != nil; elem = elem.Next() {
+		v := elem.Value.(*pair)
+		if v.expire < now {
+			continue
+		}
+		ttl := NoExpiration
+		if v.expire != neverExpire {
+			ttl = time.Duration(v.expire - now)
+		}
+		entries = append(entries, Entry{Key: v.key, Value: c.copyOut(v.Object), TTL: ttl})
+	}
+	c.mu.RUnlock()
+	return entries
+}
+
+// KeysSorted returns the keys of all live items in lexicographic order,
+// for output that does not depend on the use of the items.
+func (c *ObjCache) KeysSorted() []string {
+	keys := c.Keys()
+	sort.Strings(keys)
+	return keys
+}
+
+// HotKeys returns the keys of at most n live items at the back of the LRU
+// list, from the most recently used. It does not change the list.
+func (c *ObjCache) HotKeys(n int) []string {
+	now := c.now()
+	c.mu.RLock()
+	if n > c.itemCount {
+		n = c.itemCount
+	}
+	if n < 0 {
+		n = 0
+	}
+	keys := make([]string, 0, n)
+	for elem := c.list.Back(); elem != nil && len(keys) < n; elem = elem.Prev() {
+		v := elem.Value.(*pair)
+		if v.expire >= now {
+			keys = append(keys, v.key)
+		}
+	}
+	c.mu.RUnlock()
+	return keys
+}
+
+// Range calls fn for each live item, from the least to the most recently
+// used, until fn returns false. The read lock is held during the
+// iteration, so fn must not modify the cache.
+func (c *ObjCache) Range(fn func(key string, value interface{}) bool) {
+	now := c.now()
+	c.mu.RLock()
+	for elem := c.list.Front(); elem != nil; elem = elem.Next() {
+		v := elem.Value.(*pair)
+		if v.expire < now {
+			continue
+		}
+		next := false
+		c.guard(func() { next = fn(v.key, v.Object) })
+		if !next {
+			break
+		}
+	}
+	c.mu.RUnlock()
+}
+
+// GetAndDelete removes key and returns its object. Concurrent callers
+// for the same key never both get the object.
+func (c *ObjCache) GetAndDelete(k string) (interface{}, bool) {
+	c.mu.Lock()
+	elem, ok := c.items[k]
+	if !ok || c.writable() != nil {
+		c.observeDelete(k, false)
+		c.unlock()
+		return nil, false
+	}
+	v := elem.Value.(*pair)
+	if c.expired(v) {
+		c.remove(elem, ReasonExpired)
+		atomic.AddInt64(&c.stats.expirations, 1)
+		c.emit(EventExpire, k)
+		c.observeDelete(k, false)
+		c.unlock()
+		return nil, false
+	}
+	x := v.Object
+	if ok, err := c.delThrough(k); !ok || err != nil {
+		c.observeDelete(k, false)
+		c.unlock()
+		return nil, false
+	}
+	c.observeDelete(k, true)
+	c.unlock()
+	return x, true
+}
+
+// DeleteExpired removes all expired items and returns how many.
+func (c *ObjCache) DeleteExpired() int {
+	c.mu.Lock()
+	n := c.removeExpired()
+	c.unlock()
+	return n
+}
+
+// Resize sets MaxEntryLimit to limit and evicts items until they fit.
+// It returns the number of evicted items. A limit of 0 or less is
+// refused, keeping the current one, when the config has settings that
+// require MaxEntryLimit, like RejectOnFull or HighWaterMark.
+func (c *ObjCache) Resize(limit int) int {
+	c.mu.Lock()
+	if c.writable() != nil {
+		c.unlock()
+		return 0
+	}
+	config := c.config
+	config.MaxEntryLimit = limit
+	if config.validateCombinations() != nil {
+		c.unlock()
+		return 0
+	}
+	c.config.MaxEntryLimit = limit
+	n := 0
+	for limit > 0 && c.counted() > limit && c.removeOldest(nil) {
+		n = n + 1
+	}
+	c.unlock()
+	return n
+}
+
+// Rename moves the item of oldKey to newKey, keeping its object,
+// expiration and LRU position. An item of newKey is overwritten. With
+// Config.Store, oldKey is deleted and newKey set in the Store first, and
+// oldKey is set back if newKey fails. It returns false if oldKey is not
+// in the cache or has expired, or if the Store fails.
+func (c *ObjCache) Rename(oldKey, newKey string) bool {
+	if c.checkKey(newKey) != nil {
+		return false
+	}
+	c.mu.Lock()
+	elem, ok := c.items[oldKey]
+	if !ok || c.writable() != nil || c.expired(elem.Value.(*pair)) {
+		c.unlock()
+		return false
+	}
+	if oldKey == newKey {
+		c.unlock()
+		return true
+	}
+	if c.config.Store != nil {
+		x := elem.Value.(*pair).Object
+		if c.writeBack(write{key: oldKey, deleted: true}) != nil {
+			c.unlock()
+			return false
+		}
+		if c.writeBack(write{key: newKey, value: x}) != nil {
+			c.writeBack(write{key: oldKey, value: x})
+			c.unlock()
+			return false
+		}
+	}
+	if other, ok := c.items[newKey]; ok {
+		c.remove(other, ReasonReplaced)
+	}
+	delete(c.items, oldKey)
+	p := elem.Value.(*pair)
+	c.untag(p)
+	c.unindex(p)
+	p.key = newKey
+	c.tag(p)
+	c.index(p)
+	c.items[newKey] = elem
+	c.invalidated(oldKey)
+	c.invalidated(newKey)
+	c.unlock()
+	return true
+}
+
+// Clone returns an independent copy of the cache with the live items in
+// the same LRU order. Objects are shared with the clone unless
+// Config.CopyOnGet is set. Config.Rand, Config.Invalidator,
+// Config.SourceRefresh and Config.Store, with WriteBehind and
+// OnStoreError, are not shared with the clone, so its writes stay in
+// memory. It returns the error of New if the config is rejected.
+func (c *ObjCache) Clone() (*ObjCache, error) {
+	c.mu.RLock()
+	config := c.config
+	config.Rand = nil
+	config.Warm = nil
+	config.Invalidator = nil
+	config.SourceRefresh = SourceRefresh{}
+	config.Store = nil
+	config.WriteBehind = false
+	config.OnStoreError = nil
+	clone, err := New(config)
+	if err != nil {
+		c.mu.RUnlock()
+		return nil, err
+	}
+	now := c.now()
+	for elem := c.list.Front(); elem != nil; elem = elem.Next() {
+		v := elem.Value.(*pair)
+		if v.expire < now {
+			continue
+		}
+		p := &pair{
+			Object: v.Object,
+			key:    v.key,
+			expire: v.expire,
+			size:   v.size,
+			cost:   v.cost,
+			ttl:    v.ttl,
+			freq:   atomic.LoadInt64(&v.freq),
+			tags:   v.tags,
+
+			version: v.version,
+
+			lastAccess: atomic.LoadInt64(&v.lastAccess),
+			created:    v.created,
+			seq:        v.seq,
+			soft:       v.soft,
+			softTTL:    v.softTTL,
+			pinned:     v.pinned,
+		}
+		if config.CopyOnGet {
+			p.Object = deepCopy(p.Object)
+		}
+		clone.items[p.key] = clone.list.PushBack(p)
+		clone.tag(p)
+		clone.intern(p)
+		clone.index(p)
+		heap.Push(&clone.heap, p)
+		clone.itemCount = clone.itemCount + 1
+		if p.pinned {
+			clone.pinned = clone.pinned + 1
+		}
+		clone.bytes = clone.bytes + p.size
+		clone.cost = clone.cost + p.cost
+	}
+	clone.version = c.version
+	clone.seq = c.seq
+	c.mu.RUnlock()
+	return clone, nil
+}
+
+// Compact removes the expired items and rebuilds the item map and the
+// expiration heap at the size of the live items, releasing the memory a
+// burst of items left behind. Go maps do not shrink by themselves. It is
+// O(n).
+func (c *ObjCache) Compact() {
+	c.mu.Lock()
+	c.removeExpired()
+	items := make(map[string]*list.Element, len(c.items))
+	for k, elem := range c.items {
+		items[k] = elem
+	}
+	c.items = items
+	c.heap = append(expireHeap(nil), c.heap...)
+	c.unlock()
+}
+
+// Flush deletes all items from the cache, but not from Config.Store.
+// OnEvicted is not called for the flushed items.
+func (c *ObjCache) Flush() {
+	c.mu.Lock()
+	if c.writable() != nil {
+		c.unlock()
+		return
+	}
+	for k := range c.items {
+		c.invalidated(k)
+	}
+	c.clear()
+	c.unlock()
+}
+
+// Drain deletes all items from the cache under one lock and returns the
+// objects of the live ones by key, for handing them off. OnEvicted is
+// called with ReasonDrained for the live items and ReasonExpired for the
+// others. Config.Store is not changed. While the cache is read-only, it
+// returns nil.
+func (c *ObjCache) Drain() map[string]interface{} {
+	c.mu.Lock()
+	if c.writable() != nil {
+		c.unlock()
+		return nil
+	}
+	items := make(map[string]interface{}, c.itemCount)
+	for elem := c.list.Front(); elem != nil; elem = elem.Next() {
+		v := elem.Value.(*pair)
+		if c.expired(v) {
+			c.evicting(v, ReasonExpired)
+			continue
+		}
+		items[v.key] = c.copyOut(v.Object)
+		c.evicting(v, ReasonDrained)
+		c.invalidated(v.key)
+	}
+	c.clear()
+	c.unlock()
+	return items
+}
+
+// clear removes all items without calling OnEvicted. The caller must hold
+// the write lock.
+func (c *ObjCache) clear() {
+	c.items = make(map[string]*list.Element, c.config.InitialCapacity)
+	c.list = list.New()
+	c.heap = nil
+	c.tags = nil
+	c.secondary = nil
+	c.shared = nil
+	c.itemCount = 0
+	c.pinned = 0
+	c.bytes = 0
+	c.cost = 0
+	c.notifySpace()
+}
+
+// SetReadOnly makes the cache read-only or writable again. While it is
+// read-only, Set, Add, Replace and the other writes return ErrReadOnly or
+// false and change nothing, Del returns false, and expired items are not
+// removed, so Get serves a stable snapshot. Expired items still miss.
+// Making the cache writable removes the expired items.
+func (c *ObjCache) SetReadOnly(ro bool) {
+	c.mu.Lock()
+	c.readOnly = ro
+	c.removeExpired()
+	c.unlock()
+}
+
+// Len returns the number of items in the cache. Expired items are
+// removed before counting.
+func (c *ObjCache) Len() int {
+	c.mu.Lock()
+	c.removeExpired()
+	n := c.itemCount
+	c.unlock()
+	return n
+}
+
+// Config returns a copy of the config of the cache, with the defaults
+// New applied and the MaxEntryLimit of the last Resize.
+func (c *ObjCache) Config() Config {
+	c.mu.RLock()
+	config := c.config
+	c.mu.RUnlock()
+	config.Clock = c.clock
+	config.Warm = append([]WarmEntry(nil), config.Warm...)
+	return config
+}
+
+// Close stops the janitor goroutine and closes the Events channel. With
+// Config.WriteBehind it flushes the queued writes and returns the first
+// Store error. After Close, writes return ErrCacheClosed or false and
+// reads miss, but the items can still be listed and saved. Calling it
+// again does nothing and returns nil.
+func (c *ObjCache) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.closeEvents()
+		if c.config.WriteBehind {
+			<-c.writeBehind.stopped
+			err = c.flush(false)
+		}
+	})
+	return err
+}
+
+// writable returns ErrCacheClosed after Close and ErrReadOnly while the
+// cache is read-only, or nil if it can be written.
+func (c *ObjCache) writable() error {
+	if c.closed() {
+		return ErrCacheClosed
+	}
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+// closed reports whether Close has been called.
+func (c *ObjCache) closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// janitor removes expired items every interval until Close is called.
+func (c *ObjCache) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.mu.Lock()
+			n := c.removeExpired()
+			c.removeNotified(c.now())
+			c.unlock()
+			c.log(LevelDebug, "objcache: janitor sweep", map[string]interface{}{"expired": n})
+			c.removeNegatives()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// New makes an cache object and returns it.
+// If config.JanitorInterval or config.ClockResolution is positive or
+// config.SourceRefresh is set, a goroutine is started and the caller
+// should call Close when the cache is no longer needed.
+func New(config Config) (*ObjCache, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	l := list.New()
+	cache := &ObjCache{
+		items:     make(map[string]*list.Element, config.InitialCapacity),
+		itemCount: 0,
+		list:      l,
+		config:    config,
+		clock:     config.Clock,
+		loads:     make(map[string]*call),
+		negatives: make(map[string]negativeEntry),
+		done:      make(chan struct{}),
+	}
+	if cache.clock == nil {
+		cache.clock = realClock{}
+	}
+	if config.ClockResolution > 0 {
+		cache.coarseNow = cache.clock.Now().UnixNano()
+		go cache.tick(config.ClockResolution)
+	}
+	if config.ExpirationJitter > 0 {
+		cache.rand = config.Rand
+		if cache.rand == nil {
+			cache.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+		}
+	}
+	if config.StatsBucket > 0 {
+		cache.buckets = make([]bucket, config.StatsBuckets)
+		for i := range cache.buckets {
+			cache.buckets[i].epoch = -1
+		}
+	}
+	if config.EventBuffer > 0 {
+		cache.events.ch = make(chan Event, config.EventBuffer)
+	}
+	if config.MaxConcurrentLoads > 0 {
+		cache.loadSlots = make(chan struct{}, config.MaxConcurrentLoads)
+	}
+	if config.Admission {
+		cache.sketch = newSketch(config.MaxEntryLimit)
+	}
+	cache.Warm(config.Warm)
+	if config.JanitorInterval > 0 {
+		go cache.janitor(config.JanitorInterval)
+	}
+	if config.WriteBehind {
+		cache.writeBehind.writes = make(map[string]write)
+		cache.writeBehind.wake = make(chan struct{}, 1)
+		cache.writeBehind.stopped = make(chan struct{})
+		go cache.flusher(config.FlushInterval)
+	}
+	if config.Invalidator != nil {
+		go cache.invalidations(config.Invalidator.Subscribe())
+	}
+	if config.SourceRefresh.Fetch != nil {
+		go cache.refresher(config.SourceRefresh)
 	}
 	return cache, nil
 }
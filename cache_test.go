@@ -1,7 +1,18 @@
 package objcache
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -10,6 +21,59 @@ type ForTest struct {
 	value string
 }
 
+// fakeClock is a Clock that only moves when advanced. Its timers fire
+// when it is advanced past them.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	timers := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			timers = append(timers, t)
+			continue
+		}
+		t.ch <- c.now
+	}
+	c.timers = timers
+	c.mu.Unlock()
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	c.timers = append(c.timers, fakeTimer{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// pending returns the number of timers that have not fired.
+func (c *fakeClock) pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
 func BenchmarkSimple(b *testing.B) {
 	myconfig := Config{
 		MaxEntryLimit: 100000,
@@ -61,3 +125,3025 @@ func BenchmarkSimple(b *testing.B) {
 		<-k
 	}
 }
+
+func TestSetOverwrite(t *testing.T) {
+	cache, err := New(Config{
+		MaxEntryLimit: 10,
+		Expiration:    5 * time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("k", "old", 20*time.Millisecond)
+	cache.Set("k", "new", time.Minute)
+
+	time.Sleep(40 * time.Millisecond)
+
+	v, ok := cache.Get("k")
+	if !ok {
+		t.Fatal("expected key to survive with the refreshed TTL")
+	}
+	if v != "new" {
+		t.Fatalf("expected %q, got %v", "new", v)
+	}
+}
+
+func TestConcurrentGetSet(t *testing.T) {
+	cache, err := New(Config{
+		MaxEntryLimit: 50,
+		Expiration:    time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i = i + 1 {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			for j := 0; j < 2000; j = j + 1 {
+				k := strconv.Itoa((n + j) % 100)
+				cache.Set(k, j, 0)
+				cache.Get(k)
+				cache.Get(strconv.Itoa(j % 100))
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestHas(t *testing.T) {
+	cache, err := New(Config{
+		MaxEntryLimit: 10,
+		Expiration:    5 * time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("present", 1, 0)
+	cache.Set("expiring", 2, 10*time.Millisecond)
+
+	if !cache.Has("present") {
+		t.Error("expected present key")
+	}
+	if cache.Has("absent") {
+		t.Error("expected absent key to be missing")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if cache.Has("expiring") {
+		t.Error("expected expired key to be missing")
+	}
+	if _, ok := cache.items["expiring"]; ok {
+		t.Error("expected expired key to be removed")
+	}
+}
+
+func TestLen(t *testing.T) {
+	cache, err := New(Config{
+		MaxEntryLimit: 10,
+		Expiration:    5 * time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("expiring", 0, 10*time.Millisecond)
+	cache.Set("a", 1, 0)
+	cache.Set("b", 2, 0)
+	cache.Set("c", 3, 0)
+	if n := cache.Len(); n != 4 {
+		t.Fatalf("expected 4 items, got %d", n)
+	}
+
+	cache.Del("b")
+	if n := cache.Len(); n != 3 {
+		t.Fatalf("expected 3 items after Del, got %d", n)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if n := cache.Len(); n != 2 {
+		t.Fatalf("expected 2 items after expiration, got %d", n)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	cache, err := New(Config{
+		MaxEntryLimit: 10,
+		Expiration:    5 * time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i = i + 1 {
+		cache.Set(strconv.Itoa(i), i, 0)
+	}
+	cache.Flush()
+
+	if n := cache.Len(); n != 0 {
+		t.Fatalf("expected empty cache, got %d items", n)
+	}
+	for i := 0; i < 10; i = i + 1 {
+		if _, ok := cache.Get(strconv.Itoa(i)); ok {
+			t.Fatalf("expected key %d to be flushed", i)
+		}
+	}
+}
+
+func TestDrain(t *testing.T) {
+	clock := newFakeClock()
+	reasons := make(map[string]EvictReason)
+	cache, err := New(Config{
+		Clock:     clock,
+		OnEvicted: func(k string, v interface{}, r EvictReason) { reasons[k] = r },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("a", 1, time.Minute)
+	cache.Set("b", 2, time.Minute)
+	cache.Set("old", 3, time.Second)
+	clock.Advance(2 * time.Second)
+
+	items := cache.Drain()
+	if !reflect.DeepEqual(items, map[string]interface{}{"a": 1, "b": 2}) {
+		t.Fatalf("expected the live items, got %v", items)
+	}
+	if n := cache.Len(); n != 0 {
+		t.Fatalf("expected empty cache, got %d items", n)
+	}
+	want := map[string]EvictReason{"a": ReasonDrained, "b": ReasonDrained, "old": ReasonExpired}
+	if !reflect.DeepEqual(reasons, want) {
+		t.Fatalf("expected %v, got %v", want, reasons)
+	}
+
+	cache.Set("c", 4, time.Minute)
+	items["c"] = 5
+	if v, _ := cache.Get("c"); v != 4 {
+		t.Fatalf("expected the drained map not to be shared, got %v", v)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	cache, err := New(Config{
+		MaxEntryLimit: 10,
+		Expiration:    5 * time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.Add("k", 1, 0); err != nil {
+		t.Fatalf("Add on absent key: %v", err)
+	}
+	if err := cache.Add("k", 2, 0); err != ErrKeyExists {
+		t.Fatalf("expected ErrKeyExists, got %v", err)
+	}
+	if v, _ := cache.Get("k"); v != 1 {
+		t.Fatalf("expected original value, got %v", v)
+	}
+
+	cache.Set("expiring", 1, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if err := cache.Add("expiring", 2, 0); err != nil {
+		t.Fatalf("Add on expired key: %v", err)
+	}
+	if v, _ := cache.Get("expiring"); v != 2 {
+		t.Fatalf("expected new value, got %v", v)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	cache, err := New(Config{
+		MaxEntryLimit: 10,
+		Expiration:    5 * time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.Replace("k", 1, 0); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	if cache.Has("k") {
+		t.Fatal("Replace must not insert an absent key")
+	}
+
+	cache.Set("a", 1, 0)
+	cache.Set("b", 2, 0)
+	if err := cache.Replace("a", 10, 0); err != nil {
+		t.Fatalf("Replace on live key: %v", err)
+	}
+	if v, _ := cache.Get("a"); v != 10 {
+		t.Fatalf("expected replaced value, got %v", v)
+	}
+	if cache.list.Back().Value.(*pair).key != "a" {
+		t.Fatal("expected replaced key at the back of the list")
+	}
+
+	cache.Set("expiring", 1, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if err := cache.Replace("expiring", 2, 0); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound for expired key, got %v", err)
+	}
+}
+
+func TestGetOrSet(t *testing.T) {
+	cache, err := New(Config{
+		MaxEntryLimit: 10,
+		Expiration:    5 * time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i = i + 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, _, err := cache.GetOrSet("k", 0, func() (interface{}, error) {
+				atomic.AddInt32(&calls, 1)
+				return "computed", nil
+			})
+			if err != nil || v != "computed" {
+				t.Errorf("unexpected result %v, %v", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Fatalf("expected fn to run once, ran %d times", calls)
+	}
+
+	_, found, _ := cache.GetOrSet("k", 0, nil)
+	if !found {
+		t.Fatal("expected cached value to be found")
+	}
+
+	failure := errors.New("failure")
+	if _, _, err := cache.GetOrSet("bad", 0, func() (interface{}, error) {
+		return nil, failure
+	}); err != failure {
+		t.Fatalf("expected fn error, got %v", err)
+	}
+	if cache.Has("bad") {
+		t.Fatal("failed fn must not store anything")
+	}
+}
+
+func TestIncrement(t *testing.T) {
+	cache, err := New(Config{
+		MaxEntryLimit: 10,
+		Expiration:    5 * time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("n", int64(1), 0)
+	if n, err := cache.Increment("n", 4); err != nil || n != 5 {
+		t.Fatalf("expected 5, got %d, %v", n, err)
+	}
+	if n, err := cache.Decrement("n", 2); err != nil || n != 3 {
+		t.Fatalf("expected 3, got %d, %v", n, err)
+	}
+
+	cache.Set("max", int64(math.MaxInt64), 0)
+	if n, _ := cache.Increment("max", 1); n != math.MinInt64 {
+		t.Fatalf("expected wraparound to %d, got %d", int64(math.MinInt64), n)
+	}
+
+	if _, err := cache.Increment("missing", 1); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+
+	cache.Set("s", "str", 0)
+	if _, err := cache.Increment("s", 1); err != ErrNotInt64 {
+		t.Fatalf("expected ErrNotInt64, got %v", err)
+	}
+
+	cache.Set("expiring", int64(1), 30*time.Millisecond)
+	cache.Increment("expiring", 1)
+	time.Sleep(40 * time.Millisecond)
+	if cache.Has("expiring") {
+		t.Fatal("Increment must not reset the expiration")
+	}
+}
+
+func TestRemoveExpiredOutOfOrder(t *testing.T) {
+	cache, err := New(Config{
+		MaxEntryLimit: 10,
+		Expiration:    5 * time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("long", 1, time.Minute)
+	cache.Set("short", 2, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	if n := cache.Len(); n != 1 {
+		t.Fatalf("expected short-lived item to be swept, got %d items", n)
+	}
+	if _, ok := cache.items["short"]; ok {
+		t.Fatal("expected short-lived item to be removed")
+	}
+	if !cache.Has("long") {
+		t.Fatal("expected long-lived item to remain")
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	cache, err := New(Config{
+		MaxEntryLimit:   10,
+		Expiration:      5 * time.Minute,
+		JanitorInterval: 5 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	cache.Set("k", 1, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+
+	cache.mu.RLock()
+	n := cache.itemCount
+	cache.mu.RUnlock()
+	if n != 0 {
+		t.Fatalf("expected janitor to remove the expired item, got %d items", n)
+	}
+
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOnEvicted(t *testing.T) {
+	evicted := make(map[string]interface{})
+	var cache *ObjCache
+	cache, err := New(Config{
+		MaxEntryLimit: 2,
+		Expiration:    5 * time.Minute,
+		OnEvicted: func(k string, v interface{}, reason EvictReason) {
+			// The lock is released, so the cache can be used here.
+			cache.Has(k)
+			evicted[k] = v
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("a", 1, 0)
+	cache.Set("b", 2, 0)
+	cache.Set("c", 3, 0)
+	if v, ok := evicted["a"]; !ok || v != 1 {
+		t.Fatalf("expected capacity eviction of a, got %v", evicted)
+	}
+
+	cache.Del("b")
+	if v, ok := evicted["b"]; !ok || v != 2 {
+		t.Fatalf("expected Del to evict b, got %v", evicted)
+	}
+
+	cache.Set("d", 4, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	cache.Set("e", 5, 0)
+	if v, ok := evicted["d"]; !ok || v != 4 {
+		t.Fatalf("expected expiration of d, got %v", evicted)
+	}
+
+	if len(evicted) != 3 {
+		t.Fatalf("expected 3 evictions, got %v", evicted)
+	}
+}
+
+func TestStats(t *testing.T) {
+	cache, err := New(Config{
+		MaxEntryLimit: 2,
+		Expiration:    5 * time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("a", 1, 0)
+	cache.Set("b", 2, 10*time.Millisecond)
+	cache.Get("a")
+	cache.Get("a")
+	cache.Get("missing")
+	time.Sleep(20 * time.Millisecond)
+	cache.Get("b")
+	cache.Set("c", 3, 0)
+	cache.Set("d", 4, 0)
+
+	want := Stats{
+		Hits:        2,
+		Misses:      2,
+		Evictions:   1,
+		Expirations: 1,
+		ItemCount:   2,
+		Capacity:    2,
+	}
+	if s := cache.Stats(); s != want {
+		t.Fatalf("expected %+v, got %+v", want, s)
+	}
+
+	cache.ResetStats()
+	if s := cache.Stats(); s != (Stats{ItemCount: 2, Capacity: 2}) {
+		t.Fatalf("expected reset counters, got %+v", s)
+	}
+}
+
+func TestPublishExpvar(t *testing.T) {
+	cache, err := New(Config{MaxEntryLimit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	// The name is unique, as expvar names cannot be reused by -count.
+	name := fmt.Sprintf("objcache_test_%d", time.Now().UnixNano())
+	cache.PublishExpvar(name)
+
+	cache.Set("a", 1, 0)
+	cache.Set("b", 2, 0)
+	cache.Set("c", 3, 0)
+	cache.Get("c")
+	cache.Get("a")
+
+	var got map[string]int64
+	if err := json.Unmarshal([]byte(expvar.Get(name).String()), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int64{"hits": 1, "misses": 1, "evictions": 1, "expirations": 0, "items": 2, "capacity": 2}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic for a name in use")
+		}
+	}()
+	cache.PublishExpvar(name)
+}
+
+func TestMaxBytes(t *testing.T) {
+	cache, err := New(Config{
+		MaxEntryLimit: 100,
+		Expiration:    5 * time.Minute,
+		MaxBytes:      10,
+		Sizer: func(v interface{}) int64 {
+			return int64(len(v.(string)))
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("a", "aaaa", 0)
+	cache.Set("b", "bbbb", 0)
+	cache.Set("c", "cccc", 0)
+	if cache.Has("a") || !cache.Has("b") || !cache.Has("c") {
+		t.Fatal("expected the oldest item to be evicted by size")
+	}
+	if cache.bytes != 8 {
+		t.Fatalf("expected 8 bytes, got %d", cache.bytes)
+	}
+
+	cache.Set("b", "bb", 0)
+	if cache.bytes != 6 {
+		t.Fatalf("expected 6 bytes after overwrite, got %d", cache.bytes)
+	}
+
+	cache.Del("b")
+	if cache.bytes != 4 {
+		t.Fatalf("expected 4 bytes after Del, got %d", cache.bytes)
+	}
+
+	cache.SetWithSize("big", "x", 9, 10*time.Millisecond)
+	if cache.Has("c") {
+		t.Fatal("expected SetWithSize to evict by the given size")
+	}
+	time.Sleep(20 * time.Millisecond)
+	cache.Len()
+	if cache.bytes != 0 {
+		t.Fatalf("expected 0 bytes after expiration, got %d", cache.bytes)
+	}
+}
+
+func TestGetWithExpiration(t *testing.T) {
+	cache, err := New(Config{
+		MaxEntryLimit: 10,
+		Expiration:    5 * time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("k", 1, time.Minute)
+	v, expire, ok := cache.GetWithExpiration("k")
+	if !ok || v != 1 {
+		t.Fatalf("expected 1, got %v, %v", v, ok)
+	}
+	if d := time.Until(expire); d > time.Minute || d < time.Minute-time.Second {
+		t.Fatalf("expected expiration in about a minute, got %v", d)
+	}
+
+	if v, expire, ok := cache.GetWithExpiration("missing"); ok || v != nil || !expire.IsZero() {
+		t.Fatalf("expected zero values on miss, got %v, %v, %v", v, expire, ok)
+	}
+}
+
+func TestTouch(t *testing.T) {
+	cache, err := New(Config{
+		MaxEntryLimit: 10,
+		Expiration:    5 * time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("k", 1, 20*time.Millisecond)
+	cache.Set("other", 2, 0)
+	if !cache.Touch("k", time.Minute) {
+		t.Fatal("expected Touch to succeed on a live key")
+	}
+	if cache.list.Back().Value.(*pair).key != "k" {
+		t.Fatal("expected touched key at the back of the list")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if v, ok := cache.Get("k"); !ok || v != 1 {
+		t.Fatalf("expected extended item, got %v, %v", v, ok)
+	}
+
+	if cache.Touch("missing", time.Minute) {
+		t.Fatal("expected Touch to fail on a missing key")
+	}
+
+	cache.Set("expiring", 1, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if cache.Touch("expiring", time.Minute) {
+		t.Fatal("expected Touch to fail on an expired key")
+	}
+	if cache.Has("expiring") {
+		t.Fatal("Touch must not resurrect an expired key")
+	}
+}
+
+func TestNoExpiration(t *testing.T) {
+	cache, err := New(Config{
+		MaxEntryLimit:   10,
+		Expiration:      time.Millisecond,
+		JanitorInterval: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	cache.Set("forever", 1, NoExpiration)
+	cache.Set("default", 2, 0)
+	time.Sleep(20 * time.Millisecond)
+
+	if v, ok := cache.Get("forever"); !ok || v != 1 {
+		t.Fatalf("expected item without expiration, got %v, %v", v, ok)
+	}
+	if cache.Has("default") {
+		t.Fatal("expected item with default expiration to expire")
+	}
+	if _, expire, ok := cache.GetWithExpiration("forever"); !ok || !expire.IsZero() {
+		t.Fatalf("expected zero expiration time, got %v", expire)
+	}
+	if s := cache.Stats(); s.Expirations != 1 {
+		t.Fatalf("expected 1 expiration, got %d", s.Expirations)
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{
+		MaxEntryLimit: 10,
+		Expiration:    time.Minute,
+		Clock:         clock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("short", 1, time.Second)
+	cache.Set("default", 2, 0)
+	cache.Set("forever", 3, NoExpiration)
+
+	clock.Advance(999 * time.Millisecond)
+	if !cache.Has("short") {
+		t.Fatal("expected item to be alive before its TTL")
+	}
+
+	clock.Advance(2 * time.Millisecond)
+	if cache.Has("short") {
+		t.Fatal("expected item to expire after its TTL")
+	}
+
+	clock.Advance(time.Minute)
+	if cache.Has("default") {
+		t.Fatal("expected item to expire after the default TTL")
+	}
+
+	clock.Advance(100 * 365 * 24 * time.Hour)
+	if !cache.Has("forever") {
+		t.Fatal("expected item without expiration to survive")
+	}
+}
+
+func TestPolicyLFU(t *testing.T) {
+	hits := func(policy Policy) int64 {
+		cache, err := New(Config{
+			MaxEntryLimit: 100,
+			Expiration:    5 * time.Minute,
+			Policy:        policy,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		zipf := rand.NewZipf(rand.New(rand.NewSource(1)), 1.1, 1, 10000)
+		for i := 0; i < 20000; i = i + 1 {
+			k := strconv.FormatUint(zipf.Uint64(), 10)
+			if _, ok := cache.Get(k); !ok {
+				cache.Set(k, i, 0)
+			}
+		}
+		return cache.Stats().Hits
+	}
+
+	lru := hits(PolicyLRU)
+	lfu := hits(PolicyLFU)
+	if lfu <= lru {
+		t.Fatalf("expected LFU to beat LRU on a Zipfian pattern, got %d <= %d hits", lfu, lru)
+	}
+}
+
+func TestRecentHitRatio(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{Clock: clock, StatsBucket: time.Second, StatsBuckets: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := cache.RecentHitRatio(time.Minute); !math.IsNaN(r) {
+		t.Fatalf("expected NaN without traffic, got %v", r)
+	}
+
+	cache.Set("a", 1, NoExpiration)
+	cache.Get("a")
+	cache.Get("a")
+	cache.MGet([]string{"a", "b"})
+	clock.Advance(time.Second)
+	cache.Get("b")
+	cache.Get("b")
+
+	if r := cache.RecentHitRatio(time.Second); r != 0 {
+		t.Fatalf("expected 0 in the last bucket, got %v", r)
+	}
+	if r := cache.RecentHitRatio(2 * time.Second); r != 0.5 {
+		t.Fatalf("expected 0.5 over two buckets, got %v", r)
+	}
+
+	clock.Advance(5 * time.Second)
+	cache.Get("a")
+	if r := cache.RecentHitRatio(time.Minute); r != 4.0/7 {
+		t.Fatalf("expected 4/7 over all buckets, got %v", r)
+	}
+
+	clock.Advance(20 * time.Second)
+	if r := cache.RecentHitRatio(time.Minute); !math.IsNaN(r) {
+		t.Fatalf("expected NaN after the window, got %v", r)
+	}
+	cache.Get("a")
+	if r := cache.RecentHitRatio(time.Minute); r != 1 {
+		t.Fatalf("expected a reused bucket to be cleared, got %v", r)
+	}
+}
+
+func TestEvictionRate(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{Clock: clock, MaxEntryLimit: 10, StatsBucket: time.Second, StatsBuckets: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := cache.Utilization(); u != 0 {
+		t.Fatalf("expected an empty cache, got %v", u)
+	}
+
+	i := 0
+	for i < 15 {
+		cache.Set(strconv.Itoa(i), i, NoExpiration)
+		i = i + 1
+	}
+	if u := cache.Utilization(); u != 1 {
+		t.Fatalf("expected a full cache, got %v", u)
+	}
+	if r := cache.EvictionRate(time.Second); r != 5 {
+		t.Fatalf("expected 5 evictions per second, got %v", r)
+	}
+
+	clock.Advance(time.Second)
+	for i < 18 {
+		cache.Set(strconv.Itoa(i), i, NoExpiration)
+		i = i + 1
+	}
+	if r := cache.EvictionRate(time.Second); r != 3 {
+		t.Fatalf("expected 3 evictions per second in the last bucket, got %v", r)
+	}
+	if r := cache.EvictionRate(4 * time.Second); r != 2 {
+		t.Fatalf("expected 2 evictions per second over 4 seconds, got %v", r)
+	}
+
+	clock.Advance(time.Minute)
+	if r := cache.EvictionRate(time.Minute); r != 0 {
+		t.Fatalf("expected no recent evictions, got %v", r)
+	}
+
+	cache.Del("17")
+	if u := cache.Utilization(); u != 0.9 {
+		t.Fatalf("expected 0.9, got %v", u)
+	}
+	unlimited, _ := New(Config{})
+	unlimited.Set("a", 1, 0)
+	if u := unlimited.Utilization(); u != 0 {
+		t.Fatalf("expected 0 without a limit, got %v", u)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{
+		MaxEntryLimit: 10,
+		Expiration:    time.Minute,
+		Clock:         clock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("a", 1, 0)
+	cache.Set("expiring", 2, time.Second)
+	cache.Set("b", 3, 0)
+	cache.Set("c", 4, 0)
+	clock.Advance(2 * time.Second)
+
+	keys := cache.Keys()
+	want := []string{"a", "b", "c"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Fatalf("expected %v, got %v", want, keys)
+	}
+
+	keys[0] = "changed"
+	if cache.Keys()[0] != "a" {
+		t.Fatal("expected Keys to return a copy")
+	}
+}
+
+func TestKeysSorted(t *testing.T) {
+	cache, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"c", "a", "d", "b"} {
+		cache.Set(k, k, 0)
+	}
+
+	want := []string{"a", "b", "c", "d"}
+	for i := 0; i < 3; i = i + 1 {
+		if keys := cache.KeysSorted(); !reflect.DeepEqual(keys, want) {
+			t.Fatalf("expected %v, got %v", want, keys)
+		}
+	}
+	if keys := cache.Keys(); !reflect.DeepEqual(keys, []string{"c", "a", "d", "b"}) {
+		t.Fatalf("expected the LRU order to be kept, got %v", keys)
+	}
+}
+
+func TestHotKeys(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{
+		Expiration: time.Minute,
+		Clock:      clock,
+		TouchOnGet: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("a", 1, 0)
+	cache.Set("b", 2, 0)
+	cache.Set("expiring", 3, time.Second)
+	cache.Set("c", 4, 0)
+	cache.Get("a")
+	cache.Get("expiring")
+	clock.Advance(2 * time.Second)
+
+	if keys, want := cache.HotKeys(2), []string{"a", "c"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("expected %v, got %v", want, keys)
+	}
+	if keys, want := cache.HotKeys(10), []string{"a", "c", "b"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("expected %v, got %v", want, keys)
+	}
+	if keys := cache.Keys(); !reflect.DeepEqual(keys, []string{"b", "c", "a"}) {
+		t.Fatalf("expected HotKeys not to change the LRU list, got %v", keys)
+	}
+}
+
+func TestRange(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{
+		MaxEntryLimit: 10,
+		Expiration:    time.Minute,
+		Clock:         clock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("a", 1, 0)
+	cache.Set("expiring", 2, time.Second)
+	cache.Set("b", 3, 0)
+	clock.Advance(2 * time.Second)
+
+	visited := make(map[string]interface{})
+	cache.Range(func(k string, v interface{}) bool {
+		visited[k] = v
+		return true
+	})
+	want := map[string]interface{}{"a": 1, "b": 3}
+	if !reflect.DeepEqual(visited, want) {
+		t.Fatalf("expected %v, got %v", want, visited)
+	}
+
+	n := 0
+	cache.Range(func(k string, v interface{}) bool {
+		n = n + 1
+		return false
+	})
+	if n != 1 {
+		t.Fatalf("expected Range to stop after 1 item, visited %d", n)
+	}
+}
+
+func TestMSetMGet(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{
+		MaxEntryLimit: 3,
+		Expiration:    time.Minute,
+		Clock:         clock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.MSet(map[string]interface{}{"a": 1, "b": 2}, 0)
+	cache.Set("expiring", 3, time.Second)
+	clock.Advance(2 * time.Second)
+
+	found := cache.MGet([]string{"a", "b", "expiring", "missing"})
+	want := map[string]interface{}{"a": 1, "b": 2}
+	if !reflect.DeepEqual(found, want) {
+		t.Fatalf("expected %v, got %v", want, found)
+	}
+
+	batch := make(map[string]interface{})
+	for i := 0; i < 10; i = i + 1 {
+		batch[strconv.Itoa(i)] = i
+	}
+	cache.MSet(batch, 0)
+	if n := cache.Len(); n != 3 {
+		t.Fatalf("expected the batch to be bounded by MaxEntryLimit, got %d items", n)
+	}
+	if found := cache.MGet([]string{"a", "b"}); len(found) != 0 {
+		t.Fatalf("expected older items to be evicted, got %v", found)
+	}
+}
+
+func TestZeroConfig(t *testing.T) {
+	cache, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.config.Expiration != DefaultExpiration {
+		t.Fatalf("expected default expiration, got %v", cache.config.Expiration)
+	}
+
+	for i := 0; i < 100; i = i + 1 {
+		cache.Set(strconv.Itoa(i), i, 0)
+	}
+	if n := cache.Len(); n != 100 {
+		t.Fatalf("expected an unlimited cache, got %d items", n)
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	configs := []Config{
+		{JanitorInterval: -1},
+		{ClockResolution: -1},
+		{Shards: -1},
+		{MaxBytes: -1},
+		{Policy: Policy(-1)},
+		{RefreshAhead: time.Second},
+		{SampleSize: 5, Policy: PolicyLFU},
+		{HighWaterMark: 0.9},
+		{MaxEntryLimit: 10, OnHighWater: func(int, int) {}},
+		{LowWaterMark: 0.8},
+		{RejectOnFull: true},
+		{OnStoreError: func(string, error) {}},
+		{WriteBehind: true},
+		{MaxConcurrentLoads: -1},
+		{ExpiredDebounce: time.Second},
+		{ExpiredDebounce: -1, OnExpired: func(string, interface{}) {}},
+		{ValueHasher: func(interface{}) uint64 { return 0 }},
+		{OnTypeMismatch: TypeMismatchPanic + 1},
+		{SourceRefresh: SourceRefresh{Fetch: func() (map[string]Entry, error) { return nil, nil }}},
+		{SourceRefresh: SourceRefresh{Interval: time.Second}},
+		{MinTTL: -1},
+		{Admission: true},
+	}
+	for _, config := range configs {
+		if _, err := New(config); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("expected ErrInvalidConfig for %+v, got %v", config, err)
+		}
+	}
+}
+
+func TestRemoveOldestEmpty(t *testing.T) {
+	for _, limit := range []int{0, -1} {
+		cache, err := New(Config{MaxEntryLimit: limit})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cache.removeOldest(nil)
+		if err := cache.Set("k", 1, 0); err != nil {
+			t.Fatal(err)
+		}
+		if !cache.Has("k") {
+			t.Fatalf("expected item to be stored with MaxEntryLimit %d", limit)
+		}
+	}
+}
+
+func TestPeek(t *testing.T) {
+	cache, err := New(Config{
+		MaxEntryLimit: 2,
+		Policy:        PolicyLFU,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("cold", 1, 0)
+	cache.Set("hot", 2, 0)
+	for i := 0; i < 5; i = i + 1 {
+		if v, ok := cache.Peek("cold"); !ok || v != 1 {
+			t.Fatalf("expected 1, got %v, %v", v, ok)
+		}
+	}
+	cache.Get("hot")
+	if s := cache.Stats(); s.Hits != 1 {
+		t.Fatalf("expected Peek not to count hits, got %d", s.Hits)
+	}
+
+	cache.Set("new", 3, 0)
+	if cache.Has("cold") || !cache.Has("hot") {
+		t.Fatal("expected the peeked key to stay the eviction candidate")
+	}
+
+	if _, ok := cache.Peek("missing"); ok {
+		t.Fatal("expected Peek to miss")
+	}
+}
+
+func TestGetAndDelete(t *testing.T) {
+	cache, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("k", 1, 0)
+	var winners int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i = i + 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, ok := cache.GetAndDelete("k"); ok && v == 1 {
+				atomic.AddInt32(&winners, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if winners != 1 {
+		t.Fatalf("expected exactly one caller to get the object, got %d", winners)
+	}
+	if cache.Has("k") {
+		t.Fatal("expected the key to be removed")
+	}
+}
+
+func TestDelValue(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("a", 1, 0)
+	cache.Set("expiring", 2, time.Second)
+	clock.Advance(2 * time.Second)
+
+	if v, ok := cache.DelValue("a"); !ok || v != 1 {
+		t.Fatalf("expected 1, got %v, %v", v, ok)
+	}
+	if cache.Has("a") {
+		t.Fatal("expected the key to be deleted")
+	}
+	if v, ok := cache.DelValue("a"); ok || v != nil {
+		t.Fatalf("expected a missing key to return nil, got %v, %v", v, ok)
+	}
+	if v, ok := cache.DelValue("expiring"); ok || v != nil {
+		t.Fatalf("expected an expired key to return nil, got %v, %v", v, ok)
+	}
+	if cache.Len() != 0 {
+		t.Fatal("expected the expired item to be deleted")
+	}
+}
+
+func TestDelMulti(t *testing.T) {
+	clock := newFakeClock()
+	var reasons []string
+	c, err := New(Config{
+		MaxEntryLimit: 10,
+		Clock:         clock,
+		OnEvicted: func(k string, _ interface{}, reason EvictReason) {
+			if reason == ReasonDeleted {
+				reasons = append(reasons, k)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.Set("a", 1, time.Minute)
+	c.Set("b", 2, time.Minute)
+	c.Set("c", 3, time.Minute)
+	c.Set("old", 4, time.Second)
+	clock.Advance(2 * time.Second)
+
+	if n := c.DelMulti("a", "missing", "old", "c", "a"); n != 2 {
+		t.Fatalf("expected 2 live items deleted, got %d", n)
+	}
+	if c.Len() != 1 {
+		t.Fatalf("expected 1 item left, got %d", c.Len())
+	}
+	if _, ok := c.Get("b"); !ok {
+		t.Fatal("expected b to stay")
+	}
+	if !reflect.DeepEqual(reasons, []string{"a", "old", "c"}) {
+		t.Fatalf("expected OnEvicted with ReasonDeleted for a, old and c, got %v", reasons)
+	}
+	if n := c.DelMulti(); n != 0 {
+		t.Fatalf("expected 0 without keys, got %d", n)
+	}
+}
+
+func TestDeleteFunc(t *testing.T) {
+	clock := newFakeClock()
+	var evicted []string
+	cache, err := New(Config{
+		Clock: clock,
+		OnEvicted: func(k string, v interface{}, reason EvictReason) {
+			if reason != ReasonDeleted {
+				t.Errorf("expected ReasonDeleted, got %v", reason)
+			}
+			evicted = append(evicted, k)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("a", "tenant1", 0)
+	cache.Set("b", "tenant2", 0)
+	cache.Set("c", "tenant1", 0)
+	cache.Set("d", 1, 0)
+	cache.Set("expiring", "tenant1", time.Second)
+	clock.Advance(2 * time.Second)
+
+	n := cache.DeleteFunc(func(key string, value interface{}) bool {
+		return value == "tenant1"
+	})
+	if n != 2 {
+		t.Fatalf("expected 2 items deleted, got %d", n)
+	}
+	if !reflect.DeepEqual(evicted, []string{"a", "c"}) {
+		t.Fatalf("expected OnEvicted for a and c, got %v", evicted)
+	}
+	if keys := cache.Keys(); !reflect.DeepEqual(keys, []string{"b", "d"}) {
+		t.Fatalf("expected b and d to be kept, got %v", keys)
+	}
+}
+
+func TestResize(t *testing.T) {
+	var evicted []string
+	cache, err := New(Config{
+		MaxEntryLimit: 10,
+		OnEvicted: func(k string, v interface{}, reason EvictReason) {
+			evicted = append(evicted, k)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i = i + 1 {
+		cache.Set(strconv.Itoa(i), i, 0)
+	}
+	if n := cache.Resize(7); n != 3 {
+		t.Fatalf("expected 3 evictions, got %d", n)
+	}
+	if want := []string{"0", "1", "2"}; !reflect.DeepEqual(evicted, want) {
+		t.Fatalf("expected %v to be evicted, got %v", want, evicted)
+	}
+	if n := cache.Len(); n != 7 {
+		t.Fatalf("expected 7 items, got %d", n)
+	}
+
+	cache.Set("new", 1, 0)
+	if n := cache.Len(); n != 7 {
+		t.Fatalf("expected the new limit to hold, got %d items", n)
+	}
+}
+
+func TestResizeRequiredLimit(t *testing.T) {
+	cache, err := New(Config{MaxEntryLimit: 2, RejectOnFull: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := cache.Resize(0); n != 0 {
+		t.Fatalf("expected no evictions, got %d", n)
+	}
+	if n := cache.Config().MaxEntryLimit; n != 2 {
+		t.Fatalf("expected the limit to be kept, got %d", n)
+	}
+	cache.Set("a", 1, 0)
+	cache.Set("b", 2, 0)
+	if err := cache.Set("c", 3, 0); !errors.Is(err, ErrCacheFull) {
+		t.Fatalf("expected ErrCacheFull, got %v", err)
+	}
+}
+
+func TestDeleteExpired(t *testing.T) {
+	clock := newFakeClock()
+	evicted := 0
+	cache, err := New(Config{
+		Expiration: time.Minute,
+		Clock:      clock,
+		OnEvicted: func(k string, v interface{}, reason EvictReason) {
+			evicted = evicted + 1
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("long", 1, time.Hour)
+	cache.Set("a", 2, time.Second)
+	cache.Set("b", 3, 2*time.Second)
+	cache.Set("c", 4, 10*time.Second)
+	clock.Advance(5 * time.Second)
+
+	if n := cache.DeleteExpired(); n != 2 {
+		t.Fatalf("expected 2 expired items, got %d", n)
+	}
+	if evicted != 2 {
+		t.Fatalf("expected OnEvicted for 2 items, got %d", evicted)
+	}
+	if n := cache.DeleteExpired(); n != 0 {
+		t.Fatalf("expected nothing left to expire, got %d", n)
+	}
+}
+
+func TestSlidingExpiration(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{
+		Expiration:        time.Minute,
+		Clock:             clock,
+		SlidingExpiration: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("k", 1, time.Second)
+	cache.Set("idle", 2, time.Second)
+	for i := 0; i < 5; i = i + 1 {
+		clock.Advance(800 * time.Millisecond)
+		if _, ok := cache.Get("k"); !ok {
+			t.Fatalf("expected item to slide, expired after %d Gets", i)
+		}
+	}
+	if cache.Has("idle") {
+		t.Fatal("expected idle item to expire")
+	}
+
+	clock.Advance(1100 * time.Millisecond)
+	if _, ok := cache.Get("k"); ok {
+		t.Fatal("expected item to expire without Gets")
+	}
+}
+
+func TestExpirationJitter(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{
+		Clock:            clock,
+		ExpirationJitter: time.Second,
+		Rand:             rand.New(rand.NewSource(1)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := clock.Now().Add(time.Minute).UnixNano()
+	expires := make(map[int64]bool)
+	for i := 0; i < 100; i = i + 1 {
+		k := strconv.Itoa(i)
+		cache.Set(k, i, time.Minute)
+		expire := cache.items[k].Value.(*pair).expire
+		if expire < base || expire >= base+int64(time.Second) {
+			t.Fatalf("expiration %d out of the jitter bound", expire-base)
+		}
+		expires[expire] = true
+	}
+	if len(expires) < 90 {
+		t.Fatalf("expected spread expirations, got %d distinct", len(expires))
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	cache, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("k", []int{1, 2}, 0)
+	if !cache.CompareAndSwap("k", []int{1, 2}, []int{3}, 0) {
+		t.Fatal("expected swap of an equal object")
+	}
+	if v, _ := cache.Get("k"); !reflect.DeepEqual(v, []int{3}) {
+		t.Fatalf("expected swapped object, got %v", v)
+	}
+
+	if cache.CompareAndSwap("k", []int{1, 2}, []int{4}, 0) {
+		t.Fatal("expected no swap of a different object")
+	}
+	if v, _ := cache.Get("k"); !reflect.DeepEqual(v, []int{3}) {
+		t.Fatalf("expected unchanged object, got %v", v)
+	}
+
+	if cache.CompareAndSwap("missing", nil, 1, 0) {
+		t.Fatal("expected no swap of a missing key")
+	}
+	if cache.Has("missing") {
+		t.Fatal("CompareAndSwap must not insert a missing key")
+	}
+}
+
+func TestTouchOnGet(t *testing.T) {
+	cache, err := New(Config{
+		MaxEntryLimit: 3,
+		TouchOnGet:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("hot", 1, 0)
+	cache.Set("cold", 2, 0)
+	cache.Set("b", 3, 0)
+	for i := 0; i < 3; i = i + 1 {
+		cache.Get("hot")
+		cache.Set(strconv.Itoa(i), i, 0)
+	}
+
+	if !cache.Has("hot") {
+		t.Fatal("expected the read key to survive eviction")
+	}
+	if cache.Has("cold") {
+		t.Fatal("expected the cold key to be evicted")
+	}
+}
+
+func TestSetWithCost(t *testing.T) {
+	cache, err := New(Config{MaxCost: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.SetWithCost("huge", 1, 11, 0); err != ErrCostTooHigh {
+		t.Fatalf("expected ErrCostTooHigh, got %v", err)
+	}
+	if cache.Has("huge") {
+		t.Fatal("expected a rejected item not to be stored")
+	}
+
+	cache.SetWithCost("a", 1, 3, 0)
+	cache.SetWithCost("b", 2, 3, 0)
+	cache.SetWithCost("c", 3, 3, 0)
+	cache.SetWithCost("d", 4, 8, 0)
+	if cache.Has("a") || cache.Has("b") || cache.Has("c") || !cache.Has("d") {
+		t.Fatalf("expected several items evicted to fit, got keys %v", cache.Keys())
+	}
+	if cache.cost != 8 {
+		t.Fatalf("expected total cost 8, got %d", cache.cost)
+	}
+
+	cache.SetWithCost("d", 4, 2, 0)
+	if cache.cost != 2 {
+		t.Fatalf("expected total cost 2 after overwrite, got %d", cache.cost)
+	}
+	cache.Del("d")
+	if cache.cost != 0 {
+		t.Fatalf("expected total cost 0 after Del, got %d", cache.cost)
+	}
+}
+
+func TestRename(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("tmp", 1, time.Minute)
+	cache.Set("perm", 2, 0)
+	clock.Advance(30 * time.Second)
+	if !cache.Rename("tmp", "perm") {
+		t.Fatal("expected Rename to succeed")
+	}
+	if cache.Has("tmp") {
+		t.Fatal("expected the old key to be gone")
+	}
+	v, expire, ok := cache.GetWithExpiration("perm")
+	if !ok || v != 1 {
+		t.Fatalf("expected the renamed object, got %v, %v", v, ok)
+	}
+	if d := expire.Sub(clock.Now()); d != 30*time.Second {
+		t.Fatalf("expected the remaining TTL to be kept, got %v", d)
+	}
+	if n := cache.Len(); n != 1 {
+		t.Fatalf("expected the overwritten key to be removed, got %d items", n)
+	}
+
+	if cache.Rename("missing", "x") {
+		t.Fatal("expected Rename of a missing key to fail")
+	}
+}
+
+func TestClone(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("a", 1, time.Minute)
+	cache.Set("b", 2, 0)
+	cache.Set("expiring", 3, time.Second)
+	clock.Advance(2 * time.Second)
+
+	clone, err := cache.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := clone.Keys(); !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Fatalf("expected live keys to be cloned, got %v", keys)
+	}
+	_, want, _ := cache.GetWithExpiration("a")
+	if _, got, _ := clone.GetWithExpiration("a"); !got.Equal(want) {
+		t.Fatalf("expected expiration %v, got %v", want, got)
+	}
+
+	clone.Set("c", 4, 0)
+	clone.Del("a")
+	if !cache.Has("a") || cache.Has("c") {
+		t.Fatal("expected the original to be unchanged by the clone")
+	}
+}
+
+func TestCloneResized(t *testing.T) {
+	cache, err := New(Config{MaxEntryLimit: 2, LowWaterMark: 0.5, Admission: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("a", 1, 0)
+	cache.Resize(0)
+
+	clone, err := cache.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := clone.Get("a"); !ok || v != 1 {
+		t.Fatalf("expected the item in the clone, got %v, %v", v, ok)
+	}
+	if n := clone.Config().MaxEntryLimit; n != 2 {
+		t.Fatalf("expected the limit in the clone, got %d", n)
+	}
+}
+
+func TestSetWithDeadline(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.SetWithDeadline("past", 1, clock.Now().Add(-time.Second)); err != ErrPastDeadline {
+		t.Fatalf("expected ErrPastDeadline, got %v", err)
+	}
+	if cache.Has("past") {
+		t.Fatal("expected a past deadline not to be stored")
+	}
+
+	near := clock.Now().Add(time.Second)
+	far := clock.Now().Add(10 * 365 * 24 * time.Hour)
+	cache.SetWithDeadline("near", 2, near)
+	cache.SetWithDeadline("far", 3, far)
+	if _, expire, _ := cache.GetWithExpiration("near"); !expire.Equal(near) {
+		t.Fatalf("expected expiration %v, got %v", near, expire)
+	}
+
+	clock.Advance(2 * time.Second)
+	if cache.DeleteExpired() != 1 || cache.Has("near") {
+		t.Fatal("expected the near deadline to expire")
+	}
+	if !cache.Has("far") {
+		t.Fatal("expected the far deadline to be alive")
+	}
+}
+
+func TestSampledEviction(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{
+		MaxEntryLimit: 100,
+		Clock:         clock,
+		SampleSize:    10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i = i + 1 {
+		cache.Set(strconv.Itoa(i), i, 0)
+		clock.Advance(time.Second)
+	}
+	for i := 100; i < 150; i = i + 1 {
+		cache.Set(strconv.Itoa(i), i, 0)
+	}
+
+	oldest, newest := 0, 0
+	for i := 0; i < 25; i = i + 1 {
+		if cache.Has(strconv.Itoa(i)) {
+			oldest = oldest + 1
+		}
+		if cache.Has(strconv.Itoa(i + 75)) {
+			newest = newest + 1
+		}
+	}
+	if oldest >= newest {
+		t.Fatalf("expected old items to be evicted first, %d of the oldest and %d of the newest survived", oldest, newest)
+	}
+}
+
+func BenchmarkGetExpiredLazyDelete(b *testing.B) {
+	cache, _ := New(Config{MaxEntryLimit: 1000})
+	benchmarkParallel(b,
+		func(k string, x interface{}) { cache.Set(k, x, time.Nanosecond) },
+		func(k string) { cache.Get(k) })
+}
+
+func BenchmarkGetExpiredKept(b *testing.B) {
+	cache, _ := New(Config{MaxEntryLimit: 1000, KeepExpiredOnGet: true})
+	benchmarkParallel(b,
+		func(k string, x interface{}) { cache.Set(k, x, time.Nanosecond) },
+		func(k string) { cache.Get(k) })
+}
+
+func BenchmarkGetStrictLRU(b *testing.B) {
+	cache, _ := New(Config{MaxEntryLimit: 1000, TouchOnGet: true})
+	benchmarkParallel(b,
+		func(k string, x interface{}) { cache.Set(k, x, 0) },
+		func(k string) { cache.Get(k) })
+}
+
+func BenchmarkGetSampledLRU(b *testing.B) {
+	cache, _ := New(Config{MaxEntryLimit: 1000, SampleSize: 5})
+	benchmarkParallel(b,
+		func(k string, x interface{}) { cache.Set(k, x, 0) },
+		func(k string) { cache.Get(k) })
+}
+
+func BenchmarkGetClockResolution(b *testing.B) {
+	cache, _ := New(Config{MaxEntryLimit: 1000, ClockResolution: time.Millisecond})
+	defer cache.Close()
+	benchmarkParallel(b,
+		func(k string, x interface{}) { cache.Set(k, x, 0) },
+		func(k string) { cache.Get(k) })
+}
+
+func BenchmarkGetClock(b *testing.B) {
+	cache, _ := New(Config{MaxEntryLimit: 1000})
+	benchmarkParallel(b,
+		func(k string, x interface{}) { cache.Set(k, x, 0) },
+		func(k string) { cache.Get(k) })
+}
+
+func TestClockResolution(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{Clock: clock, ClockResolution: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("a", 1, 10*time.Second)
+	clock.Advance(9 * time.Second)
+	time.Sleep(10 * time.Millisecond)
+	if _, ok := cache.Get("a"); !ok {
+		t.Fatal("expected a before it expires")
+	}
+	clock.Advance(2 * time.Second)
+	waitFor(t, func() bool {
+		_, ok := cache.Get("a")
+		return !ok
+	})
+
+	// The clock is not read any more after Close.
+	cache.Close()
+	time.Sleep(10 * time.Millisecond)
+	before := cache.now()
+	clock.Advance(time.Hour)
+	time.Sleep(10 * time.Millisecond)
+	if cache.now() != before {
+		t.Fatal("expected the clock to stop at Close")
+	}
+}
+
+func TestClockResolutionExpiration(t *testing.T) {
+	const resolution = 10 * time.Millisecond
+	cache, err := New(Config{ClockResolution: resolution})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	cache.Set("a", 1, 5*resolution)
+	if _, ok := cache.Get("a"); !ok {
+		t.Fatal("expected a right after it was set")
+	}
+	time.Sleep(5*resolution + 3*resolution)
+	if _, ok := cache.Get("a"); ok {
+		t.Fatal("expected a to be expired within the resolution")
+	}
+}
+
+func TestDeletePrefix(t *testing.T) {
+	var evicted []string
+	cache, err := New(Config{
+		OnEvicted: func(k string, v interface{}, reason EvictReason) {
+			evicted = append(evicted, k)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []string{"user:1", "user:2", "session:1", "token:1", "token:2"} {
+		cache.Set(k, 1, 0)
+	}
+	if n := cache.DeletePrefix("user:"); n != 2 {
+		t.Fatalf("expected 2 deleted items, got %d", n)
+	}
+	if want := []string{"user:1", "user:2"}; !reflect.DeepEqual(evicted, want) {
+		t.Fatalf("expected OnEvicted for %v, got %v", want, evicted)
+	}
+
+	n, err := cache.DeletePattern("tok*:?")
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 deleted items, got %d, %v", n, err)
+	}
+	if keys := cache.Keys(); !reflect.DeepEqual(keys, []string{"session:1"}) {
+		t.Fatalf("expected only session:1 to be left, got %v", keys)
+	}
+
+	if _, err := cache.DeletePattern("["); err == nil {
+		t.Fatal("expected an error for a bad pattern")
+	}
+}
+
+func TestEvictReason(t *testing.T) {
+	clock := newFakeClock()
+	reasons := make(map[string]EvictReason)
+	cache, err := New(Config{
+		MaxEntryLimit: 2,
+		Clock:         clock,
+		OnEvicted: func(k string, v interface{}, reason EvictReason) {
+			reasons[fmt.Sprint(k, "=", v)] = reason
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("a", 1, 0)
+	cache.Set("a", 2, 0)
+	cache.Set("b", 3, 0)
+	cache.Set("c", 4, 0)
+	cache.Del("b")
+	cache.Set("d", 5, time.Second)
+	clock.Advance(2 * time.Second)
+	cache.DeleteExpired()
+
+	want := map[string]EvictReason{
+		"a=1": ReasonReplaced,
+		"a=2": ReasonCapacity,
+		"b=3": ReasonDeleted,
+		"d=5": ReasonExpired,
+	}
+	if !reflect.DeepEqual(reasons, want) {
+		t.Fatalf("expected %v, got %v", want, reasons)
+	}
+}
+
+func TestSetMany(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{
+		MaxEntryLimit: 3,
+		Expiration:    time.Minute,
+		Clock:         clock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.SetMany(map[string]Entry{
+		"short":   {Value: 1, TTL: time.Second},
+		"long":    {Value: 2, TTL: time.Hour},
+		"default": {Value: 3},
+	})
+
+	clock.Advance(2 * time.Second)
+	if cache.Has("short") || !cache.Has("long") || !cache.Has("default") {
+		t.Fatal("expected only short to expire")
+	}
+	clock.Advance(time.Minute)
+	if cache.Has("default") || !cache.Has("long") {
+		t.Fatal("expected default to expire with Config.Expiration")
+	}
+
+	batch := make(map[string]Entry)
+	for i := 0; i < 10; i = i + 1 {
+		batch[strconv.Itoa(i)] = Entry{Value: i}
+	}
+	cache.SetMany(batch)
+	if n := cache.Len(); n != 3 {
+		t.Fatalf("expected the batch to be bounded by MaxEntryLimit, got %d items", n)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("fresh", 1, time.Minute)
+	cache.Set("near", 2, time.Second)
+	cache.Set("forever", 3, NoExpiration)
+	clock.Advance(900 * time.Millisecond)
+
+	if d := cache.TTL("fresh"); d != time.Minute-900*time.Millisecond {
+		t.Fatalf("unexpected TTL of fresh: %v", d)
+	}
+	if d := cache.TTL("near"); d != 100*time.Millisecond {
+		t.Fatalf("unexpected TTL of near: %v", d)
+	}
+	if d := cache.TTL("forever"); d != -1 {
+		t.Fatalf("expected -1 for no expiration, got %v", d)
+	}
+	if d := cache.TTL("missing"); d != -2 {
+		t.Fatalf("expected -2 for a missing key, got %v", d)
+	}
+	clock.Advance(time.Second)
+	if d := cache.TTL("near"); d != -2 {
+		t.Fatalf("expected -2 for an expired key, got %v", d)
+	}
+}
+
+func TestMaxKeyLen(t *testing.T) {
+	cache, err := New(Config{MaxKeyLen: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.Set("abcd", 1, 0); err != nil {
+		t.Fatalf("expected a key at the limit to be set, got %v", err)
+	}
+	if err := cache.Set("abcde", 1, 0); err != ErrKeyTooLong {
+		t.Fatalf("expected ErrKeyTooLong from Set, got %v", err)
+	}
+	if err := cache.Add("abcde", 1, 0); err != ErrKeyTooLong {
+		t.Fatalf("expected ErrKeyTooLong from Add, got %v", err)
+	}
+	if err := cache.Replace("abcde", 1, 0); err != ErrKeyTooLong {
+		t.Fatalf("expected ErrKeyTooLong from Replace, got %v", err)
+	}
+	if _, ok := cache.Get("abcde"); ok || cache.Len() != 1 {
+		t.Fatal("expected the long key not to be stored")
+	}
+
+	unlimited, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := unlimited.Set(strings.Repeat("k", 1000), 1, 0); err != nil {
+		t.Fatalf("expected no limit by default, got %v", err)
+	}
+}
+
+func TestEntryAndByteLimits(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{
+		MaxEntryLimit: 3,
+		MaxBytes:      100,
+		Clock:         clock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Bound by count.
+	for i := 0; i < 5; i = i + 1 {
+		cache.SetWithSize(strconv.Itoa(i), i, 10, 0)
+	}
+	if cache.Len() != 3 || cache.bytes != 30 || cache.Has("1") {
+		t.Fatalf("expected 3 items of 30 bytes, got %d of %d", cache.Len(), cache.bytes)
+	}
+
+	// Bound by size.
+	cache.SetWithSize("big", 0, 85, 0)
+	if cache.Len() != 2 || cache.bytes != 95 || cache.Has("2") || cache.Has("3") {
+		t.Fatalf("expected 2 items of 95 bytes, got %d of %d", cache.Len(), cache.bytes)
+	}
+
+	// Bound by both: a new item at the count limit, then an overwrite
+	// growing past the size limit.
+	cache.SetWithSize("small", 0, 5, 0)
+	cache.SetWithSize("more", 0, 5, 0)
+	if cache.Len() != 3 || cache.bytes != 95 || cache.Has("4") {
+		t.Fatalf("expected 3 items of 95 bytes, got %d of %d", cache.Len(), cache.bytes)
+	}
+	cache.SetWithSize("small", 0, 15, 0)
+	if cache.Len() != 2 || cache.bytes != 20 || cache.Has("big") {
+		t.Fatalf("expected 2 items of 20 bytes, got %d of %d", cache.Len(), cache.bytes)
+	}
+
+	// Expired items free their bytes.
+	cache.SetWithSize("expiring", 0, 10, time.Second)
+	clock.Advance(2 * time.Second)
+	if cache.Len() != 2 || cache.bytes != 20 {
+		t.Fatalf("expected 2 items of 20 bytes, got %d of %d", cache.Len(), cache.bytes)
+	}
+}
+
+func TestGetBytes(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.SetBytes("blob", []byte("data"), 0)
+	cache.SetBytes("expiring", []byte("data"), time.Second)
+	cache.Set("string", "data", 0)
+	clock.Advance(2 * time.Second)
+
+	if b, ok := cache.GetBytes("blob"); !ok || string(b) != "data" {
+		t.Fatalf("expected data, got %q, %v", b, ok)
+	}
+	if b, ok := cache.GetBytes("missing"); ok || b != nil {
+		t.Fatalf("expected a miss, got %q, %v", b, ok)
+	}
+	if b, ok := cache.GetBytes("expiring"); ok || b != nil {
+		t.Fatalf("expected an expired item to miss, got %q, %v", b, ok)
+	}
+	if b, ok := cache.GetBytes("string"); ok || b != nil {
+		t.Fatalf("expected a string object to miss, got %q, %v", b, ok)
+	}
+}
+
+func TestPairReuse(t *testing.T) {
+	cache, err := New(Config{MaxEntryLimit: 1, Policy: PolicyLFU})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.SetWithCost("a", []byte("old"), 5, time.Minute)
+	cache.Get("a")
+	cache.mu.Lock()
+	old := cache.items["a"].Value.(*pair)
+	cache.remove(cache.items["a"], ReasonDeleted)
+	cache.unlock()
+	if old.Object != nil || old.key != "" || old.freq != 0 || old.cost != 0 {
+		t.Fatalf("expected a removed pair to be cleared, got %+v", old)
+	}
+
+	cache.Set("b", "new", 0)
+	cache.Set("c", "newer", 0)
+	p := cache.items["c"].Value.(*pair)
+	if p.Object != "newer" || p.key != "c" || p.freq != 0 || p.cost != 0 || p.size != 0 {
+		t.Fatalf("expected a fresh pair, got %+v", p)
+	}
+	if cache.cost != 0 || cache.bytes != 0 {
+		t.Fatalf("expected no cost or bytes left, got %d and %d", cache.cost, cache.bytes)
+	}
+	if v, ok := cache.GetAndDelete("c"); !ok || v != "newer" {
+		t.Fatalf("expected newer, got %v, %v", v, ok)
+	}
+}
+
+func BenchmarkSetChurn(b *testing.B) {
+	cache, err := New(Config{MaxEntryLimit: 1000})
+	if err != nil {
+		b.Fatal(err)
+	}
+	keys := make([]string, 10000)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i = i + 1 {
+		cache.Set(keys[i%len(keys)], i, 0)
+	}
+}
+
+func TestSetReadOnly(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("a", 1, 0)
+	cache.Set("expiring", 2, time.Second)
+
+	cache.SetReadOnly(true)
+	if err := cache.Set("b", 1, 0); err != ErrReadOnly {
+		t.Fatalf("expected ErrReadOnly from Set, got %v", err)
+	}
+	if err := cache.Add("b", 1, 0); err != ErrReadOnly {
+		t.Fatalf("expected ErrReadOnly from Add, got %v", err)
+	}
+	if err := cache.Replace("a", 2, 0); err != ErrReadOnly {
+		t.Fatalf("expected ErrReadOnly from Replace, got %v", err)
+	}
+	if cache.Del("a") {
+		t.Fatal("expected Del to fail")
+	}
+	if v, ok := cache.Get("a"); !ok || v != 1 {
+		t.Fatalf("expected reads to be served, got %v, %v", v, ok)
+	}
+
+	clock.Advance(2 * time.Second)
+	if _, ok := cache.Get("expiring"); ok {
+		t.Fatal("expected an expired item to miss")
+	}
+	if cache.DeleteExpired() != 0 || cache.Len() != 2 {
+		t.Fatal("expected expired items to be kept while read-only")
+	}
+
+	cache.SetReadOnly(false)
+	if cache.Len() != 1 {
+		t.Fatalf("expected expired items to be removed, got %d items", cache.Len())
+	}
+	if err := cache.Set("b", 1, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWarm(t *testing.T) {
+	entries := make([]WarmEntry, 0, 10)
+	for i := 0; i < 10; i = i + 1 {
+		entries = append(entries, WarmEntry{Key: strconv.Itoa(i), Value: i, TTL: NoExpiration})
+	}
+	store := newFakeStore()
+	cache, err := New(Config{MaxEntryLimit: 4, Warm: entries, Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if keys := cache.Keys(); !reflect.DeepEqual(keys, []string{"6", "7", "8", "9"}) {
+		t.Fatalf("expected the last 4 entries, got %v", keys)
+	}
+	if v, ok := cache.Get("9"); !ok || v != 9 {
+		t.Fatalf("expected 9, got %v, %v", v, ok)
+	}
+	if store.len() != 0 {
+		t.Fatal("expected warmed entries not to be written to the store")
+	}
+
+	cache.Warm([]WarmEntry{{Key: "a", Value: "x"}})
+	if v, ok := cache.Get("a"); !ok || v != "x" || cache.Len() != 4 {
+		t.Fatalf("expected a to be warmed, got %v, %v", v, ok)
+	}
+}
+
+func TestAccessCount(t *testing.T) {
+	cache, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.AccessCount("a"); ok {
+		t.Fatal("expected a missing key")
+	}
+
+	cache.Set("a", 1, 0)
+	for i := 0; i < 3; i = i + 1 {
+		cache.Get("a")
+	}
+	cache.Peek("a")
+	cache.MGet([]string{"a"})
+	if n, ok := cache.AccessCount("a"); !ok || n != 4 {
+		t.Fatalf("expected 4 accesses, got %d, %v", n, ok)
+	}
+
+	cache.Touch("a", time.Minute)
+	if n, _ := cache.AccessCount("a"); n != 4 {
+		t.Fatalf("expected Touch to keep the count, got %d", n)
+	}
+
+	cache.Set("a", 2, 0)
+	if n, ok := cache.AccessCount("a"); !ok || n != 0 {
+		t.Fatalf("expected Set to reset the count, got %d, %v", n, ok)
+	}
+}
+
+func TestKeepExpiredOnGet(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{Clock: clock, KeepExpiredOnGet: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("a", 1, time.Second)
+	clock.Advance(2 * time.Second)
+
+	if v, ok := cache.Get("a"); ok || v != nil {
+		t.Fatalf("expected an expired item to miss, got %v, %v", v, ok)
+	}
+	if _, ok := cache.items["a"]; !ok {
+		t.Fatal("expected the expired item to be kept by Get")
+	}
+	if n := cache.DeleteExpired(); n != 1 {
+		t.Fatalf("expected DeleteExpired to remove it, got %d", n)
+	}
+}
+
+func TestGetMultiTouch(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{MaxEntryLimit: 2, Clock: clock, TouchOnGet: true, SlidingExpiration: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("a", 1, time.Minute)
+	cache.Set("b", 2, time.Minute)
+
+	clock.Advance(40 * time.Second)
+	if found, _ := cache.GetMulti([]string{"a"}); found["a"] != 1 {
+		t.Fatalf("expected a, got %v", found)
+	}
+	cache.Set("c", 3, time.Minute)
+	if !cache.Has("a") || cache.Has("b") {
+		t.Fatal("expected GetMulti to move a to the back of the LRU list")
+	}
+	clock.Advance(40 * time.Second)
+	if !cache.Has("a") {
+		t.Fatal("expected GetMulti to extend the expiration of a")
+	}
+}
+
+func TestGetMulti(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("a", 1, 0)
+	cache.Set("b", 2, 0)
+	cache.Set("expiring", 3, time.Second)
+	clock.Advance(2 * time.Second)
+
+	found, missing := cache.GetMulti([]string{"a", "absent", "expiring", "b"})
+	if !reflect.DeepEqual(found, map[string]interface{}{"a": 1, "b": 2}) {
+		t.Fatalf("expected a and b to be found, got %v", found)
+	}
+	if !reflect.DeepEqual(missing, []string{"absent", "expiring"}) {
+		t.Fatalf("expected absent and expiring to be missing, got %v", missing)
+	}
+	if s := cache.Stats(); s.Hits != 2 || s.Misses != 2 {
+		t.Fatalf("expected 2 hits and 2 misses, got %+v", s)
+	}
+}
+
+func TestRejectOnFull(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{MaxEntryLimit: 3, RejectOnFull: true, Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("a", 1, 0)
+	cache.Set("b", 2, 0)
+	cache.Set("expiring", 3, time.Second)
+
+	if err := cache.Set("c", 3, 0); err != ErrCacheFull {
+		t.Fatalf("expected ErrCacheFull, got %v", err)
+	}
+	cache.MSet(map[string]interface{}{"d": 4}, 0)
+	if cache.Has("c") || cache.Has("d") || cache.Len() != 3 {
+		t.Fatal("expected no change to a full cache")
+	}
+	if err := cache.Set("a", 10, 0); err != nil {
+		t.Fatalf("expected an update to succeed, got %v", err)
+	}
+	if v, _ := cache.Get("a"); v != 10 {
+		t.Fatalf("expected the updated value, got %v", v)
+	}
+
+	clock.Advance(2 * time.Second)
+	if err := cache.Set("c", 3, 0); err != nil {
+		t.Fatalf("expected room after the expired item is swept, got %v", err)
+	}
+	if !cache.Has("a") || !cache.Has("b") || !cache.Has("c") {
+		t.Fatal("expected no item to be evicted")
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("list", []string{"a"}, time.Minute)
+
+	ok := cache.Update("list", func(old interface{}, found bool) (interface{}, bool) {
+		if !found {
+			t.Error("expected list to be found")
+		}
+		return append(old.([]string), "b"), true
+	})
+	if !ok {
+		t.Fatal("expected the update to be stored")
+	}
+	if v, _ := cache.Get("list"); !reflect.DeepEqual(v, []string{"a", "b"}) {
+		t.Fatalf("expected [a b], got %v", v)
+	}
+	if ttl := cache.TTL("list"); ttl != time.Minute {
+		t.Fatalf("expected the expiration to be kept, got %v", ttl)
+	}
+
+	ok = cache.Update("new", func(old interface{}, found bool) (interface{}, bool) {
+		if found || old != nil {
+			t.Errorf("expected new to be absent, got %v, %v", old, found)
+		}
+		return 1, true
+	})
+	if v, _ := cache.Get("new"); !ok || v != 1 {
+		t.Fatalf("expected new to be created, got %v, %v", v, ok)
+	}
+
+	ok = cache.Update("list", func(old interface{}, found bool) (interface{}, bool) {
+		return nil, false
+	})
+	if ok || cache.Has("list") {
+		t.Fatal("expected list to be deleted")
+	}
+}
+
+func TestGetOrDefault(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("a", 1, time.Minute)
+	cache.Set("expiring", 2, time.Second)
+	clock.Advance(2 * time.Second)
+
+	if v := cache.GetOrDefault("a", 0); v != 1 {
+		t.Fatalf("expected 1, got %v", v)
+	}
+	if v := cache.GetOrDefault("missing", 0); v != 0 {
+		t.Fatalf("expected the default, got %v", v)
+	}
+	if v := cache.GetOrDefault("expiring", 0); v != 0 {
+		t.Fatalf("expected the default for an expired item, got %v", v)
+	}
+	if cache.Has("missing") || cache.Has("expiring") {
+		t.Fatal("expected the default not to be stored")
+	}
+}
+
+func TestSetIfGreater(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, ok := cache.SetIfGreater("seq", 5, time.Minute); !ok || v != 5 {
+		t.Fatalf("expected the first value to be set, got %d, %v", v, ok)
+	}
+	if v, ok := cache.SetIfGreater("seq", 7, time.Minute); !ok || v != 7 {
+		t.Fatalf("expected a larger value to be set, got %d, %v", v, ok)
+	}
+	if v, ok := cache.SetIfGreater("seq", 7, time.Minute); ok || v != 7 {
+		t.Fatalf("expected an equal value not to be set, got %d, %v", v, ok)
+	}
+	if v, ok := cache.SetIfGreater("seq", 3, time.Minute); ok || v != 7 {
+		t.Fatalf("expected a smaller value not to be set, got %d, %v", v, ok)
+	}
+	if v, _ := cache.Get("seq"); v != int64(7) {
+		t.Fatalf("expected 7 to be stored, got %v", v)
+	}
+
+	clock.Advance(2 * time.Minute)
+	if v, ok := cache.SetIfGreater("seq", 1, time.Minute); !ok || v != 1 {
+		t.Fatalf("expected an expired value to be replaced, got %d, %v", v, ok)
+	}
+
+	cache.Set("name", "x", 0)
+	if v, ok := cache.SetIfGreater("name", 1, 0); ok || v != 0 {
+		t.Fatalf("expected a non-int64 object to be kept, got %d, %v", v, ok)
+	}
+}
+
+func TestNilValue(t *testing.T) {
+	for _, copyOnGet := range []bool{false, true} {
+		cache, err := New(Config{CopyOnGet: copyOnGet})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cache.Set("none", nil, time.Minute)
+
+		if v, ok := cache.Get("none"); !ok || v != nil {
+			t.Fatalf("expected a cached nil, got %v, %v", v, ok)
+		}
+		if v, ok := cache.Get("missing"); ok || v != nil {
+			t.Fatalf("expected a miss, got %v, %v", v, ok)
+		}
+		if !cache.Has("none") {
+			t.Fatal("expected Has to report a cached nil")
+		}
+		if v := cache.GetOrDefault("none", 1); v != nil {
+			t.Fatalf("expected the cached nil over the default, got %v", v)
+		}
+		found, missing := cache.GetMulti([]string{"none", "missing"})
+		if v, ok := found["none"]; !ok || v != nil || !reflect.DeepEqual(missing, []string{"missing"}) {
+			t.Fatalf("expected none to be found and missing missing, got %v, %v", found, missing)
+		}
+
+		calls := 0
+		loader := func() (interface{}, error) {
+			calls = calls + 1
+			return nil, nil
+		}
+		cache.GetWithLoader("loaded", time.Minute, loader)
+		if v, err := cache.GetWithLoader("loaded", time.Minute, loader); err != nil || v != nil || calls != 1 {
+			t.Fatalf("expected a nil result to be cached, got %v, %v after %d calls", v, err, calls)
+		}
+	}
+}
+
+func TestConfig(t *testing.T) {
+	cache, err := New(Config{MaxEntryLimit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := cache.Config()
+	if config.Expiration != DefaultExpiration {
+		t.Fatalf("expected the default expiration, got %v", config.Expiration)
+	}
+	if config.StatsBuckets != DefaultStatsBuckets || config.FlushInterval != DefaultFlushInterval {
+		t.Fatalf("expected the defaults, got %d, %v", config.StatsBuckets, config.FlushInterval)
+	}
+	if config.Clock == nil {
+		t.Fatal("expected the clock in use")
+	}
+
+	config.MaxEntryLimit = 1
+	if cache.Config().MaxEntryLimit != 10 {
+		t.Fatal("expected the config to be a copy")
+	}
+	cache.Resize(5)
+	if n := cache.Config().MaxEntryLimit; n != 5 {
+		t.Fatalf("expected the resized limit, got %d", n)
+	}
+}
+
+func TestGetVersioned(t *testing.T) {
+	cache, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1, err := cache.SetVersioned("a", 1, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, v, ok := cache.GetVersioned("a")
+	if !ok || x != 1 || v != v1 {
+		t.Fatalf("expected 1 at version %d, got %v at %d, %v", v1, x, v, ok)
+	}
+
+	cache.Get("a")
+	cache.Touch("a", time.Hour)
+	if _, v, _ := cache.GetVersioned("a"); v != v1 {
+		t.Fatalf("expected Get and Touch to keep version %d, got %d", v1, v)
+	}
+
+	cache.Set("b", 2, time.Minute)
+	cache.Set("a", 1, time.Minute)
+	_, v2, _ := cache.GetVersioned("a")
+	if v2 <= v1 {
+		t.Fatalf("expected an overwrite to increase version %d, got %d", v1, v2)
+	}
+	if v3, _ := cache.SetVersioned("a", 3, time.Minute); v3 <= v2 {
+		t.Fatalf("expected version above %d, got %d", v2, v3)
+	}
+	if _, _, ok := cache.GetVersioned("missing"); ok {
+		t.Fatal("expected a miss")
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{Clock: clock, Expiration: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("a", 1, time.Minute)
+	cache.Set("b", 2, time.Minute)
+	cache.Set("old", 3, time.Second)
+	clock.Advance(30 * time.Second)
+
+	if !cache.Refresh("a") {
+		t.Fatal("expected Refresh to succeed on a live key")
+	}
+	if ttl := cache.TTL("a"); ttl != time.Hour {
+		t.Fatalf("expected the default TTL, got %v", ttl)
+	}
+	if cache.list.Back().Value.(*pair).key != "a" {
+		t.Fatal("expected the refreshed key at the back of the list")
+	}
+	if cache.Refresh("missing") {
+		t.Fatal("expected Refresh to fail on a missing key")
+	}
+	if cache.Refresh("old") || cache.Has("old") {
+		t.Fatal("expected Refresh not to resurrect an expired key")
+	}
+}
+
+func TestGetAllowStale(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{Clock: clock, StaleGrace: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("a", 1, time.Second)
+
+	if v, stale, ok := cache.GetAllowStale("a"); !ok || stale || v != 1 {
+		t.Fatalf("expected a live value, got %v, %v, %v", v, stale, ok)
+	}
+
+	clock.Advance(2 * time.Second)
+	if _, ok := cache.Get("a"); ok {
+		t.Fatal("expected Get to miss an expired item")
+	}
+	cache.DeleteExpired()
+	if v, stale, ok := cache.GetAllowStale("a"); !ok || !stale || v != 1 {
+		t.Fatalf("expected a stale value within the grace, got %v, %v, %v", v, stale, ok)
+	}
+
+	clock.Advance(time.Minute)
+	cache.DeleteExpired()
+	if _, _, ok := cache.GetAllowStale("a"); ok {
+		t.Fatal("expected the item to be removed after the grace")
+	}
+	if _, _, ok := cache.GetAllowStale("missing"); ok {
+		t.Fatal("expected a miss")
+	}
+}
+
+func TestInitialCapacity(t *testing.T) {
+	cache, err := New(Config{InitialCapacity: 100, MaxEntryLimit: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i = i + 1 {
+		cache.Set(strconv.Itoa(i), i, 0)
+	}
+	if n := cache.Len(); n != 50 {
+		t.Fatalf("expected 50 items, got %d", n)
+	}
+	if v, ok := cache.Get("199"); !ok || v != 199 {
+		t.Fatalf("expected 199, got %v, %v", v, ok)
+	}
+
+	sharded, err := NewSharded(Config{InitialCapacity: 100, Shards: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := sharded.shards[0].config.InitialCapacity; c != 25 {
+		t.Fatalf("expected 25 per shard, got %d", c)
+	}
+}
+
+func benchmarkBulkLoad(b *testing.B, capacity int) {
+	keys := make([]string, 10000)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i = i + 1 {
+		cache, _ := New(Config{InitialCapacity: capacity})
+		for j, k := range keys {
+			cache.Set(k, j, 0)
+		}
+	}
+}
+
+func BenchmarkBulkLoad(b *testing.B) {
+	benchmarkBulkLoad(b, 0)
+}
+
+func BenchmarkBulkLoadInitialCapacity(b *testing.B) {
+	benchmarkBulkLoad(b, 10000)
+}
+
+func TestCompact(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10000; i = i + 1 {
+		cache.Set(strconv.Itoa(i), i, time.Minute)
+	}
+	for i := 10; i < 10000; i = i + 1 {
+		cache.Del(strconv.Itoa(i))
+	}
+	cache.Set("expiring", 1, time.Second)
+	clock.Advance(2 * time.Second)
+
+	before := reflect.ValueOf(cache.items).Pointer()
+	cache.Compact()
+	if reflect.ValueOf(cache.items).Pointer() == before {
+		t.Fatal("expected the item map to be rebuilt")
+	}
+	if n := cache.Len(); n != 10 {
+		t.Fatalf("expected 10 items, got %d", n)
+	}
+	if len(cache.heap) != 10 || cap(cache.heap) > 16 {
+		t.Fatalf("expected a heap of 10, got len %d cap %d", len(cache.heap), cap(cache.heap))
+	}
+	for i := 0; i < 10; i = i + 1 {
+		if v, ok := cache.Get(strconv.Itoa(i)); !ok || v != i {
+			t.Fatalf("expected %d, got %v, %v", i, v, ok)
+		}
+	}
+	cache.Set("new", 1, time.Minute)
+	if !cache.Del("0") || !cache.Has("new") {
+		t.Fatal("expected the cache to work after Compact")
+	}
+}
+
+func TestReplaceAll(t *testing.T) {
+	reasons := make(map[string]EvictReason)
+	var mu sync.Mutex
+	cache, err := New(Config{
+		MaxEntryLimit: 100,
+		OnEvicted: func(k string, v interface{}, r EvictReason) {
+			mu.Lock()
+			reasons[k] = r
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i = i + 1 {
+		cache.Set("old"+strconv.Itoa(i), i, time.Minute)
+	}
+	entries := make(map[string]Entry)
+	for i := 0; i < 99; i = i + 1 {
+		entries["new"+strconv.Itoa(i)] = Entry{Value: i, TTL: time.Minute}
+	}
+	entries["old0"] = Entry{Value: -1, TTL: time.Minute}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			old, new := 0, 0
+			cache.Range(func(k string, v interface{}) bool {
+				if strings.HasPrefix(k, "new") {
+					new = new + 1
+				} else {
+					old = old + 1
+				}
+				return true
+			})
+			if !(old == 100 && new == 0) && !(old == 1 && new == 99) {
+				t.Errorf("expected the old or the new items, got %d old and %d new", old, new)
+				return
+			}
+		}
+	}()
+	cache.ReplaceAll(entries)
+	close(stop)
+	<-done
+
+	if n := cache.Len(); n != 100 {
+		t.Fatalf("expected 100 items, got %d", n)
+	}
+	if cache.Has("old1") {
+		t.Fatal("expected the old items to be dropped")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if reasons["old0"] != ReasonReplaced || reasons["old1"] != ReasonDeleted {
+		t.Fatalf("expected old0 replaced and old1 deleted, got %v and %v", reasons["old0"], reasons["old1"])
+	}
+}
+
+func TestExpirationOverflow(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{Clock: clock, ExpirationJitter: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := clock.Now().UnixNano()
+	durations := []time.Duration{
+		time.Duration(math.MaxInt64 - now - 1),
+		time.Duration(math.MaxInt64 - now),
+		time.Duration(math.MaxInt64),
+	}
+	for i, d := range durations {
+		k := strconv.Itoa(i)
+		cache.Set(k, i, d)
+		if _, ok := cache.Get(k); !ok {
+			t.Fatalf("expected an item set for %v to survive", d)
+		}
+		if ttl := cache.TTL(k); ttl <= 0 || ttl == NoExpiration {
+			t.Fatalf("expected a huge TTL for %v, got %v", d, ttl)
+		}
+	}
+
+	cache.SetWithDeadline("far", 1, time.Unix(1<<40, 0))
+	cache.DeleteExpired()
+	if _, ok := cache.Get("far"); !ok {
+		t.Fatal("expected an item with a far deadline to survive")
+	}
+	if n := cache.Len(); n != 4 {
+		t.Fatalf("expected 4 items, got %d", n)
+	}
+	cache.Set("never", 1, NoExpiration)
+	if ttl := cache.TTL("never"); ttl != NoExpiration {
+		t.Fatalf("expected NoExpiration to stay distinct, got %v", ttl)
+	}
+}
+
+func TestItemsInOrder(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{Clock: clock, TouchOnGet: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("a", 1, time.Minute)
+	cache.Set("b", 2, NoExpiration)
+	cache.Set("c", 3, time.Minute)
+	cache.Set("old", 4, time.Second)
+	cache.Get("a")
+	clock.Advance(2 * time.Second)
+
+	entries := cache.ItemsInOrder()
+	want := []Entry{
+		{Key: "b", Value: 2, TTL: NoExpiration},
+		{Key: "c", Value: 3, TTL: 58 * time.Second},
+		{Key: "a", Value: 1, TTL: 58 * time.Second},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Fatalf("expected %v, got %v", want, entries)
+	}
+
+	target, _ := New(Config{Clock: clock})
+	for _, e := range entries {
+		target.Set(e.Key, e.Value, e.TTL)
+	}
+	if keys := target.Keys(); !reflect.DeepEqual(keys, []string{"b", "c", "a"}) {
+		t.Fatalf("expected the LRU order to be kept, got %v", keys)
+	}
+}
+
+func TestSetWithResult(t *testing.T) {
+	clock := newFakeClock()
+	c, err := New(Config{MaxEntryLimit: 3, Expiration: time.Minute, Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for _, k := range []string{"a", "b", "c"} {
+		if r := c.SetWithResult(k, k, 0); !r.Inserted || r.Updated || r.Evicted != 0 || r.Err != nil {
+			t.Fatalf("expected %s to be inserted, got %+v", k, r)
+		}
+	}
+	if r := c.SetWithResult("b", "B", 0); r.Inserted || !r.Updated || r.EvictedKey != "" || r.Evicted != 0 {
+		t.Fatalf("expected b to be updated without eviction, got %+v", r)
+	}
+	if r := c.SetWithResult("d", "d", 0); !r.Inserted || r.EvictedKey != "a" || r.Evicted != 1 {
+		t.Fatalf("expected a to be evicted, got %+v", r)
+	}
+
+	c.Set("e", "e", time.Second)
+	clock.Advance(2 * time.Second)
+	if r := c.SetWithResult("f", "f", 0); !r.Inserted || r.ExpiredSwept != 1 || r.Evicted != 0 {
+		t.Fatalf("expected e to be swept, got %+v", r)
+	}
+
+	c.SetReadOnly(true)
+	if r := c.SetWithResult("g", "g", 0); !errors.Is(r.Err, ErrReadOnly) || r.Inserted || r.Updated {
+		t.Fatalf("expected ErrReadOnly, got %+v", r)
+	}
+}
+
+func TestMinTTL(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{Clock: clock, MinTTL: time.Second, Expiration: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	cache.Set("tiny", 1, time.Microsecond)
+	cache.Set("default", 2, 0)
+	cache.Set("long", 3, time.Minute)
+	cache.Set("forever", 4, NoExpiration)
+
+	clock.Advance(time.Second)
+	for _, k := range []string{"tiny", "default", "long", "forever"} {
+		if _, ok := cache.Get(k); !ok {
+			t.Fatalf("expected %s to survive MinTTL", k)
+		}
+	}
+	clock.Advance(time.Nanosecond)
+	for _, k := range []string{"tiny", "default"} {
+		if _, ok := cache.Get(k); ok {
+			t.Fatalf("expected %s to expire after MinTTL", k)
+		}
+	}
+	if _, ok := cache.Get("long"); !ok {
+		t.Fatal("expected long to keep its own duration")
+	}
+	clock.Advance(time.Hour)
+	if _, ok := cache.Get("forever"); !ok {
+		t.Fatal("expected NoExpiration to bypass MinTTL")
+	}
+}
+
+func TestOldestNewestAge(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{Clock: clock, Expiration: time.Hour, TouchOnGet: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	if _, ok := cache.OldestAge(); ok {
+		t.Fatal("expected no age for an empty cache")
+	}
+	if _, ok := cache.NewestAge(); ok {
+		t.Fatal("expected no age for an empty cache")
+	}
+
+	cache.Set("a", 1, 0)
+	clock.Advance(time.Minute)
+	cache.Set("b", 2, 0)
+	clock.Advance(time.Minute)
+	cache.Set("c", 3, time.Second)
+	clock.Advance(time.Second)
+
+	if age, ok := cache.OldestAge(); !ok || age != 2*time.Minute+time.Second {
+		t.Fatalf("expected the age of a, got %v, %v", age, ok)
+	}
+	if age, ok := cache.NewestAge(); !ok || age != time.Second {
+		t.Fatalf("expected the age of c, got %v, %v", age, ok)
+	}
+
+	// c expires, and a Get makes a the most recently used.
+	clock.Advance(time.Second)
+	cache.Get("a")
+	if age, ok := cache.OldestAge(); !ok || age != time.Minute+2*time.Second {
+		t.Fatalf("expected the age of b, got %v, %v", age, ok)
+	}
+	if age, ok := cache.NewestAge(); !ok || age != 2*time.Minute+2*time.Second {
+		t.Fatalf("expected the age of a, got %v, %v", age, ok)
+	}
+}
+
+func TestCanEvict(t *testing.T) {
+	pinned := func(k string, _ interface{}) bool { return !strings.HasPrefix(k, "pin") }
+	for _, policy := range []Policy{PolicyLRU, PolicyLFU} {
+		cache, err := New(Config{MaxEntryLimit: 4, Policy: policy, CanEvict: pinned})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cache.Set("pin1", 1, 0)
+		cache.Set("pin2", 2, 0)
+		cache.Set("a", 3, 0)
+		cache.Set("b", 4, 0)
+		cache.Set("c", 5, 0)
+		cache.Set("d", 6, 0)
+
+		if cache.Len() != 4 {
+			t.Fatalf("policy %d: expected 4 items, got %d", policy, cache.Len())
+		}
+		for _, k := range []string{"pin1", "pin2", "c", "d"} {
+			if !cache.Has(k) {
+				t.Fatalf("policy %d: expected %s to be kept", policy, k)
+			}
+		}
+
+		// With only pinned items left, the cache goes over its limit.
+		cache.Del("c")
+		cache.Del("d")
+		cache.Set("pin3", 7, 0)
+		cache.Set("pin4", 8, 0)
+		cache.Set("pin5", 9, 0)
+		if cache.Len() != 5 {
+			t.Fatalf("policy %d: expected all pinned items to stay, got %d", policy, cache.Len())
+		}
+		cache.Close()
+	}
+}
+
+func TestCanEvictScanLimit(t *testing.T) {
+	cache, err := New(Config{
+		MaxEntryLimit: MaxEvictScan + 1,
+		CanEvict:      func(k string, _ interface{}) bool { return k == "free" },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	for i := 0; i < MaxEvictScan; i = i + 1 {
+		cache.Set(strconv.Itoa(i), i, 0)
+	}
+	cache.Set("free", 0, 0)
+	cache.Set("new", 0, 0)
+	if !cache.Has("free") || cache.Len() != MaxEvictScan+2 {
+		t.Fatalf("expected the scan to stop before free, got %d items", cache.Len())
+	}
+}
+
+func TestClosedCache(t *testing.T) {
+	cache, err := New(Config{JanitorInterval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("a", 1, 0)
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.Set("b", 2, 0); !errors.Is(err, ErrCacheClosed) {
+		t.Fatalf("expected ErrCacheClosed from Set, got %v", err)
+	}
+	if err := cache.Add("b", 2, 0); !errors.Is(err, ErrCacheClosed) {
+		t.Fatalf("expected ErrCacheClosed from Add, got %v", err)
+	}
+	if err := cache.Replace("a", 2, 0); !errors.Is(err, ErrCacheClosed) {
+		t.Fatalf("expected ErrCacheClosed from Replace, got %v", err)
+	}
+	if err := cache.Add("a", 2, 0); !errors.Is(err, ErrCacheClosed) {
+		t.Fatalf("expected ErrCacheClosed from Add of an existing key, got %v", err)
+	}
+	if err := cache.Replace("b", 2, 0); !errors.Is(err, ErrCacheClosed) {
+		t.Fatalf("expected ErrCacheClosed from Replace of a missing key, got %v", err)
+	}
+	if _, err := cache.Increment("a", 1); !errors.Is(err, ErrCacheClosed) {
+		t.Fatalf("expected ErrCacheClosed from Increment, got %v", err)
+	}
+	if _, ok := cache.Get("a"); ok {
+		t.Fatal("expected Get to miss")
+	}
+	if cache.Has("a") {
+		t.Fatal("expected Has to miss")
+	}
+	if _, ok := cache.Peek("a"); ok {
+		t.Fatal("expected Peek to miss")
+	}
+	if found, missing := cache.GetMulti([]string{"a"}); len(found) != 0 || len(missing) != 1 {
+		t.Fatalf("expected GetMulti to miss, got %v, %v", found, missing)
+	}
+	if cache.Del("a") {
+		t.Fatal("expected Del to return false")
+	}
+	if cache.Touch("a", time.Hour) || cache.Rename("a", "c") || cache.Pin("a") {
+		t.Fatal("expected Touch, Rename and Pin to return false")
+	}
+	if n := cache.ExtendAll(time.Hour); n != 0 {
+		t.Fatalf("expected ExtendAll to extend nothing, got %d", n)
+	}
+	if _, _, err := cache.GetOrSet("a", 0, func() (interface{}, error) { return 2, nil }); !errors.Is(err, ErrCacheClosed) {
+		t.Fatalf("expected ErrCacheClosed from GetOrSet, got %v", err)
+	}
+	calls := 0
+	if _, err := cache.GetWithLoader("b", 0, func() (interface{}, error) {
+		calls = calls + 1
+		return 2, nil
+	}); !errors.Is(err, ErrCacheClosed) {
+		t.Fatalf("expected ErrCacheClosed from GetWithLoader, got %v", err)
+	}
+	if _, err := cache.GetWithContext(context.Background(), "b", 0, func(context.Context) (interface{}, error) {
+		calls = calls + 1
+		return 2, nil
+	}); !errors.Is(err, ErrCacheClosed) {
+		t.Fatalf("expected ErrCacheClosed from GetWithContext, got %v", err)
+	}
+	if _, err := cache.GetOrLoadBatch([]string{"b"}, 0, func([]string) (map[string]interface{}, error) {
+		calls = calls + 1
+		return map[string]interface{}{"b": 2}, nil
+	}); !errors.Is(err, ErrCacheClosed) {
+		t.Fatalf("expected ErrCacheClosed from GetOrLoadBatch, got %v", err)
+	}
+	if calls != 0 {
+		t.Fatalf("expected no loader to run, got %d calls", calls)
+	}
+	if err := cache.ImportJSON([]byte(`{"b":{"value":2}}`)); !errors.Is(err, ErrCacheClosed) {
+		t.Fatalf("expected ErrCacheClosed from ImportJSON, got %v", err)
+	}
+	if _, ok := cache.AccessCount("a"); ok {
+		t.Fatal("expected AccessCount to miss")
+	}
+	if _, ok := cache.LastAccess("a"); ok {
+		t.Fatal("expected LastAccess to miss")
+	}
+	if items := cache.Drain(); items != nil {
+		t.Fatalf("expected Drain to return nil, got %v", items)
+	}
+	cache.Flush()
+	cache.ReplaceAll(map[string]Entry{"b": {Value: 2}})
+	cache.Warm([]WarmEntry{{Key: "b", Value: 2}})
+	if n := cache.DeletePrefix(""); n != 0 {
+		t.Fatalf("expected DeletePrefix to delete nothing, got %d", n)
+	}
+	cache.mu.RLock()
+	_, ok := cache.items["a"]
+	n := cache.itemCount
+	cache.mu.RUnlock()
+	if !ok || n != 1 {
+		t.Fatalf("expected the closed cache to keep only a, got %d items", n)
+	}
+	if err := cache.Close(); err != nil {
+		t.Fatalf("expected a second Close to do nothing, got %v", err)
+	}
+}
+
+func TestClosedCounterCache(t *testing.T) {
+	cache, err := NewCounterCache(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Increment("a", 1)
+	cache.ObjCache().Close()
+	if _, err := cache.Increment("a", 1); !errors.Is(err, ErrCacheClosed) {
+		t.Fatalf("expected ErrCacheClosed for an existing counter, got %v", err)
+	}
+	if _, err := cache.Increment("b", 1); !errors.Is(err, ErrCacheClosed) {
+		t.Fatalf("expected ErrCacheClosed for a new counter, got %v", err)
+	}
+	if _, ok := cache.Get("a"); ok {
+		t.Fatal("expected Get to miss")
+	}
+}
+
+func TestClosedTieredCache(t *testing.T) {
+	cache, err := NewTiered(Config{MaxEntryLimit: 1}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("a", 1, 0)
+	cache.Set("b", 2, 0)
+	cache.Close()
+	if _, ok := cache.Get("a"); ok {
+		t.Fatal("expected Get of the cold tier to miss")
+	}
+	if cache.cold.Len() != 1 {
+		t.Fatal("expected a to stay in the cold tier")
+	}
+}
+
+func TestExtendAll(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	cache.Set("a", 1, time.Second)
+	cache.Set("b", 2, 2*time.Second)
+	cache.Set("c", 3, NoExpiration)
+	cache.Set("old", 4, time.Millisecond)
+	clock.Advance(10 * time.Millisecond)
+
+	if n := cache.ExtendAll(time.Hour); n != 3 {
+		t.Fatalf("expected 3 live items extended, got %d", n)
+	}
+	clock.Advance(time.Minute)
+	for _, k := range []string{"a", "b", "c"} {
+		if ttl := cache.TTL(k); ttl != time.Hour-time.Minute {
+			t.Fatalf("expected %s to survive with the new TTL, got %v", k, ttl)
+		}
+	}
+	if cache.Has("old") {
+		t.Fatal("expected the expired item not to be extended")
+	}
+
+	// The LRU order is kept.
+	keys := make([]string, 0, 3)
+	for _, e := range cache.ItemsInOrder() {
+		keys = append(keys, e.Key)
+	}
+	if !reflect.DeepEqual(keys, []string{"a", "b", "c"}) {
+		t.Fatalf("expected the LRU order to be kept, got %v", keys)
+	}
+}
+
+func TestLastAccess(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{Clock: clock, Expiration: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	set := clock.Now()
+	cache.Set("read", 1, 0)
+	cache.Set("untouched", 2, 0)
+	clock.Advance(time.Minute)
+	cache.Get("read")
+	cache.Peek("untouched")
+
+	read, ok := cache.LastAccess("read")
+	if !ok || !read.Equal(set.Add(time.Minute)) {
+		t.Fatalf("expected the time of the Get, got %v, %v", read, ok)
+	}
+	untouched, ok := cache.LastAccess("untouched")
+	if !ok || !untouched.Equal(set) {
+		t.Fatalf("expected the time of the set, got %v, %v", untouched, ok)
+	}
+	if _, ok := cache.LastAccess("missing"); ok {
+		t.Fatal("expected no access time for a missing key")
+	}
+}
+
+func TestSetWithSoftHard(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	if err := cache.SetWithSoftHard("k", 1, time.Second, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if x, stale, ok := cache.GetAllowStale("k"); !ok || stale || x != 1 {
+		t.Fatalf("expected a fresh item, got %v, %v, %v", x, stale, ok)
+	}
+
+	clock.Advance(2 * time.Second)
+	if x, ok := cache.Get("k"); !ok || x != 1 {
+		t.Fatalf("expected Get to serve the soft-stale item, got %v, %v", x, ok)
+	}
+	if _, stale, ok := cache.GetAllowStale("k"); !ok || !stale {
+		t.Fatalf("expected a stale item, got %v, %v", stale, ok)
+	}
+	if cache.Len() != 1 {
+		t.Fatal("expected the soft-stale item to stay")
+	}
+
+	clock.Advance(time.Minute)
+	if _, ok := cache.Get("k"); ok || cache.Len() != 0 {
+		t.Fatal("expected the item to be gone after the hard TTL")
+	}
+
+	// A plain Set clears the soft deadline.
+	cache.SetWithSoftHard("k", 1, time.Second, time.Minute)
+	cache.Set("k", 2, time.Minute)
+	clock.Advance(2 * time.Second)
+	if _, stale, _ := cache.GetAllowStale("k"); stale {
+		t.Fatal("expected Set to clear the soft deadline")
+	}
+}
+
+func TestSetWithSoftHardReload(t *testing.T) {
+	clock := newFakeClock()
+	var reloads int32
+	cache, err := New(Config{
+		Clock: clock,
+		Reload: func(k string) (interface{}, error) {
+			return int(atomic.AddInt32(&reloads, 1)) + 1, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	cache.SetWithSoftHard("k", 1, time.Second, time.Minute)
+	cache.Get("k")
+	if atomic.LoadInt32(&reloads) != 0 {
+		t.Fatal("expected no reload of a fresh item")
+	}
+
+	clock.Advance(2 * time.Second)
+	if x, ok := cache.Get("k"); !ok || x != 1 {
+		t.Fatalf("expected the stale object while reloading, got %v, %v", x, ok)
+	}
+	waitFor(t, func() bool {
+		x, stale, _ := cache.GetAllowStale("k")
+		return x == 2 && !stale
+	})
+	// The reloaded item keeps the soft and hard TTLs.
+	clock.Advance(2 * time.Second)
+	if _, stale, ok := cache.GetAllowStale("k"); !ok || !stale {
+		t.Fatalf("expected the reloaded item to go stale again, got %v, %v", stale, ok)
+	}
+}
+
+func TestEvictionTieBreak(t *testing.T) {
+	clock := newFakeClock()
+	var evicted []string
+	cache, err := New(Config{
+		MaxEntryLimit: 3,
+		SampleSize:    10,
+		Clock:         clock,
+		OnEvicted: func(k string, _ interface{}, _ EvictReason) {
+			evicted = append(evicted, k)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	// The clock does not move, so all items tie on their access time and
+	// the sample holds all of them.
+	for _, k := range []string{"c", "a", "b", "d", "e"} {
+		cache.Set(k, k, time.Minute)
+	}
+	if !reflect.DeepEqual(evicted, []string{"c", "a"}) {
+		t.Fatalf("expected eviction in insertion order, got %v", evicted)
+	}
+
+	// Items expiring at the same time expire in insertion order.
+	evicted = nil
+	cache.ExtendAll(time.Second)
+	clock.Advance(2 * time.Second)
+	cache.DeleteExpired()
+	if !reflect.DeepEqual(evicted, []string{"b", "d", "e"}) {
+		t.Fatalf("expected expiration in insertion order, got %v", evicted)
+	}
+}
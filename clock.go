@@ -0,0 +1,57 @@
+package objcache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Clock tells the current time. It can be set in Config to control
+// expiration in tests. A Clock that also has a method
+// After(time.Duration) <-chan time.Time drives the timer of
+// Config.SourceRefresh as well.
+type Clock interface {
+	Now() time.Time
+}
+
+// afterClock is a Clock with its own timers.
+type afterClock interface {
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// after returns a channel that receives the time once d has passed on
+// the cache clock.
+func (c *ObjCache) after(d time.Duration) <-chan time.Time {
+	if ac, ok := c.clock.(afterClock); ok {
+		return ac.After(d)
+	}
+	return time.After(d)
+}
+
+// now returns the current time of the cache clock in nanoseconds. With
+// Config.ClockResolution it is the time of the last tick.
+func (c *ObjCache) now() int64 {
+	if c.config.ClockResolution > 0 {
+		return atomic.LoadInt64(&c.coarseNow)
+	}
+	return c.clock.Now().UnixNano()
+}
+
+// tick reads the clock into coarseNow every resolution until Close is
+// called. The time then stands still, which does not matter as reads
+// miss and writes fail after Close.
+func (c *ObjCache) tick(resolution time.Duration) {
+	ticker := time.NewTicker(resolution)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			atomic.StoreInt64(&c.coarseNow, c.clock.Now().UnixNano())
+		case <-c.done:
+			return
+		}
+	}
+}
@@ -1,10 +1,465 @@
 package objcache
 
-import "time"
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// DefaultExpiration is used when Config.Expiration is 0.
+const DefaultExpiration = 5 * time.Minute
+
+// EvictReason tells why an item left the cache.
+type EvictReason int
+
+const (
+	// ReasonCapacity is for an item evicted to make room.
+	ReasonCapacity EvictReason = iota
+	// ReasonExpired is for an expired item.
+	ReasonExpired
+	// ReasonDeleted is for an item deleted by Del and the like.
+	ReasonDeleted
+	// ReasonReplaced is for an object overwritten by a new one.
+	ReasonReplaced
+	// ReasonDrained is for an item returned by Drain.
+	ReasonDrained
+)
+
+// Policy decides which item is evicted when the cache is full.
+type Policy int
+
+const (
+	// PolicyLRU evicts the least recently set item.
+	PolicyLRU Policy = iota
+	// PolicyLFU evicts the item with the fewest Get hits.
+	PolicyLFU
+)
+
+// TypeMismatch decides what methods that want an object of some type,
+// like GetBytes and Increment, do when the object of the key has another
+// type.
+type TypeMismatch int
+
+const (
+	// TypeMismatchError returns ErrNotInt64. Methods without an error
+	// result, like GetBytes, return false.
+	TypeMismatchError TypeMismatch = iota
+	// TypeMismatchMiss treats the key as missing, so Increment returns
+	// ErrKeyNotFound and SetIfGreater sets its value.
+	TypeMismatchMiss
+	// TypeMismatchPanic panics, after releasing the lock.
+	TypeMismatchPanic
+)
 
 // Config for cache
-// TODO: memory limit or entry limit
 type Config struct {
+	// MaxEntryLimit is the maximum number of items. If it is 0 or less,
+	// the number of items is not limited.
 	MaxEntryLimit int
-	Expiration    time.Duration
+
+	// InitialCapacity is the number of items the cache is sized for when
+	// it is made or flushed, so filling it does not grow the map again
+	// and again. ShardedCache divides it between the shards.
+	InitialCapacity int
+
+	// RejectOnFull makes Set and the like return ErrCacheFull for a new
+	// key when the cache has MaxEntryLimit live items, instead of evicting
+	// one. Existing keys can still be set. It requires MaxEntryLimit.
+	RejectOnFull bool
+
+	// Admission makes a full cache reject a new key with ErrNotAdmitted
+	// if the key has been read less often lately than the item it would
+	// evict, like TinyLFU. Gets, hits and misses, are counted in a small
+	// frequency sketch. This keeps a scan of keys read only once from
+	// flushing the hot items. It requires MaxEntryLimit.
+	Admission bool
+
+	// HighWaterMark is a fraction of MaxEntryLimit, like 0.9. When a write
+	// brings the item count to the mark or above, OnHighWater is called,
+	// and when the count drops below it again, OnLowWater is called. Each
+	// is called once per crossing. If it is 0, neither is called.
+	HighWaterMark float64
+
+	// OnHighWater is called with the item count and MaxEntryLimit when the
+	// count reaches HighWaterMark. It is called after the lock is
+	// released, so it may use the cache. It requires HighWaterMark, and
+	// HighWaterMark requires MaxEntryLimit.
+	OnHighWater func(itemCount, limit int)
+
+	// OnLowWater is called like OnHighWater when the count drops below
+	// HighWaterMark again.
+	OnLowWater func(itemCount, limit int)
+
+	// LowWaterMark is a fraction of MaxEntryLimit, like 0.8. When a Set
+	// finds the cache full, or MSet or SetMany adds more items than fit,
+	// items are evicted in one pass until the count is LowWaterMark of
+	// MaxEntryLimit with the new items added, so the next Sets do not
+	// each evict. If it is 0, 1 is used and one item is evicted per Set.
+	// It requires MaxEntryLimit.
+	LowWaterMark float64
+
+	// Expiration is the duration of items set with a duration of 0. If it
+	// is 0, DefaultExpiration is used. If it is negative, those items
+	// never expire.
+	Expiration time.Duration
+
+	// MaxKeyLen is the maximum length of a key in bytes. Setting a longer
+	// key returns ErrKeyTooLong. If it is 0, keys are not limited.
+	MaxKeyLen int
+
+	// Warm are the entries set by New with Warm, before the cache is
+	// used.
+	Warm []WarmEntry
+
+	// JanitorInterval is the interval of removing expired items in the
+	// background. If it is 0, expired items are only removed lazily.
+	JanitorInterval time.Duration
+
+	// OnEvicted is called with the key and the object of an item that
+	// left the cache, and the reason why. It is called after the lock is
+	// released, so it may use the cache.
+	OnEvicted func(key string, value interface{}, reason EvictReason)
+
+	// OnExpired is called with the key and the object of an expired item
+	// when it is removed, by the janitor or lazily, after OnEvicted.
+	OnExpired func(key string, value interface{})
+
+	// ExpiredDebounce suppresses OnExpired for a key that already expired
+	// within the last ExpiredDebounce. It requires OnExpired.
+	ExpiredDebounce time.Duration
+
+	// Shards is the number of shards of a ShardedCache. It is not used
+	// by ObjCache.
+	Shards int
+
+	// Hasher hashes keys to pick their shard in a ShardedCache, as
+	// Hasher(key) % Shards. If it is nil, FNV-1a is used.
+	Hasher func(key string) uint64
+
+	// MaxBytes limits the total size of all items. The oldest items are
+	// evicted until the total fits. It applies together with
+	// MaxEntryLimit, so whichever is reached first evicts. If it is 0,
+	// there is no limit.
+	MaxBytes int64
+
+	// Sizer returns the size of an object set by Set. Without Sizer the
+	// size is 0 unless given by SetWithSize.
+	Sizer func(value interface{}) int64
+
+	// MaxCost limits the total cost of items set by SetWithCost. Items set
+	// otherwise cost 0. If it is 0, there is no limit.
+	MaxCost int64
+
+	// Clock is used for expiration. If it is nil, the system clock is used.
+	Clock Clock
+
+	// ClockResolution makes the cache read Clock every ClockResolution in
+	// a background goroutine instead of on every operation, which saves
+	// the cost of time.Now under high load. Items then expire up to
+	// ClockResolution early or late. If it is 0, Clock is read every time.
+	ClockResolution time.Duration
+
+	// Policy is the eviction policy. The default is PolicyLRU.
+	Policy Policy
+
+	// OnTypeMismatch is what GetBytes, Increment, SetIfGreater,
+	// CounterCache and Cache do with an object of the wrong type. The
+	// default is TypeMismatchError.
+	OnTypeMismatch TypeMismatch
+
+	// SampleSize makes PolicyLRU evict the least recently used of
+	// SampleSize random items instead of the front of the LRU list. Get
+	// then only updates an access time, but eviction is approximate. Of
+	// sampled items accessed at the same time, the one inserted first is
+	// evicted. It cannot be used with PolicyLFU.
+	SampleSize int
+
+	// CanEvict is asked before an item is evicted for capacity. If it
+	// returns false, the item is kept and the next candidate is tried.
+	// Without SampleSize, PolicyLRU tries at most MaxEvictScan candidates.
+	// If no item can be evicted, the cache goes over its limit until items
+	// are deleted or expire. It is called with the write lock held, so it
+	// must not use the cache.
+	CanEvict func(key string, value interface{}) bool
+
+	// Observer is called synchronously by Get, Set, Del and the like. If
+	// it is nil, nothing is called.
+	Observer Observer
+
+	// StatsBucket is the period of each bucket counting hits and misses
+	// for RecentHitRatio. If it is 0, RecentHitRatio is not available.
+	StatsBucket time.Duration
+
+	// StatsBuckets is the number of buckets kept, which limits the window
+	// of RecentHitRatio to StatsBuckets * StatsBucket. If it is 0,
+	// DefaultStatsBuckets is used.
+	StatsBuckets int
+
+	// EventBuffer is the buffer size of the Events channel. If it is 0,
+	// no events are sent.
+	EventBuffer int
+
+	// KeepExpiredOnGet makes Get treat an expired item as a miss without
+	// removing it, so Get never needs the write lock for it. Expired
+	// items then hold memory until the janitor, DeleteExpired or a Set
+	// removes them.
+	KeepExpiredOnGet bool
+
+	// StaleGrace keeps expired items in the cache for that long after
+	// they expire, for GetAllowStale. Get still misses them, but they are
+	// counted by Len and only removed, and OnEvicted called, once the
+	// grace has passed.
+	StaleGrace time.Duration
+
+	// SlidingExpiration makes each Get hit extend the expiration of the
+	// item by the duration it was set for. Get then takes the write lock.
+	SlidingExpiration bool
+
+	// TouchOnGet makes each Get hit move the item to the back of the LRU
+	// list, so reads count as use for eviction. Get then takes the write
+	// lock, which makes concurrent reads slower.
+	TouchOnGet bool
+
+	// RefreshAhead makes a Get hit on an item expiring within RefreshAhead
+	// reload it with Reload in the background. Get returns the current
+	// object without waiting. It requires Reload.
+	RefreshAhead time.Duration
+
+	// Reload loads the object of a key for RefreshAhead and for stale
+	// items of SetWithSoftHard. The result is set for the durations the
+	// item was set for. On error the item is kept.
+	Reload func(key string) (interface{}, error)
+
+	// NegativeTTL is how long GetOrCompute and GetReadThrough cache a
+	// loader error, so the loader of a failing key is not called again
+	// until then. If it is 0, errors are not cached.
+	NegativeTTL time.Duration
+
+	// Loader loads the objects of keys missing from the cache for
+	// GetReadThrough.
+	Loader Loader
+
+	// OnLoad is called with the key, the duration on Clock and the error
+	// of each loader call of GetWithLoader, GetOrCompute, GetReadThrough,
+	// GetWithContext and GetOrLoadBatch, whose key is the missing keys
+	// joined with commas. Only the caller running a load reports it: the
+	// time other callers wait for it and for a slot of
+	// MaxConcurrentLoads is not included. The loads are counted in Stats
+	// too.
+	OnLoad func(key string, d time.Duration, err error)
+
+	// MaxConcurrentLoads limits the number of loaders and Reloads running
+	// at once. Loads of other keys wait for a free slot, those of
+	// GetWithContext until their context is done. Loads of the same key
+	// are still shared. If it is 0, loads are not limited.
+	MaxConcurrentLoads int
+
+	// Store is written through by Set, Del and the like, including
+	// DeletePrefix, DeleteFunc, InvalidateTag and Rename. Flush, Drain,
+	// ReplaceAll, Warm and loading a saved cache only change the memory.
+	// If it is nil, the cache is only in memory.
+	Store Store
+
+	// WriteBehind makes writes to Store asynchronous. They are queued,
+	// with writes to the same key coalesced, and flushed in the
+	// background. Close flushes what is left.
+	WriteBehind bool
+
+	// FlushInterval is the interval of flushing writes with WriteBehind.
+	// If it is 0, DefaultFlushInterval is used.
+	FlushInterval time.Duration
+
+	// MaxBatch is the maximum number of writes flushed in one batch. A
+	// full batch is flushed without waiting for FlushInterval. If it is 0,
+	// all queued writes are flushed at once.
+	MaxBatch int
+
+	// OnStoreError is called with the key and the error of a write that
+	// WriteBehind failed to flush. The write is retried with a growing
+	// interval unless the key is written again. It requires WriteBehind.
+	OnStoreError func(key string, err error)
+
+	// DedupValues makes keys set to equal objects share one of them, to
+	// save the memory of the copies. Each set hashes the object, with
+	// ValueHasher or its gob encoding, and compares it deeply with the
+	// object of the same hash, which costs CPU time proportional to its
+	// size. Shared objects must not be modified. Sizes and MaxBytes still
+	// count each key. The *int64 counters of CounterCache are not shared.
+	DedupValues bool
+
+	// ValueHasher hashes objects for DedupValues instead of gob. Equal
+	// objects must have the same hash.
+	ValueHasher func(value interface{}) uint64
+
+	// IndexFunc returns the secondary key of an object, and false if it
+	// has none, for GetBySecondary. It is called with the write lock
+	// held whenever an object is set, so it must be fast and must not use
+	// the cache.
+	IndexFunc func(value interface{}) (secondaryKey string, ok bool)
+
+	// OnCallbackPanic is called with the value of a recovered panic of
+	// OnEvicted, Observer, a loader or another callback. The cache stays
+	// usable. A panicking loader or Store returns ErrCallbackPanic. If it
+	// is nil, panics are only recovered.
+	OnCallbackPanic func(recovered interface{})
+
+	// Logger receives log records of janitor sweeps, evictions, loader
+	// errors and Store errors. If it is nil, nothing is logged.
+	Logger Logger
+
+	// Invalidator publishes the keys of Set, Del and the other writes to
+	// other instances and deletes the keys they publish. See Invalidator.
+	Invalidator Invalidator
+
+	// SourceRefresh replaces all items with the entries of its Fetch
+	// every Interval, if Fetch is set. Close should be called to stop it.
+	SourceRefresh SourceRefresh
+
+	// ExpirationJitter adds a random duration in [0, ExpirationJitter) to
+	// each expiration, so items set together do not expire together.
+	ExpirationJitter time.Duration
+
+	// MinTTL raises shorter durations of Set and the like to MinTTL, after
+	// 0 is replaced by Expiration, so items do not expire before they can
+	// be read. NoExpiration is kept. If it is 0, there is no floor.
+	MinTTL time.Duration
+
+	// Rand is the random source of ExpirationJitter. It is only used with
+	// the write lock held. If it is nil, a randomly seeded source is used.
+	// NewSharded seeds a source for each shard from it.
+	Rand *rand.Rand
+
+	// CopyOnGet makes the cache store and return deep copies of objects,
+	// so callers cannot change a cached object through a slice, map or
+	// pointer. Unexported struct fields, channels and functions are not
+	// copied deeply.
+	CopyOnGet bool
+}
+
+// validate fills in the defaults of config and checks its fields.
+func (config *Config) validate() error {
+	if config.Expiration == 0 {
+		config.Expiration = DefaultExpiration
+	}
+	if config.HighWaterMark < 0 || config.HighWaterMark > 1 {
+		return fmt.Errorf("%w: HighWaterMark out of [0, 1]", ErrInvalidConfig)
+	}
+	if config.LowWaterMark < 0 || config.LowWaterMark > 1 {
+		return fmt.Errorf("%w: LowWaterMark out of [0, 1]", ErrInvalidConfig)
+	}
+	if config.MaxKeyLen < 0 {
+		return fmt.Errorf("%w: negative MaxKeyLen", ErrInvalidConfig)
+	}
+	if config.SourceRefresh.Fetch != nil && config.SourceRefresh.Interval <= 0 {
+		return fmt.Errorf("%w: SourceRefresh without a positive Interval", ErrInvalidConfig)
+	}
+	if config.SourceRefresh.Fetch == nil && config.SourceRefresh.Interval != 0 {
+		return fmt.Errorf("%w: SourceRefresh.Interval without Fetch", ErrInvalidConfig)
+	}
+	if config.JanitorInterval < 0 {
+		return fmt.Errorf("%w: negative JanitorInterval", ErrInvalidConfig)
+	}
+	if config.ClockResolution < 0 {
+		return fmt.Errorf("%w: negative ClockResolution", ErrInvalidConfig)
+	}
+	if config.Shards < 0 {
+		return fmt.Errorf("%w: negative Shards", ErrInvalidConfig)
+	}
+	if config.StatsBucket < 0 {
+		return fmt.Errorf("%w: negative StatsBucket", ErrInvalidConfig)
+	}
+	if config.StatsBuckets < 0 {
+		return fmt.Errorf("%w: negative StatsBuckets", ErrInvalidConfig)
+	}
+	if config.StatsBuckets == 0 {
+		config.StatsBuckets = DefaultStatsBuckets
+	}
+	if config.EventBuffer < 0 {
+		return fmt.Errorf("%w: negative EventBuffer", ErrInvalidConfig)
+	}
+	if config.NegativeTTL < 0 {
+		return fmt.Errorf("%w: negative NegativeTTL", ErrInvalidConfig)
+	}
+	if config.InitialCapacity < 0 {
+		return fmt.Errorf("%w: negative InitialCapacity", ErrInvalidConfig)
+	}
+	if config.StaleGrace < 0 {
+		return fmt.Errorf("%w: negative StaleGrace", ErrInvalidConfig)
+	}
+	if config.ExpiredDebounce < 0 {
+		return fmt.Errorf("%w: negative ExpiredDebounce", ErrInvalidConfig)
+	}
+	if config.MaxConcurrentLoads < 0 {
+		return fmt.Errorf("%w: negative MaxConcurrentLoads", ErrInvalidConfig)
+	}
+	if config.FlushInterval == 0 {
+		config.FlushInterval = DefaultFlushInterval
+	}
+	if config.FlushInterval < 0 {
+		return fmt.Errorf("%w: negative FlushInterval", ErrInvalidConfig)
+	}
+	if config.MaxBatch < 0 {
+		return fmt.Errorf("%w: negative MaxBatch", ErrInvalidConfig)
+	}
+	if config.ExpirationJitter < 0 {
+		return fmt.Errorf("%w: negative ExpirationJitter", ErrInvalidConfig)
+	}
+	if config.MinTTL < 0 {
+		return fmt.Errorf("%w: negative MinTTL", ErrInvalidConfig)
+	}
+	if config.MaxBytes < 0 {
+		return fmt.Errorf("%w: negative MaxBytes", ErrInvalidConfig)
+	}
+	if config.SampleSize < 0 {
+		return fmt.Errorf("%w: negative SampleSize", ErrInvalidConfig)
+	}
+	if config.MaxCost < 0 {
+		return fmt.Errorf("%w: negative MaxCost", ErrInvalidConfig)
+	}
+	if config.Policy != PolicyLRU && config.Policy != PolicyLFU {
+		return fmt.Errorf("%w: unknown Policy %d", ErrInvalidConfig, config.Policy)
+	}
+	if config.OnTypeMismatch < TypeMismatchError || config.OnTypeMismatch > TypeMismatchPanic {
+		return fmt.Errorf("%w: unknown OnTypeMismatch %d", ErrInvalidConfig, config.OnTypeMismatch)
+	}
+	return config.validateCombinations()
+}
+
+// validateCombinations checks the fields that only make sense together.
+// MaxBytes without Sizer is allowed, as SetWithSize gives the sizes.
+func (config *Config) validateCombinations() error {
+	if config.RefreshAhead > 0 && config.Reload == nil {
+		return fmt.Errorf("%w: RefreshAhead without Reload", ErrInvalidConfig)
+	}
+	if config.SampleSize > 0 && config.Policy != PolicyLRU {
+		return fmt.Errorf("%w: SampleSize with a Policy other than PolicyLRU", ErrInvalidConfig)
+	}
+	if config.HighWaterMark > 0 && config.MaxEntryLimit <= 0 {
+		return fmt.Errorf("%w: HighWaterMark without MaxEntryLimit", ErrInvalidConfig)
+	}
+	if (config.OnHighWater != nil || config.OnLowWater != nil) && config.HighWaterMark == 0 {
+		return fmt.Errorf("%w: OnHighWater or OnLowWater without HighWaterMark", ErrInvalidConfig)
+	}
+	if config.Admission && config.MaxEntryLimit <= 0 {
+		return fmt.Errorf("%w: Admission without MaxEntryLimit", ErrInvalidConfig)
+	}
+	if config.RejectOnFull && config.MaxEntryLimit <= 0 {
+		return fmt.Errorf("%w: RejectOnFull without MaxEntryLimit", ErrInvalidConfig)
+	}
+	if config.LowWaterMark > 0 && config.MaxEntryLimit <= 0 {
+		return fmt.Errorf("%w: LowWaterMark without MaxEntryLimit", ErrInvalidConfig)
+	}
+	if config.WriteBehind && config.Store == nil {
+		return fmt.Errorf("%w: WriteBehind without Store", ErrInvalidConfig)
+	}
+	if config.OnStoreError != nil && !config.WriteBehind {
+		return fmt.Errorf("%w: OnStoreError without WriteBehind", ErrInvalidConfig)
+	}
+	if config.ValueHasher != nil && !config.DedupValues {
+		return fmt.Errorf("%w: ValueHasher without DedupValues", ErrInvalidConfig)
+	}
+	if config.ExpiredDebounce > 0 && config.OnExpired == nil {
+		return fmt.Errorf("%w: ExpiredDebounce without OnExpired", ErrInvalidConfig)
+	}
+	return nil
 }
@@ -0,0 +1,58 @@
+package objcache
+
+import (
+	"container/list"
+	"fmt"
+)
+
+// CheckConsistency checks the invariants between the items map, the LRU
+// list, the expiration heap and the counts, and returns an error
+// describing the first one broken. It walks all items under the read
+// lock, so it is O(n) and meant for tests and debugging.
+func (c *ObjCache) CheckConsistency() error {
+	c.mu.RLock()
+	err := c.checkConsistency()
+	c.mu.RUnlock()
+	return err
+}
+
+// checkConsistency is CheckConsistency. The caller must hold the lock.
+func (c *ObjCache) checkConsistency() error {
+	if len(c.items) != c.list.Len() || len(c.items) != c.itemCount {
+		return fmt.Errorf("objcache: %d keys in the map, %d in the list and an item count of %d", len(c.items), c.list.Len(), c.itemCount)
+	}
+	if len(c.heap) != c.itemCount {
+		return fmt.Errorf("objcache: %d items in the heap, but an item count of %d", len(c.heap), c.itemCount)
+	}
+
+	inList := make(map[*list.Element]bool, c.list.Len())
+	keys := make(map[string]bool, c.list.Len())
+	pinned := 0
+	for elem := c.list.Front(); elem != nil; elem = elem.Next() {
+		k := elem.Value.(*pair).key
+		if keys[k] {
+			return fmt.Errorf("objcache: key %q is in the list twice", k)
+		}
+		keys[k] = true
+		inList[elem] = true
+		if elem.Value.(*pair).pinned {
+			pinned = pinned + 1
+		}
+	}
+	if pinned != c.pinned {
+		return fmt.Errorf("objcache: %d items are pinned, but a pinned count of %d", pinned, c.pinned)
+	}
+	for k, elem := range c.items {
+		if !inList[elem] {
+			return fmt.Errorf("objcache: the element of key %q is not in the list", k)
+		}
+		p := elem.Value.(*pair)
+		if p.key != k {
+			return fmt.Errorf("objcache: the element of key %q has key %q", k, p.key)
+		}
+		if p.index < 0 || p.index >= len(c.heap) || c.heap[p.index] != p {
+			return fmt.Errorf("objcache: key %q is not at its index %d in the heap", k, p.index)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package objcache
+
+import (
+	"container/list"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckConsistency(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{MaxEntryLimit: 10, Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	for i := 0; i < 20; i = i + 1 {
+		cache.Set(strconv.Itoa(i), i, time.Duration(i)*time.Second)
+	}
+	cache.Del("15")
+	clock.Advance(15 * time.Second)
+	cache.DeleteExpired()
+	cache.Set("new", 0, 0)
+	if err := cache.CheckConsistency(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckConsistencyCorrupt(t *testing.T) {
+	corruptions := []struct {
+		name    string
+		corrupt func(c *ObjCache)
+		want    string
+	}{
+		{"item count", func(c *ObjCache) { c.itemCount = c.itemCount + 1 }, "item count"},
+		{"missing from map", func(c *ObjCache) { delete(c.items, "a") }, "keys in the map"},
+		{"wrong key", func(c *ObjCache) { c.items["a"].Value.(*pair).key = "x" }, `has key "x"`},
+		{"swapped elements", func(c *ObjCache) {
+			c.items["a"], c.items["b"] = c.items["b"], c.items["a"]
+		}, "has key"},
+		{"duplicate key", func(c *ObjCache) { c.items["b"].Value.(*pair).key = "a" }, "twice"},
+		{"not in list", func(c *ObjCache) {
+			c.items["a"] = &list.Element{Value: c.items["a"].Value}
+		}, "not in the list"},
+		{"pinned count", func(c *ObjCache) { c.pinned = 1 }, "pinned"},
+		{"heap index", func(c *ObjCache) { c.items["a"].Value.(*pair).index = 5 }, "heap"},
+	}
+	for _, tc := range corruptions {
+		cache, err := New(Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cache.Set("a", 1, 0)
+		cache.Set("b", 2, 0)
+		cache.Set("c", 3, 0)
+		cache.mu.Lock()
+		tc.corrupt(cache)
+		cache.mu.Unlock()
+		err = cache.CheckConsistency()
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected an error about %q, got %v", tc.name, tc.want, err)
+		}
+	}
+}
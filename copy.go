@@ -0,0 +1,78 @@
+package objcache
+
+import "reflect"
+
+// deepCopy returns a copy of x that shares no slices, maps or pointers
+// with it. Exported struct fields are copied deeply, unexported fields are
+// copied as they are. Channels, functions and unsafe pointers are shared.
+// Cyclic data is not supported.
+func deepCopy(x interface{}) interface{} {
+	if x == nil {
+		return nil
+	}
+	v := reflect.ValueOf(x)
+	return copyValue(v).Interface()
+}
+
+func copyValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i = i + 1 {
+			c.Index(i).Set(copyValue(v.Index(i)))
+		}
+		return c
+	case reflect.Array:
+		c := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i = i + 1 {
+			c.Index(i).Set(copyValue(v.Index(i)))
+		}
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			c.SetMapIndex(copyValue(iter.Key()), copyValue(iter.Value()))
+		}
+		return c
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type().Elem())
+		c.Elem().Set(copyValue(v.Elem()))
+		return c
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(copyValue(v.Elem()))
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < v.NumField(); i = i + 1 {
+			if c.Field(i).CanSet() {
+				c.Field(i).Set(copyValue(v.Field(i)))
+			}
+		}
+		return c
+	default:
+		return v
+	}
+}
+
+// copyOut returns x, or a copy of x with Config.CopyOnGet.
+func (c *ObjCache) copyOut(x interface{}) interface{} {
+	if !c.config.CopyOnGet {
+		return x
+	}
+	return deepCopy(x)
+}
@@ -0,0 +1,48 @@
+package objcache
+
+import (
+	"reflect"
+	"testing"
+)
+
+type copied struct {
+	Names []string
+	Next  *copied
+}
+
+func TestCopyOnGet(t *testing.T) {
+	cache, err := New(Config{CopyOnGet: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := []byte("abc")
+	cache.Set("bytes", b, 0)
+	b[0] = 'x'
+
+	v, _ := cache.Get("bytes")
+	got := v.([]byte)
+	if string(got) != "abc" {
+		t.Fatalf("expected Set to copy the slice, got %q", got)
+	}
+	got[0] = 'y'
+	if v, _ := cache.Get("bytes"); string(v.([]byte)) != "abc" {
+		t.Fatalf("expected Get to copy the slice, got %q", v)
+	}
+
+	cache.Set("map", map[string][]int{"a": {1}}, 0)
+	m, _ := cache.Get("map")
+	m.(map[string][]int)["a"][0] = 2
+	m.(map[string][]int)["b"] = nil
+	if v, _ := cache.Get("map"); !reflect.DeepEqual(v, map[string][]int{"a": {1}}) {
+		t.Fatalf("expected unchanged map, got %v", v)
+	}
+
+	cache.Set("ptr", &copied{Names: []string{"a"}, Next: &copied{}}, 0)
+	p, _ := cache.Get("ptr")
+	p.(*copied).Names[0] = "b"
+	p.(*copied).Next.Names = []string{"c"}
+	if v, _ := cache.Peek("ptr"); !reflect.DeepEqual(v, &copied{Names: []string{"a"}, Next: &copied{}}) {
+		t.Fatalf("expected unchanged struct, got %+v", v)
+	}
+}
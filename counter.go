@@ -0,0 +1,102 @@
+package objcache
+
+import "sync/atomic"
+
+// CounterCache is a cache of int64 counters. The counters are stored as
+// *int64 and changed atomically under the read lock, so increments of
+// different keys do not wait for each other. Only creating a counter takes
+// the write lock. Counters are not written to Config.Store.
+type CounterCache struct {
+	cache *ObjCache
+}
+
+// NewCounterCache makes a counter cache and returns it.
+func NewCounterCache(config Config) (*CounterCache, error) {
+	cache, err := New(config)
+	if err != nil {
+		return nil, err
+	}
+	return &CounterCache{cache: cache}, nil
+}
+
+// Increment adds n to the counter of key and returns the new value. A
+// missing or expired counter is created with the default expiration. It
+// returns ErrNotInt64 if the key holds an object set on the ObjCache that
+// is not a *int64. With TypeMismatchMiss the object is replaced by a new
+// counter.
+func (c *CounterCache) Increment(k string, n int64) (int64, error) {
+	oc := c.cache
+	oc.mu.RLock()
+	if elem, ok := oc.items[k]; ok && oc.writable() == nil && !oc.expired(elem.Value.(*pair)) {
+		if i, ok := elem.Value.(*pair).Object.(*int64); ok {
+			oc.mu.RUnlock()
+			return atomic.AddInt64(i, n), nil
+		}
+	}
+	oc.mu.RUnlock()
+
+	if err := oc.checkKey(k); err != nil {
+		return 0, err
+	}
+	oc.mu.Lock()
+	if err := oc.writable(); err != nil {
+		oc.unlock()
+		return 0, err
+	}
+	if elem, ok := oc.items[k]; ok && !oc.expired(elem.Value.(*pair)) {
+		x := elem.Value.(*pair).Object
+		if i, ok := x.(*int64); ok {
+			oc.unlock()
+			return atomic.AddInt64(i, n), nil
+		}
+		if oc.config.OnTypeMismatch != TypeMismatchMiss {
+			oc.unlock()
+			oc.mismatch(k, x, "*int64")
+			return 0, ErrNotInt64
+		}
+	}
+	if oc.full(k) {
+		oc.unlock()
+		return 0, ErrCacheFull
+	}
+	i := new(int64)
+	*i = n
+	oc.set(k, i, oc.sizeOf(i), 0)
+	oc.unlock()
+	return n, nil
+}
+
+// Decrement subtracts n from the counter of key. See Increment.
+func (c *CounterCache) Decrement(k string, n int64) (int64, error) {
+	return c.Increment(k, -n)
+}
+
+// Get the value of the counter of key. It returns false if the counter is
+// missing or has expired.
+func (c *CounterCache) Get(k string) (int64, bool) {
+	oc := c.cache
+	oc.mu.RLock()
+	elem, ok := oc.items[k]
+	if !ok || oc.closed() || oc.expired(elem.Value.(*pair)) {
+		oc.mu.RUnlock()
+		return 0, false
+	}
+	x := elem.Value.(*pair).Object
+	i, ok := x.(*int64)
+	oc.mu.RUnlock()
+	if !ok {
+		oc.mismatch(k, x, "*int64")
+		return 0, false
+	}
+	return atomic.LoadInt64(i), true
+}
+
+// Del delete the counter of key.
+func (c *CounterCache) Del(k string) bool {
+	return c.cache.Del(k)
+}
+
+// ObjCache returns the underlying cache.
+func (c *CounterCache) ObjCache() *ObjCache {
+	return c.cache
+}
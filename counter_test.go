@@ -0,0 +1,123 @@
+package objcache
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCounterCache(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := NewCounterCache(Config{Clock: clock, Expiration: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Get("hits"); ok {
+		t.Fatal("expected no counter")
+	}
+	if n, err := cache.Increment("hits", 2); err != nil || n != 2 {
+		t.Fatalf("expected 2, got %d, %v", n, err)
+	}
+	if n, err := cache.Decrement("hits", 3); err != nil || n != -1 {
+		t.Fatalf("expected -1, got %d, %v", n, err)
+	}
+	if n, ok := cache.Get("hits"); !ok || n != -1 {
+		t.Fatalf("expected -1, got %d, %v", n, ok)
+	}
+
+	clock.Advance(2 * time.Minute)
+	if _, ok := cache.Get("hits"); ok {
+		t.Fatal("expected the counter to expire")
+	}
+	if n, err := cache.Increment("hits", 1); err != nil || n != 1 {
+		t.Fatalf("expected an expired counter to restart at 1, got %d, %v", n, err)
+	}
+
+	cache.ObjCache().Set("name", "x", 0)
+	if _, err := cache.Increment("name", 1); err != ErrNotInt64 {
+		t.Fatalf("expected ErrNotInt64, got %v", err)
+	}
+}
+
+func TestCounterCacheDedupValues(t *testing.T) {
+	cache, err := NewCounterCache(Config{DedupValues: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Increment("a", 1)
+	cache.Increment("b", 1)
+	cache.Increment("a", 5)
+	if n, _ := cache.Get("a"); n != 6 {
+		t.Fatalf("expected a to be 6, got %d", n)
+	}
+	if n, _ := cache.Get("b"); n != 1 {
+		t.Fatalf("expected b to be 1, got %d", n)
+	}
+}
+
+func TestCounterCacheConcurrent(t *testing.T) {
+	cache, err := NewCounterCache(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const workers, incs, keys = 16, 1000, 8
+	var wg sync.WaitGroup
+	i := 0
+	for i < workers {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			j := 0
+			for j < incs {
+				cache.Increment("k"+strconv.Itoa((w+j)%keys), 1)
+				j = j + 1
+			}
+		}(i)
+		i = i + 1
+	}
+	wg.Wait()
+
+	var total int64
+	i = 0
+	for i < keys {
+		n, _ := cache.Get("k" + strconv.Itoa(i))
+		total = total + n
+		i = i + 1
+	}
+	if total != workers*incs {
+		t.Fatalf("expected a total of %d, got %d", workers*incs, total)
+	}
+}
+
+func BenchmarkIncrementObjCache(b *testing.B) {
+	cache, _ := New(Config{})
+	i := 0
+	for i < 1000 {
+		cache.Set("k"+strconv.Itoa(i), int64(0), 0)
+		i = i + 1
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			cache.Increment("k"+strconv.Itoa(i%1000), 1)
+			i = i + 1
+		}
+	})
+}
+
+func BenchmarkIncrementCounterCache(b *testing.B) {
+	cache, _ := NewCounterCache(Config{})
+	i := 0
+	for i < 1000 {
+		cache.Increment("k"+strconv.Itoa(i), 0)
+		i = i + 1
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			cache.Increment("k"+strconv.Itoa(i%1000), 1)
+			i = i + 1
+		}
+	})
+}
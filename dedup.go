@@ -0,0 +1,90 @@
+package objcache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"hash/fnv"
+	"reflect"
+)
+
+// shared is an object stored once for all keys with an equal object, with
+// Config.DedupValues.
+type shared struct {
+	value interface{}
+	refs  int
+}
+
+// gobHash is the FNV-1a hash of x, of its gob encoding for other types
+// than []byte and string. It returns false if x cannot be encoded.
+func gobHash(x interface{}) (uint64, bool) {
+	h := fnv.New64a()
+	switch v := x.(type) {
+	case []byte:
+		h.Write(v)
+	case string:
+		h.Write([]byte(v))
+	default:
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(x); err != nil {
+			return 0, false
+		}
+		h.Write(buf.Bytes())
+	}
+	return h.Sum64(), true
+}
+
+// intern replaces the object of p with an equal object already stored for
+// another key, or records it for the next keys, with Config.DedupValues.
+// A *int64 is never shared, as CounterCache changes its counters in
+// place. The caller must hold the write lock.
+func (c *ObjCache) intern(p *pair) {
+	if !c.config.DedupValues || p.Object == nil {
+		return
+	}
+	if _, ok := p.Object.(*int64); ok {
+		return
+	}
+	var h uint64
+	ok := false
+	c.guard(func() { h, ok = c.hashValue(p.Object) })
+	if !ok {
+		return
+	}
+	s, found := c.shared[h]
+	if !found {
+		if c.shared == nil {
+			c.shared = make(map[uint64]*shared)
+		}
+		s = &shared{value: p.Object}
+		c.shared[h] = s
+	} else if !reflect.DeepEqual(s.value, p.Object) {
+		// A hash collision, the object is kept for this key alone.
+		return
+	}
+	s.refs = s.refs + 1
+	p.Object = s.value
+	p.vhash, p.interned = h, true
+}
+
+// unintern drops the reference of p to its shared object. The object is
+// forgotten when no key refers to it any more. The caller must hold the
+// write lock.
+func (c *ObjCache) unintern(p *pair) {
+	if !p.interned {
+		return
+	}
+	s := c.shared[p.vhash]
+	s.refs = s.refs - 1
+	if s.refs == 0 {
+		delete(c.shared, p.vhash)
+	}
+	p.vhash, p.interned = 0, false
+}
+
+// hashValue hashes x with Config.ValueHasher, or gobHash without one.
+func (c *ObjCache) hashValue(x interface{}) (uint64, bool) {
+	if c.config.ValueHasher != nil {
+		return c.config.ValueHasher(x), true
+	}
+	return gobHash(x)
+}
@@ -0,0 +1,62 @@
+package objcache
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestDedupValues(t *testing.T) {
+	cache, err := New(Config{DedupValues: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := bytes.Repeat([]byte("x"), 1<<16)
+	cache.Set("a", append([]byte(nil), payload...), time.Minute)
+	cache.Set("b", append([]byte(nil), payload...), time.Minute)
+	cache.Set("c", []byte("other"), time.Minute)
+
+	a, _ := cache.GetBytes("a")
+	b, _ := cache.GetBytes("b")
+	if &a[0] != &b[0] {
+		t.Fatal("expected a and b to share one object")
+	}
+	if len(cache.shared) != 2 {
+		t.Fatalf("expected 2 shared objects, got %d", len(cache.shared))
+	}
+
+	cache.Del("a")
+	if b, ok := cache.GetBytes("b"); !ok || !bytes.Equal(b, payload) {
+		t.Fatal("expected b to stay valid after deleting a")
+	}
+	cache.Set("b", []byte("new"), time.Minute)
+	cache.Del("c")
+	if len(cache.shared) != 1 {
+		t.Fatalf("expected unreferenced objects to be forgotten, got %d", len(cache.shared))
+	}
+}
+
+func TestDedupValuesHasher(t *testing.T) {
+	type point struct{ X, Y int }
+	cache, err := New(Config{
+		DedupValues: true,
+		// Every object collides, so only deeply equal ones are shared.
+		ValueHasher: func(v interface{}) uint64 { return 1 },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("a", &point{1, 2}, time.Minute)
+	cache.Set("b", &point{1, 2}, time.Minute)
+	cache.Set("c", &point{3, 4}, time.Minute)
+
+	a, _ := cache.Get("a")
+	b, _ := cache.Get("b")
+	c, _ := cache.Get("c")
+	if a.(*point) != b.(*point) {
+		t.Fatal("expected equal objects to be shared")
+	}
+	if *c.(*point) != (point{3, 4}) {
+		t.Fatalf("expected a colliding object to be kept, got %v", c)
+	}
+}
@@ -0,0 +1,56 @@
+package objcache
+
+import "errors"
+
+// The errors of the cache are returned as they are, or wrapped with %w, so
+// they can be matched with errors.Is.
+var (
+	// ErrInvalidConfig is wrapped by the errors New returns for an
+	// invalid Config.
+	ErrInvalidConfig = errors.New("objcache: invalid config")
+
+	// ErrKeyExists is returned by Add when the key is already in the cache.
+	ErrKeyExists = errors.New("objcache: key already exists")
+
+	// ErrKeyNotFound is returned by Replace, Increment and Decrement when
+	// the key is not in the cache, and by loads waiting for a key the
+	// loader of GetOrLoadBatch did not return.
+	ErrKeyNotFound = errors.New("objcache: key not found")
+
+	// ErrNotInt64 is returned by Increment and Decrement when the object
+	// is not an int64, and by CounterCache when it is not a counter.
+	ErrNotInt64 = errors.New("objcache: object is not an int64")
+
+	// ErrCostTooHigh is returned by SetWithCost when the cost of one item
+	// exceeds MaxCost.
+	ErrCostTooHigh = errors.New("objcache: cost exceeds MaxCost")
+
+	// ErrPastDeadline is returned by SetWithDeadline when the deadline has
+	// passed.
+	ErrPastDeadline = errors.New("objcache: deadline has passed")
+
+	// ErrKeyTooLong is returned when a key is longer than MaxKeyLen.
+	ErrKeyTooLong = errors.New("objcache: key is too long")
+
+	// ErrCacheFull is returned by Set and the like for a new key when
+	// the cache is full and Config.RejectOnFull is set.
+	ErrCacheFull = errors.New("objcache: cache is full")
+
+	// ErrNotAdmitted is returned by Set and the like for a new key that
+	// Config.Admission keeps out of the full cache.
+	ErrNotAdmitted = errors.New("objcache: key not admitted")
+
+	// ErrCallbackPanic is returned when a loader or Config.Store panics.
+	// The panic is reported to Config.OnCallbackPanic.
+	ErrCallbackPanic = errors.New("objcache: callback panicked")
+
+	// ErrNoLoader is returned by GetReadThrough when Config.Loader is not
+	// set.
+	ErrNoLoader = errors.New("objcache: no Loader")
+
+	// ErrCacheClosed is returned by writes after Close.
+	ErrCacheClosed = errors.New("objcache: cache is closed")
+
+	// ErrReadOnly is returned by writes while the cache is read-only.
+	ErrReadOnly = errors.New("objcache: cache is read-only")
+)
@@ -0,0 +1,80 @@
+package objcache
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestErrorsIs(t *testing.T) {
+	cache, err := New(Config{MaxEntryLimit: 1, RejectOnFull: true, MaxCost: 10, MaxKeyLen: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("a", "x", 0)
+	readOnly, _ := New(Config{})
+	readOnly.SetReadOnly(true)
+	counters, _ := NewCounterCache(Config{})
+	counters.ObjCache().Set("name", "x", 0)
+	typed, _ := NewCache[int](Config{MaxKeyLen: 1})
+
+	cases := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"Add", cache.Add("a", 1, 0), ErrKeyExists},
+		{"Replace", cache.Replace("missing", 1, 0), ErrKeyNotFound},
+		{"Increment missing", func() error { _, err := cache.Increment("missing", 1); return err }(), ErrKeyNotFound},
+		{"Increment", func() error { _, err := cache.Increment("a", 1); return err }(), ErrNotInt64},
+		{"CounterCache", func() error { _, err := counters.Increment("name", 1); return err }(), ErrNotInt64},
+		{"SetWithCost", cache.SetWithCost("a", 1, 11, 0), ErrCostTooHigh},
+		{"SetWithDeadline", cache.SetWithDeadline("a", 1, time.Now().Add(-time.Second)), ErrPastDeadline},
+		{"Set long key", cache.Set("longer than 8", 1, 0), ErrKeyTooLong},
+		{"Cache[V]", typed.Set("ab", 1, 0), ErrKeyTooLong},
+		{"Namespace", cache.Namespace("namespace").Set("key", 1, 0), ErrKeyTooLong},
+		{"Set full", cache.Set("b", 1, 0), ErrCacheFull},
+		{"Set read-only", readOnly.Set("a", 1, 0), ErrReadOnly},
+		{"ImportJSON read-only", readOnly.ImportJSON([]byte("{}")), ErrReadOnly},
+		{"New", func() error { _, err := New(Config{Shards: -1}); return err }(), ErrInvalidConfig},
+		{"NewSharded", func() error { _, err := NewSharded(Config{MaxBytes: -1}); return err }(), ErrInvalidConfig},
+		{"GetWithLoader", func() error {
+			_, err := cache.GetWithLoader("p", 0, func() (interface{}, error) { panic("load") })
+			return err
+		}(), ErrCallbackPanic},
+		{"GetReadThrough", func() error { _, err := cache.GetReadThrough("a"); return err }(), ErrNoLoader},
+		{"Set closed", func() error { closed, _ := New(Config{}); closed.Close(); return closed.Set("a", 1, 0) }(), ErrCacheClosed},
+	}
+	for _, c := range cases {
+		if !errors.Is(c.err, c.want) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.want, c.err)
+		}
+	}
+}
+
+func TestErrorsIsWrapped(t *testing.T) {
+	storeErr := errors.New("store down")
+	store := newFakeStore()
+	store.setErr(storeErr)
+	cache, err := New(Config{Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Set("a", 1, 0); !errors.Is(err, storeErr) {
+		t.Fatalf("expected the Store error, got %v", err)
+	}
+
+	sharded, err := NewSharded(Config{Shards: 2, Store: store, WriteBehind: true, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sharded.Set("a", 1, 0)
+	if err := sharded.Close(); !errors.Is(err, storeErr) {
+		t.Fatalf("expected Close to return the flush error, got %v", err)
+	}
+
+	if _, err := cache.LoadStream(strings.NewReader(`{"a":`), FormatJSON); err == nil || !strings.HasPrefix(err.Error(), "objcache: load") {
+		t.Fatalf("expected a wrapped decode error, got %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package objcache
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType is the kind of an Event.
+type EventType int
+
+const (
+	// EventSet is sent when an item is set.
+	EventSet EventType = iota
+	// EventHit is sent when Get finds an item.
+	EventHit
+	// EventMiss is sent when Get does not find an item.
+	EventMiss
+	// EventEvict is sent when an item is evicted for capacity.
+	EventEvict
+	// EventExpire is sent when an expired item is removed.
+	EventExpire
+)
+
+// Event is an operation on the cache.
+type Event struct {
+	Type      EventType
+	Key       string
+	Timestamp time.Time
+}
+
+// events is the channel returned by Events. Events are dropped when the
+// channel is full, so the cache never waits for a slow consumer.
+type events struct {
+	mu     sync.RWMutex
+	ch     chan Event
+	closed bool
+}
+
+// Events returns the channel of events. It is nil unless
+// Config.EventBuffer is positive, and it is closed by Close.
+func (c *ObjCache) Events() <-chan Event {
+	return c.events.ch
+}
+
+// emit sends an event without blocking.
+func (c *ObjCache) emit(t EventType, k string) {
+	if c.events.ch == nil {
+		return
+	}
+	c.events.mu.RLock()
+	if !c.events.closed {
+		select {
+		case c.events.ch <- Event{Type: t, Key: k, Timestamp: time.Unix(0, c.now())}:
+		default:
+		}
+	}
+	c.events.mu.RUnlock()
+}
+
+// closeEvents stops sending events and closes the channel.
+func (c *ObjCache) closeEvents() {
+	if c.events.ch == nil {
+		return
+	}
+	c.events.mu.Lock()
+	if !c.events.closed {
+		c.events.closed = true
+		close(c.events.ch)
+	}
+	c.events.mu.Unlock()
+}
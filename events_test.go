@@ -0,0 +1,66 @@
+package objcache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEvents(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{
+		MaxEntryLimit: 1,
+		Expiration:    time.Minute,
+		Clock:         clock,
+		EventBuffer:   16,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("a", 1, 0)
+	cache.Get("a")
+	cache.Get("missing")
+	cache.Set("b", 2, time.Second)
+	clock.Advance(2 * time.Second)
+	cache.Get("b")
+	cache.Close()
+
+	want := []Event{
+		{Type: EventSet, Key: "a"},
+		{Type: EventHit, Key: "a"},
+		{Type: EventMiss, Key: "missing"},
+		{Type: EventEvict, Key: "a"},
+		{Type: EventSet, Key: "b"},
+		{Type: EventExpire, Key: "b"},
+		{Type: EventMiss, Key: "b"},
+	}
+	var got []Event
+	for e := range cache.Events() {
+		got = append(got, e)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d events, got %+v", len(want), got)
+	}
+	for i, e := range got {
+		if e.Type != want[i].Type || e.Key != want[i].Key {
+			t.Fatalf("event %d: expected %+v, got %+v", i, want[i], e)
+		}
+	}
+
+	// Events after Close are not sent.
+	cache.Set("c", 3, 0)
+}
+
+func TestEventsDropped(t *testing.T) {
+	cache, err := New(Config{EventBuffer: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i = i + 1 {
+		cache.Get("missing")
+	}
+	if n := len(cache.Events()); n != 2 {
+		t.Fatalf("expected a full buffer of 2 events, got %d", n)
+	}
+}
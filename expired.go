@@ -0,0 +1,38 @@
+package objcache
+
+// notifyExpired reports whether Config.OnExpired is called for key k
+// expiring now. With Config.ExpiredDebounce it is not called again for k
+// within the window. The caller must hold the write lock.
+func (c *ObjCache) notifyExpired(k string) bool {
+	if c.config.OnExpired == nil {
+		return false
+	}
+	if c.config.ExpiredDebounce <= 0 {
+		return true
+	}
+	now := c.now()
+	if last, ok := c.notified[k]; ok && now-last < int64(c.config.ExpiredDebounce) {
+		return false
+	}
+	if c.notified == nil {
+		c.notified = make(map[string]int64)
+	}
+	// Forget old calls once the map has doubled, so keys that are not set
+	// again do not pile up without a janitor.
+	if len(c.notified) >= c.notifiedMax {
+		c.removeNotified(now)
+		c.notifiedMax = 2*len(c.notified) + 64
+	}
+	c.notified[k] = now
+	return true
+}
+
+// removeNotified forgets the OnExpired calls older than
+// Config.ExpiredDebounce. The caller must hold the write lock.
+func (c *ObjCache) removeNotified(now int64) {
+	for k, last := range c.notified {
+		if now-last >= int64(c.config.ExpiredDebounce) {
+			delete(c.notified, k)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package objcache
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOnExpired(t *testing.T) {
+	clock := newFakeClock()
+	calls := make(map[string]int)
+	cache, err := New(Config{
+		Clock:           clock,
+		OnExpired:       func(k string, v interface{}) { calls[k] = calls[k] + 1 },
+		ExpiredDebounce: 10 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("a", 1, time.Second)
+	cache.Set("b", 2, time.Second)
+	cache.Set("c", 3, time.Minute)
+	clock.Advance(2 * time.Second)
+	cache.DeleteExpired()
+	if calls["a"] != 1 || calls["b"] != 1 || calls["c"] != 0 {
+		t.Fatalf("expected one call for a and b, got %v", calls)
+	}
+
+	cache.Set("a", 1, time.Second)
+	clock.Advance(2 * time.Second)
+	if _, ok := cache.Get("a"); ok {
+		t.Fatal("expected a to expire")
+	}
+	if calls["a"] != 1 {
+		t.Fatalf("expected the second expiry within the window to be suppressed, got %v", calls)
+	}
+
+	clock.Advance(10 * time.Second)
+	cache.Set("a", 1, time.Second)
+	clock.Advance(2 * time.Second)
+	cache.DeleteExpired()
+	if calls["a"] != 2 {
+		t.Fatalf("expected a call after the window, got %v", calls)
+	}
+}
+
+func TestOnExpiredDebounceCleanup(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{
+		Clock:           clock,
+		OnExpired:       func(k string, v interface{}) {},
+		ExpiredDebounce: 10 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expire := func(prefix string, n int) {
+		for i := 0; i < n; i = i + 1 {
+			cache.Set(prefix+strconv.Itoa(i), i, time.Second)
+		}
+		clock.Advance(2 * time.Second)
+		cache.DeleteExpired()
+	}
+
+	expire("old", 100)
+	clock.Advance(20 * time.Second)
+	expire("new", 200)
+	for k := range cache.notified {
+		if strings.HasPrefix(k, "old") {
+			t.Fatalf("expected the old calls to be forgotten, found %s", k)
+		}
+	}
+	if len(cache.notified) != 200 {
+		t.Fatalf("expected 200 recent calls, got %d", len(cache.notified))
+	}
+}
@@ -0,0 +1,38 @@
+package objcache
+
+// expireHeap is a min-heap of pairs ordered by expiration time, then by
+// insertion. It lets removeExpired find expired pairs regardless of their
+// position in the LRU list, and remove those expiring at the same time in
+// the order they were inserted.
+type expireHeap []*pair
+
+func (h expireHeap) Len() int { return len(h) }
+
+func (h expireHeap) Less(i, j int) bool {
+	if h[i].expire != h[j].expire {
+		return h[i].expire < h[j].expire
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h expireHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expireHeap) Push(x interface{}) {
+	p := x.(*pair)
+	p.index = len(*h)
+	*h = append(*h, p)
+}
+
+func (h *expireHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	p := old[n-1]
+	old[n-1] = nil
+	p.index = -1
+	*h = old[:n-1]
+	return p
+}
@@ -0,0 +1,59 @@
+package objcache
+
+// Invalidator carries invalidations between the caches of several
+// instances, over a transport like Redis or NATS. Set, Del and the other
+// writes publish the keys they change, but evictions, expirations, Warm,
+// ReplaceAll, Load and ImportJSON do not. Each key received from
+// Subscribe is deleted from the cache without being published again. An
+// Invalidator must not deliver the keys an instance published back to
+// that instance.
+type Invalidator interface {
+	// Publish sends an invalidation of key to the other instances.
+	Publish(key string) error
+	// Subscribe returns the channel of keys invalidated by the other
+	// instances. It is called once, by New.
+	Subscribe() <-chan string
+}
+
+// invalidated queues k to be published by unlock if there is an
+// Invalidator. The caller must hold the write lock.
+func (c *ObjCache) invalidated(k string) {
+	if c.config.Invalidator != nil {
+		c.published = append(c.published, k)
+	}
+}
+
+// publish publishes an invalidation of k if there is an Invalidator.
+// Errors are ignored, the local write has already been done.
+func (c *ObjCache) publish(k string) {
+	if c.config.Invalidator != nil {
+		defer c.recoverCallback()
+		c.config.Invalidator.Publish(k)
+	}
+}
+
+// invalidations deletes the keys received from ch until Close is called
+// or ch is closed.
+func (c *ObjCache) invalidations(ch <-chan string) {
+	for {
+		select {
+		case k, ok := <-ch:
+			if !ok {
+				return
+			}
+			c.invalidate(k)
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// invalidate deletes the item of k from memory only. The instance that
+// published it has already written Config.Store.
+func (c *ObjCache) invalidate(k string) {
+	c.mu.Lock()
+	if elem, ok := c.items[k]; ok && c.writable() == nil {
+		c.remove(elem, ReasonDeleted)
+	}
+	c.unlock()
+}
@@ -0,0 +1,176 @@
+package objcache
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBus is an in-memory pub/sub bus. Each node receives the keys
+// published by the others.
+type fakeBus struct {
+	mu    sync.Mutex
+	nodes []*fakeNode
+}
+
+type fakeNode struct {
+	bus       *fakeBus
+	ch        chan string
+	published int
+}
+
+func (b *fakeBus) node() *fakeNode {
+	n := &fakeNode{bus: b, ch: make(chan string, 16)}
+	b.mu.Lock()
+	b.nodes = append(b.nodes, n)
+	b.mu.Unlock()
+	return n
+}
+
+func (n *fakeNode) Publish(key string) error {
+	n.bus.mu.Lock()
+	n.published = n.published + 1
+	for _, other := range n.bus.nodes {
+		if other != n {
+			other.ch <- key
+		}
+	}
+	n.bus.mu.Unlock()
+	return nil
+}
+
+func (n *fakeNode) Subscribe() <-chan string {
+	return n.ch
+}
+
+func (n *fakeNode) count() int {
+	n.bus.mu.Lock()
+	defer n.bus.mu.Unlock()
+	return n.published
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestInvalidator(t *testing.T) {
+	bus := &fakeBus{}
+	na, nb := bus.node(), bus.node()
+	warm := []WarmEntry{{Key: "k", Value: 1}, {Key: "set", Value: 2}}
+	a, err := New(Config{Invalidator: na, Warm: warm})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := New(Config{Invalidator: nb, Warm: warm})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if !a.Del("k") {
+		t.Fatal("expected k to be deleted")
+	}
+	waitFor(t, func() bool { return !b.Has("k") })
+
+	a.Set("set", 3, time.Minute)
+	waitFor(t, func() bool { return !b.Has("set") })
+	if v, _ := a.Get("set"); v != 3 {
+		t.Fatalf("expected the publisher to keep its value, got %v", v)
+	}
+
+	if n := na.count(); n != 2 {
+		t.Fatalf("expected 2 invalidations from a, got %d", n)
+	}
+	if n := nb.count(); n != 0 {
+		t.Fatalf("expected received invalidations not to be published, got %d", n)
+	}
+}
+
+func TestInvalidatorWrites(t *testing.T) {
+	bus := &fakeBus{}
+	na, nb := bus.node(), bus.node()
+	warm := []WarmEntry{{Key: "replace", Value: 1}, {Key: "p/1", Value: 2}, {Key: "p/2", Value: 3}, {Key: "q", Value: 4}}
+	a, err := New(Config{Invalidator: na, Warm: warm})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := New(Config{Invalidator: nb, Warm: append(warm, WarmEntry{Key: "add", Value: 5})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if err := a.Add("add", 6, 0); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return !b.Has("add") })
+	if err := a.Replace("replace", 7, 0); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return !b.Has("replace") })
+	if n := a.DeletePrefix("p/"); n != 2 {
+		t.Fatalf("expected DeletePrefix to delete 2 items, got %d", n)
+	}
+	waitFor(t, func() bool { return !b.Has("p/1") && !b.Has("p/2") })
+	if !b.Has("q") {
+		t.Fatal("expected q to be kept")
+	}
+	if n := na.count(); n != 4 {
+		t.Fatalf("expected 4 invalidations from a, got %d", n)
+	}
+}
+
+func TestInvalidatorLoad(t *testing.T) {
+	src, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.Set("a", 1, 0)
+	src.Set("b", 2, time.Minute)
+	var buf bytes.Buffer
+	if err := src.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data, err := src.ExportJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bus := &fakeBus{}
+	na := bus.node()
+	a, err := New(Config{Invalidator: na})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	if err := a.Load(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := a.LoadStream(bytes.NewReader(data), FormatJSON); n != 2 || err != nil {
+		t.Fatalf("expected 2 items loaded, got %d, %v", n, err)
+	}
+	if n := a.Len(); n != 2 {
+		t.Fatalf("expected 2 items, got %d", n)
+	}
+	if n := na.count(); n != 0 {
+		t.Fatalf("expected loading not to publish, got %d invalidations", n)
+	}
+}
+
+func TestInvalidatorSharded(t *testing.T) {
+	bus := &fakeBus{}
+	if _, err := NewSharded(Config{Invalidator: bus.node()}); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("expected ErrInvalidConfig, got %v", err)
+	}
+}
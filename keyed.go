@@ -0,0 +1,235 @@
+package objcache
+
+import (
+	"container/heap"
+	"container/list"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// KeyedCache is a cache of objects of type V keyed by K, so keys like
+// structs or ints are used as they are instead of being turned into
+// strings. It evicts and expires items like ObjCache, with the same
+// expiration heap, and supports the MaxEntryLimit, InitialCapacity,
+// Expiration, MinTTL, TouchOnGet and Clock of its Config.
+type KeyedCache[K comparable, V any] struct {
+	mu     sync.RWMutex
+	items  map[K]*list.Element
+	list   *list.List
+	heap   expireHeap
+	seq    uint64
+	config Config
+	clock  Clock
+}
+
+// keyedEntry is an item of a KeyedCache. Its pair holds the expiration
+// for the heap, with Object pointing back to the entry.
+type keyedEntry[K comparable, V any] struct {
+	key   K
+	value V
+	pair
+}
+
+// NewKeyedCache makes a keyed cache and returns it. It returns an error
+// wrapping ErrInvalidConfig if a field of config other than those
+// KeyedCache supports is set.
+func NewKeyedCache[K comparable, V any](config Config) (*KeyedCache[K, V], error) {
+	if err := checkKeyedConfig(config); err != nil {
+		return nil, err
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	c := &KeyedCache[K, V]{
+		items:  make(map[K]*list.Element, config.InitialCapacity),
+		list:   list.New(),
+		config: config,
+		clock:  config.Clock,
+	}
+	if c.clock == nil {
+		c.clock = realClock{}
+	}
+	return c, nil
+}
+
+// checkKeyedConfig returns an error for the first field of config that
+// KeyedCache does not support.
+func checkKeyedConfig(config Config) error {
+	rest := config
+	rest.MaxEntryLimit = 0
+	rest.InitialCapacity = 0
+	rest.Expiration = 0
+	rest.MinTTL = 0
+	rest.TouchOnGet = false
+	rest.Clock = nil
+	v := reflect.ValueOf(rest)
+	for i := 0; i < v.NumField(); i = i + 1 {
+		if !v.Field(i).IsZero() {
+			return fmt.Errorf("%w: %s with KeyedCache", ErrInvalidConfig, v.Type().Field(i).Name)
+		}
+	}
+	return nil
+}
+
+// Set a value for key. if d is 0, the Expiration time would be default time.
+func (c *KeyedCache[K, V]) Set(k K, v V, d time.Duration) {
+	c.mu.Lock()
+	expire := c.expireAt(d)
+	if elem, ok := c.items[k]; ok {
+		e := elem.Value.(*keyedEntry[K, V])
+		e.value = v
+		e.expire = expire
+		heap.Fix(&c.heap, e.index)
+		c.list.MoveToBack(elem)
+		c.mu.Unlock()
+		return
+	}
+
+	c.removeExpired()
+	limit := c.config.MaxEntryLimit
+	for limit > 0 && len(c.items) >= limit {
+		c.remove(c.list.Front())
+	}
+	e := &keyedEntry[K, V]{key: k, value: v}
+	e.Object = e
+	e.expire = expire
+	c.seq = c.seq + 1
+	e.seq = c.seq
+	c.items[k] = c.list.PushBack(e)
+	heap.Push(&c.heap, &e.pair)
+	c.mu.Unlock()
+}
+
+// Get the value of key. It returns the zero value of V if the key is not
+// in the cache or has expired.
+func (c *KeyedCache[K, V]) Get(k K) (V, bool) {
+	if c.config.TouchOnGet {
+		c.mu.Lock()
+		elem, ok := c.items[k]
+		if ok && c.expired(elem) {
+			c.remove(elem)
+			ok = false
+		}
+		if !ok {
+			c.mu.Unlock()
+			var zero V
+			return zero, false
+		}
+		c.list.MoveToBack(elem)
+		v := elem.Value.(*keyedEntry[K, V]).value
+		c.mu.Unlock()
+		return v, true
+	}
+	v, _, ok := c.lookup(k)
+	return v, ok
+}
+
+// Has reports whether k is in the cache and not expired.
+func (c *KeyedCache[K, V]) Has(k K) bool {
+	_, _, ok := c.lookup(k)
+	return ok
+}
+
+// TTL returns the remaining time to live of key like ObjCache.TTL: -2 if
+// the key is missing or has expired and NoExpiration if it never expires.
+func (c *KeyedCache[K, V]) TTL(k K) time.Duration {
+	_, expire, ok := c.lookup(k)
+	if !ok {
+		return -2
+	}
+	if expire == neverExpire {
+		return NoExpiration
+	}
+	return time.Duration(expire - c.now())
+}
+
+// Del delete an item for some key.
+func (c *KeyedCache[K, V]) Del(k K) bool {
+	c.mu.Lock()
+	elem, ok := c.items[k]
+	if ok {
+		c.remove(elem)
+	}
+	c.mu.Unlock()
+	return ok
+}
+
+// Len returns the number of items in the cache. Expired items are
+// removed before counting.
+func (c *KeyedCache[K, V]) Len() int {
+	c.mu.Lock()
+	c.removeExpired()
+	n := len(c.items)
+	c.mu.Unlock()
+	return n
+}
+
+// lookup returns the value and expiration of k under the read lock. An
+// expired entry is removed lazily, like by ObjCache.lookup.
+func (c *KeyedCache[K, V]) lookup(k K) (V, int64, bool) {
+	var zero V
+	c.mu.RLock()
+	elem, ok := c.items[k]
+	if !ok {
+		c.mu.RUnlock()
+		return zero, 0, false
+	}
+	e := elem.Value.(*keyedEntry[K, V])
+	v, expire := e.value, e.expire
+	c.mu.RUnlock()
+	if expire < c.now() {
+		c.deleteExpired(k)
+		return zero, 0, false
+	}
+	return v, expire, true
+}
+
+// deleteExpired removes k under the write lock if it is still expired.
+func (c *KeyedCache[K, V]) deleteExpired(k K) {
+	c.mu.Lock()
+	if elem, ok := c.items[k]; ok && c.expired(elem) {
+		c.remove(elem)
+	}
+	c.mu.Unlock()
+}
+
+// expired reports whether the entry of elem has expired.
+func (c *KeyedCache[K, V]) expired(elem *list.Element) bool {
+	return elem.Value.(*keyedEntry[K, V]).expire < c.now()
+}
+
+// remove deletes elem from the map, the list and the heap.
+func (c *KeyedCache[K, V]) remove(elem *list.Element) {
+	e := elem.Value.(*keyedEntry[K, V])
+	delete(c.items, e.key)
+	c.list.Remove(elem)
+	heap.Remove(&c.heap, e.index)
+}
+
+// removeExpired removes all expired items.
+func (c *KeyedCache[K, V]) removeExpired() {
+	now := c.now()
+	for len(c.heap) > 0 && c.heap[0].expire < now {
+		c.remove(c.items[c.heap[0].Object.(*keyedEntry[K, V]).key])
+	}
+}
+
+// expireAt is ObjCache.expireAt without jitter.
+func (c *KeyedCache[K, V]) expireAt(d time.Duration) int64 {
+	if d == 0 {
+		d = c.config.Expiration
+	}
+	if d < 0 {
+		return neverExpire
+	}
+	if d < c.config.MinTTL {
+		d = c.config.MinTTL
+	}
+	return addExpire(c.now(), d)
+}
+
+func (c *KeyedCache[K, V]) now() int64 {
+	return c.clock.Now().UnixNano()
+}
@@ -0,0 +1,110 @@
+package objcache
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type tenantKey struct {
+	TenantID int
+	Name     string
+}
+
+func TestKeyedCacheStructKey(t *testing.T) {
+	clock := newFakeClock()
+	c, err := NewKeyedCache[tenantKey, string](Config{MaxEntryLimit: 2, Expiration: time.Minute, Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, b, d := tenantKey{1, "a"}, tenantKey{2, "a"}, tenantKey{1, "d"}
+	c.Set(a, "A", 0)
+	c.Set(b, "B", time.Second)
+	if v, ok := c.Get(a); !ok || v != "A" {
+		t.Fatalf("expected A, got %q, %v", v, ok)
+	}
+	if ttl := c.TTL(b); ttl != time.Second {
+		t.Fatalf("expected a TTL of 1s, got %v", ttl)
+	}
+
+	// A full cache evicts the least recently set key.
+	c.Set(d, "D", NoExpiration)
+	if c.Has(a) || !c.Has(b) || !c.Has(d) {
+		t.Fatal("expected a to be evicted")
+	}
+	if ttl := c.TTL(d); ttl != NoExpiration {
+		t.Fatalf("expected NoExpiration, got %v", ttl)
+	}
+
+	clock.Advance(2 * time.Second)
+	if _, ok := c.Get(b); ok || c.TTL(b) != -2 {
+		t.Fatal("expected b to expire")
+	}
+	if c.Len() != 1 {
+		t.Fatalf("expected 1 item, got %d", c.Len())
+	}
+	if !c.Del(d) || c.Del(d) || c.Len() != 0 {
+		t.Fatal("expected Del to remove d once")
+	}
+}
+
+// TestKeyedCacheMatchesObjCache runs the same operations on a KeyedCache
+// with int keys and on an ObjCache with their strings.
+func TestKeyedCacheMatchesObjCache(t *testing.T) {
+	clock := newFakeClock()
+	config := Config{MaxEntryLimit: 5, Expiration: time.Minute, Clock: clock, TouchOnGet: true}
+	keyed, err := NewKeyedCache[int, int](config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	strs, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer strs.Close()
+
+	for i := 0; i < 40; i = i + 1 {
+		k := i * 7 % 11
+		switch i % 4 {
+		case 0, 1:
+			d := time.Duration(i%3) * time.Second
+			keyed.Set(k, i, d)
+			strs.Set(strconv.Itoa(k), i, d)
+		case 2:
+			keyed.Get(k)
+			strs.Get(strconv.Itoa(k))
+		case 3:
+			clock.Advance(time.Second)
+		}
+		for j := 0; j < 11; j = j + 1 {
+			v, ok := keyed.Get(j)
+			x, found := strs.Get(strconv.Itoa(j))
+			if ok != found || (ok && v != x) {
+				t.Fatalf("step %d, key %d: KeyedCache has %v, %v, ObjCache %v, %v", i, j, v, ok, x, found)
+			}
+		}
+		if keyed.Len() != strs.Len() {
+			t.Fatalf("step %d: KeyedCache has %d items, ObjCache %d", i, keyed.Len(), strs.Len())
+		}
+	}
+}
+
+func TestKeyedCacheUnsupportedConfig(t *testing.T) {
+	for _, config := range []Config{
+		{OnEvicted: func(string, interface{}, EvictReason) {}},
+		{MaxBytes: 1},
+		{Policy: PolicyLFU},
+		{ClockResolution: time.Millisecond},
+		{ExpirationJitter: time.Second},
+		{Store: &fakeStore{}},
+	} {
+		if _, err := NewKeyedCache[int, int](config); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("expected ErrInvalidConfig for %+v, got %v", config, err)
+		}
+	}
+	if _, err := NewKeyedCache[int, int](Config{MaxEntryLimit: 1, InitialCapacity: 1, Expiration: time.Second, MinTTL: time.Second, TouchOnGet: true, Clock: newFakeClock()}); err != nil {
+		t.Fatalf("expected the supported fields to be accepted, got %v", err)
+	}
+}
@@ -0,0 +1,398 @@
+package objcache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// call is a load in flight. Callers for the same key wait on it instead of
+// running the loader again. done is closed when the load has finished.
+type call struct {
+	done chan struct{}
+	val  interface{}
+	err  error
+
+	// waiters is the number of callers waiting on a load of
+	// GetWithContext, and cancel cancels its context when all of them
+	// have given up. They are guarded by loadMu.
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// Loader loads the object of a key and the duration to cache it for, as
+// for Set, for GetReadThrough.
+type Loader interface {
+	Load(key string) (value interface{}, ttl time.Duration, err error)
+}
+
+// negativeEntry is a loader error cached by GetOrCompute until expire.
+type negativeEntry struct {
+	err    error
+	expire int64
+}
+
+// GetWithLoader returns the object of key. If the key is not in the cache,
+// loader is called and its result is set for d. Concurrent callers missing
+// the same key share one call of loader, while loads of different keys
+// run in parallel: no lock is held while a loader runs. If loader fails,
+// nothing is set and all of them get the error. A nil result without
+// error is cached. After Close it returns ErrCacheClosed without calling
+// loader.
+func (c *ObjCache) GetWithLoader(k string, d time.Duration, loader func() (interface{}, error)) (interface{}, error) {
+	return c.load(k, withTTL(d, loader), false)
+}
+
+// GetOrCompute is GetWithLoader, but if loader fails, its error is cached
+// for Config.NegativeTTL. Until then GetOrCompute returns the error without
+// calling loader again. Get still reports the key as not found.
+func (c *ObjCache) GetOrCompute(k string, d time.Duration, loader func() (interface{}, error)) (interface{}, error) {
+	return c.load(k, withTTL(d, loader), c.config.NegativeTTL > 0)
+}
+
+// GetReadThrough returns the object of key. If the key is not in the
+// cache, it is loaded with Config.Loader and set for the duration the
+// Loader returns, like with GetWithLoader. Loader errors are returned and
+// cached for Config.NegativeTTL like with GetOrCompute. It returns
+// ErrNoLoader if Config.Loader is not set.
+func (c *ObjCache) GetReadThrough(k string) (interface{}, error) {
+	if c.config.Loader == nil {
+		return nil, ErrNoLoader
+	}
+	return c.load(k, func() (interface{}, time.Duration, error) {
+		return c.config.Loader.Load(k)
+	}, c.config.NegativeTTL > 0)
+}
+
+// withTTL makes loader return d as the duration of its object.
+func withTTL(d time.Duration, loader func() (interface{}, error)) func() (interface{}, time.Duration, error) {
+	return func() (interface{}, time.Duration, error) {
+		x, err := loader()
+		return x, d, err
+	}
+}
+
+// load is GetWithLoader with the duration returned by loader. If negative
+// is true, loader errors are cached.
+func (c *ObjCache) load(k string, loader func() (interface{}, time.Duration, error), negative bool) (interface{}, error) {
+	if c.closed() {
+		return nil, ErrCacheClosed
+	}
+	if v, ok := c.Get(k); ok {
+		return v, nil
+	}
+
+	c.loadMu.Lock()
+	if cl, ok := c.loads[k]; ok {
+		cl.waiters = cl.waiters + 1
+		c.loadMu.Unlock()
+		<-cl.done
+		return c.copyOut(cl.val), cl.err
+	}
+	if n, ok := c.negatives[k]; ok && negative {
+		if n.expire >= c.now() {
+			c.loadMu.Unlock()
+			return nil, n.err
+		}
+		delete(c.negatives, k)
+	}
+	// A load may have finished since the miss above.
+	if v, ok := c.lookup(k, false); ok {
+		c.loadMu.Unlock()
+		return c.copyOut(v.Object), nil
+	}
+	cl := &call{done: make(chan struct{})}
+	c.loads[k] = cl
+	c.loadMu.Unlock()
+
+	var d time.Duration
+	c.acquire(context.Background())
+	cl.val, cl.err = c.callLoad(k, func() (interface{}, error) {
+		x, ttl, err := loader()
+		d = ttl
+		return x, err
+	})
+	c.release()
+	if cl.err == nil {
+		c.Set(k, cl.val, d)
+	} else {
+		c.logLoad(k, cl.err)
+	}
+
+	c.loadMu.Lock()
+	delete(c.loads, k)
+	if cl.err != nil && negative {
+		c.negatives[k] = negativeEntry{
+			err:    cl.err,
+			expire: addExpire(c.now(), c.config.NegativeTTL),
+		}
+	}
+	c.loadMu.Unlock()
+	close(cl.done)
+
+	return cl.val, cl.err
+}
+
+// GetOrLoadBatch returns the objects of keys. The hits are read under
+// one lock, and the missing keys are loaded with one call of
+// loadMissing and set for d. Keys loadMissing leaves out of its result
+// are left out of the result too, and other callers waiting for them
+// get ErrKeyNotFound. Keys already being loaded by another
+// GetOrLoadBatch or GetWithLoader are waited for instead of being
+// passed to loadMissing, so overlapping batches are coalesced, but only
+// on a best-effort basis: a key is loaded again if its load finishes
+// before the batch looks for it. On error the objects found are
+// returned with the first error. After Close it returns ErrCacheClosed
+// without calling loadMissing.
+func (c *ObjCache) GetOrLoadBatch(keys []string, d time.Duration, loadMissing func(missing []string) (map[string]interface{}, error)) (map[string]interface{}, error) {
+	if c.closed() {
+		return nil, ErrCacheClosed
+	}
+	found, missing := c.GetMulti(keys)
+	if len(missing) == 0 {
+		return found, nil
+	}
+
+	own := make(map[string]*call, len(missing))
+	var waits []string
+	var load []string
+	c.loadMu.Lock()
+	for _, k := range missing {
+		if _, ok := own[k]; ok {
+			continue
+		}
+		if _, ok := c.loads[k]; ok {
+			waits = append(waits, k)
+			continue
+		}
+		// A load may have finished since the miss above.
+		if v, ok := c.lookup(k, false); ok {
+			found[k] = c.copyOut(v.Object)
+			continue
+		}
+		cl := &call{done: make(chan struct{})}
+		c.loads[k] = cl
+		own[k] = cl
+		load = append(load, k)
+	}
+	waiting := make([]*call, len(waits))
+	for i, k := range waits {
+		waiting[i] = c.loads[k]
+		waiting[i].waiters = waiting[i].waiters + 1
+	}
+	c.loadMu.Unlock()
+
+	var first error
+	if len(load) > 0 {
+		c.acquire(context.Background())
+		x, err := c.callLoad(strings.Join(load, ","), func() (interface{}, error) { return loadMissing(load) })
+		c.release()
+		loaded, _ := x.(map[string]interface{})
+		if err != nil {
+			first = err
+			c.logLoad(strings.Join(load, ","), err)
+		} else {
+			for _, k := range load {
+				if v, ok := loaded[k]; ok {
+					c.Set(k, v, d)
+				}
+			}
+		}
+
+		c.loadMu.Lock()
+		for _, k := range load {
+			cl := own[k]
+			if v, ok := loaded[k]; ok && err == nil {
+				cl.val = v
+				found[k] = v
+			} else if err != nil {
+				cl.err = err
+			} else {
+				cl.err = ErrKeyNotFound
+			}
+			delete(c.loads, k)
+		}
+		c.loadMu.Unlock()
+		for _, cl := range own {
+			close(cl.done)
+		}
+	}
+
+	for i, cl := range waiting {
+		<-cl.done
+		if cl.err == nil {
+			found[waits[i]] = c.copyOut(cl.val)
+		} else if !errors.Is(cl.err, ErrKeyNotFound) && first == nil {
+			first = cl.err
+		}
+	}
+	return found, first
+}
+
+// GetWithContext is GetWithLoader with ctx passed to loader. It returns
+// ctx.Err() as soon as ctx is done, even while waiting for the load of
+// another caller. The load runs in its own goroutine, with the values of
+// the context of the caller that started it, and is only cancelled when
+// all callers waiting for it have given up, so one cancelled caller does
+// not fail the others.
+func (c *ObjCache) GetWithContext(ctx context.Context, k string, d time.Duration, loader func(context.Context) (interface{}, error)) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if c.closed() {
+		return nil, ErrCacheClosed
+	}
+	if v, ok := c.Get(k); ok {
+		return v, nil
+	}
+
+	c.loadMu.Lock()
+	cl, ok := c.loads[k]
+	if !ok {
+		// A load may have finished since the miss above.
+		if v, ok := c.lookup(k, false); ok {
+			c.loadMu.Unlock()
+			return c.copyOut(v.Object), nil
+		}
+		lctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		cl = &call{done: make(chan struct{}), cancel: cancel}
+		c.loads[k] = cl
+		go c.loadContext(lctx, k, d, cl, loader)
+	}
+	cl.waiters = cl.waiters + 1
+	c.loadMu.Unlock()
+
+	select {
+	case <-cl.done:
+		return c.copyOut(cl.val), cl.err
+	case <-ctx.Done():
+		c.loadMu.Lock()
+		cl.waiters = cl.waiters - 1
+		if cl.waiters == 0 && cl.cancel != nil {
+			// Nobody waits any more, so later callers start a new load.
+			cl.cancel()
+			if c.loads[k] == cl {
+				delete(c.loads, k)
+			}
+		}
+		c.loadMu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// loadContext runs the load cl of GetWithContext.
+func (c *ObjCache) loadContext(ctx context.Context, k string, d time.Duration, cl *call, loader func(context.Context) (interface{}, error)) {
+	if cl.err = c.acquire(ctx); cl.err == nil {
+		cl.val, cl.err = c.callLoad(k, func() (interface{}, error) { return loader(ctx) })
+		c.release()
+	}
+	if cl.err == nil {
+		c.Set(k, cl.val, d)
+	} else {
+		c.logLoad(k, cl.err)
+	}
+
+	c.loadMu.Lock()
+	if c.loads[k] == cl {
+		delete(c.loads, k)
+	}
+	c.loadMu.Unlock()
+	cl.cancel()
+	close(cl.done)
+}
+
+// acquire waits for a free slot of Config.MaxConcurrentLoads, or until
+// ctx is done.
+func (c *ObjCache) acquire(ctx context.Context) error {
+	if c.loadSlots == nil {
+		return nil
+	}
+	select {
+	case c.loadSlots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release frees the slot taken by acquire.
+func (c *ObjCache) release() {
+	if c.loadSlots != nil {
+		<-c.loadSlots
+	}
+}
+
+// callLoad is call for a loader of k, timed on the cache clock for
+// Config.OnLoad and Stats.
+func (c *ObjCache) callLoad(k string, fn func() (interface{}, error)) (interface{}, error) {
+	start := c.now()
+	x, err := c.call(fn)
+	d := time.Duration(c.now() - start)
+	atomic.AddInt64(&c.stats.loads, 1)
+	atomic.AddInt64(&c.stats.loadTime, int64(d))
+	if err != nil {
+		atomic.AddInt64(&c.stats.loadErrors, 1)
+	}
+	if c.config.OnLoad != nil {
+		c.guard(func() { c.config.OnLoad(k, d, err) })
+	}
+	return x, err
+}
+
+// logLoad logs the error of a load of k.
+func (c *ObjCache) logLoad(k string, err error) {
+	c.log(LevelWarn, "objcache: load failed", map[string]interface{}{"key": k, "error": err})
+}
+
+// removeNegatives removes the expired loader errors cached by
+// GetOrCompute.
+func (c *ObjCache) removeNegatives() {
+	e := c.now()
+	c.loadMu.Lock()
+	for k, n := range c.negatives {
+		if n.expire < e {
+			delete(c.negatives, k)
+		}
+	}
+	c.loadMu.Unlock()
+}
+
+// refreshAhead reloads k in the background with Config.Reload if v expires
+// within Config.RefreshAhead or is stale after the soft deadline of
+// SetWithSoftHard. Only one reload of a key runs at a time.
+func (c *ObjCache) refreshAhead(k string, v *pair) {
+	if c.config.Reload == nil {
+		return
+	}
+	if !c.stale(v) && (c.config.RefreshAhead <= 0 || v.expire == neverExpire || v.expire-c.now() >= int64(c.config.RefreshAhead)) {
+		return
+	}
+
+	c.loadMu.Lock()
+	if _, ok := c.loads[k]; ok {
+		c.loadMu.Unlock()
+		return
+	}
+	cl := &call{done: make(chan struct{})}
+	c.loads[k] = cl
+	c.loadMu.Unlock()
+
+	go func() {
+		c.acquire(context.Background())
+		cl.val, cl.err = c.call(func() (interface{}, error) { return c.config.Reload(k) })
+		c.release()
+		if cl.err == nil && v.soft != 0 {
+			c.SetWithSoftHard(k, cl.val, v.softTTL, v.ttl)
+		} else if cl.err == nil {
+			c.Set(k, cl.val, v.ttl)
+		} else {
+			c.logLoad(k, cl.err)
+		}
+		c.loadMu.Lock()
+		delete(c.loads, k)
+		c.loadMu.Unlock()
+		close(cl.done)
+	}()
+}
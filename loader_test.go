@@ -0,0 +1,625 @@
+package objcache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetWithLoader(t *testing.T) {
+	cache, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls int32
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i = i + 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			v, err := cache.GetWithLoader("k", 0, func() (interface{}, error) {
+				atomic.AddInt32(&calls, 1)
+				time.Sleep(20 * time.Millisecond)
+				return "loaded", nil
+			})
+			if err != nil || v != "loaded" {
+				t.Errorf("unexpected result %v, %v", v, err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Fatalf("expected loader to run once, ran %d times", calls)
+	}
+	if v, ok := cache.Get("k"); !ok || v != "loaded" {
+		t.Fatalf("expected loaded value to be cached, got %v, %v", v, ok)
+	}
+}
+
+func TestGetWithLoaderError(t *testing.T) {
+	cache, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failure := errors.New("failure")
+	if _, err := cache.GetWithLoader("k", 0, func() (interface{}, error) {
+		return nil, failure
+	}); err != failure {
+		t.Fatalf("expected loader error, got %v", err)
+	}
+	if cache.Has("k") {
+		t.Fatal("failed load must not be cached")
+	}
+}
+
+func TestGetOrComputeNegativeTTL(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{Clock: clock, NegativeTTL: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failure := errors.New("failure")
+	calls := 0
+	loader := func() (interface{}, error) {
+		calls = calls + 1
+		return nil, failure
+	}
+
+	for i := 0; i < 3; i = i + 1 {
+		if _, err := cache.GetOrCompute("k", 0, loader); err != failure {
+			t.Fatalf("expected loader error, got %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected loader to run once in the negative window, ran %d times", calls)
+	}
+	if _, ok := cache.Get("k"); ok {
+		t.Fatal("expected Get to miss a negatively cached key")
+	}
+
+	clock.Advance(2 * time.Second)
+	if _, err := cache.GetOrCompute("k", 0, loader); err != failure {
+		t.Fatalf("expected loader error, got %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected loader to run again after the window, ran %d times", calls)
+	}
+
+	cache.Set("k", "set", 0)
+	v, err := cache.GetOrCompute("k", 0, loader)
+	if err != nil || v != "set" {
+		t.Fatalf("expected the set value over the cached error, got %v, %v", v, err)
+	}
+}
+
+func TestGetWithContextCancel(t *testing.T) {
+	cache, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		<-started
+		cancel()
+	}()
+	_, err = cache.GetWithContext(ctx, "k", 0, func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		close(stopped)
+		return nil, ctx.Err()
+	})
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("expected the loader context to be cancelled")
+	}
+	if cache.Has("k") {
+		t.Fatal("a cancelled load must not be cached")
+	}
+}
+
+func TestGetWithContextDeadline(t *testing.T) {
+	cache, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	release := make(chan struct{})
+	defer close(release)
+	_, err = cache.GetWithContext(ctx, "k", 0, func(ctx context.Context) (interface{}, error) {
+		<-release
+		return "late", nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestGetWithContextSharedLoad(t *testing.T) {
+	cache, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var calls int32
+	loader := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		close(started)
+		select {
+		case <-release:
+			return "loaded", nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	first, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() {
+		_, err := cache.GetWithContext(first, "k", 0, loader)
+		errc <- err
+	}()
+	<-started
+
+	result := make(chan interface{})
+	go func() {
+		v, err := cache.GetWithContext(context.Background(), "k", 0, loader)
+		if err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+		result <- v
+	}()
+	// Wait for the second caller to join the load.
+	for {
+		cache.loadMu.Lock()
+		n := cache.loads["k"].waiters
+		cache.loadMu.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	close(release)
+	if v := <-result; v != "loaded" {
+		t.Fatalf("expected the other caller to get the result, got %v", v)
+	}
+	if calls != 1 {
+		t.Fatalf("expected loader to run once, ran %d times", calls)
+	}
+}
+
+func TestRefreshAhead(t *testing.T) {
+	clock := newFakeClock()
+	var reloads int32
+	release := make(chan struct{})
+	cache, err := New(Config{
+		Clock:        clock,
+		RefreshAhead: 3 * time.Second,
+		Reload: func(k string) (interface{}, error) {
+			atomic.AddInt32(&reloads, 1)
+			<-release
+			return "new", nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("k", "old", 10*time.Second)
+	if v, _ := cache.Get("k"); v != "old" || atomic.LoadInt32(&reloads) != 0 {
+		t.Fatal("expected no reload of a fresh item")
+	}
+
+	clock.Advance(8 * time.Second)
+	for i := 0; i < 5; i = i + 1 {
+		if v, ok := cache.Get("k"); !ok || v != "old" {
+			t.Fatalf("expected the current object while reloading, got %v, %v", v, ok)
+		}
+	}
+	close(release)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if v, _ := cache.Peek("k"); v == "new" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the item to be reloaded")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&reloads); n != 1 {
+		t.Fatalf("expected one reload, got %d", n)
+	}
+	if _, expire, _ := cache.GetWithExpiration("k"); expire.Sub(clock.Now()) != 10*time.Second {
+		t.Fatalf("expected the reloaded item to keep its TTL, got %v", expire.Sub(clock.Now()))
+	}
+}
+
+func TestMaxConcurrentLoads(t *testing.T) {
+	cache, err := New(Config{MaxConcurrentLoads: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var running, peak int64
+	loader := func() (interface{}, error) {
+		n := atomic.AddInt64(&running, 1)
+		for {
+			p := atomic.LoadInt64(&peak)
+			if n <= p || atomic.CompareAndSwapInt64(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt64(&running, -1)
+		return 1, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i = i + 1 {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := cache.GetWithLoader(string(rune('a'+i)), time.Minute, loader); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if peak > 3 {
+		t.Fatalf("expected at most 3 loaders at once, got %d", peak)
+	}
+	if cache.Len() != 20 {
+		t.Fatalf("expected 20 loaded items, got %d", cache.Len())
+	}
+}
+
+func TestMaxConcurrentLoadsContext(t *testing.T) {
+	cache, err := New(Config{MaxConcurrentLoads: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go cache.GetWithLoader("slow", time.Minute, func() (interface{}, error) {
+		close(started)
+		<-release
+		return 1, nil
+	})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = cache.GetWithContext(ctx, "other", time.Minute, func(context.Context) (interface{}, error) {
+		return 2, nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the wait for a slot to time out, got %v", err)
+	}
+	close(release)
+
+	v, err := cache.GetWithContext(context.Background(), "other", time.Minute, func(context.Context) (interface{}, error) {
+		return 2, nil
+	})
+	if err != nil || v != 2 {
+		t.Fatalf("expected 2 once the slot is free, got %v, %v", v, err)
+	}
+}
+
+// fakeLoader is a Loader counting its calls. It fails for the key "bad".
+type fakeLoader struct {
+	calls int32
+	ttl   time.Duration
+}
+
+func (l *fakeLoader) Load(k string) (interface{}, time.Duration, error) {
+	atomic.AddInt32(&l.calls, 1)
+	time.Sleep(10 * time.Millisecond)
+	if k == "bad" {
+		return nil, 0, errors.New("bad key")
+	}
+	return "loaded " + k, l.ttl, nil
+}
+
+func TestGetReadThrough(t *testing.T) {
+	clock := newFakeClock()
+	loader := &fakeLoader{ttl: time.Second}
+	cache, err := New(Config{Loader: loader, Clock: clock, Expiration: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i = i + 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := cache.GetReadThrough("k"); err != nil || v != "loaded k" {
+				t.Errorf("unexpected result %v, %v", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if loader.calls != 1 {
+		t.Fatalf("expected the Loader to run once, ran %d times", loader.calls)
+	}
+	if v, err := cache.GetReadThrough("k"); err != nil || v != "loaded k" || loader.calls != 1 {
+		t.Fatalf("expected a cached hit, got %v, %v after %d calls", v, err, loader.calls)
+	}
+
+	// The item is set for the duration the Loader returned.
+	clock.Advance(2 * time.Second)
+	if cache.Has("k") {
+		t.Fatal("expected the TTL of the Loader to be used")
+	}
+
+	if _, err := cache.GetReadThrough("bad"); err == nil || err.Error() != "bad key" {
+		t.Fatalf("expected the Loader error, got %v", err)
+	}
+	if _, err := cache.GetReadThrough("bad"); err == nil || loader.calls != 3 {
+		t.Fatalf("expected the error not to be cached, got %v after %d calls", err, loader.calls)
+	}
+	if cache.Has("bad") {
+		t.Fatal("failed load must not be cached")
+	}
+
+	none, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := none.GetReadThrough("k"); !errors.Is(err, ErrNoLoader) {
+		t.Fatalf("expected ErrNoLoader, got %v", err)
+	}
+}
+
+func TestGetReadThroughNegativeTTL(t *testing.T) {
+	loader := &fakeLoader{}
+	cache, err := New(Config{Loader: loader, NegativeTTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.GetReadThrough("bad")
+	if _, err := cache.GetReadThrough("bad"); err == nil || loader.calls != 1 {
+		t.Fatalf("expected the cached error, got %v after %d calls", err, loader.calls)
+	}
+}
+
+func TestGetOrLoadBatch(t *testing.T) {
+	cache, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("a", 1, 0)
+	cache.Set("b", 2, 0)
+
+	var got [][]string
+	load := func(missing []string) (map[string]interface{}, error) {
+		got = append(got, append([]string(nil), missing...))
+		found := make(map[string]interface{})
+		for _, k := range missing {
+			if k != "none" {
+				found[k] = "loaded " + k
+			}
+		}
+		return found, nil
+	}
+	found, err := cache.GetOrLoadBatch([]string{"a", "x", "b", "y", "x", "none"}, 0, load)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, [][]string{{"x", "y", "none"}}) {
+		t.Fatalf("expected one load of the missing keys, got %v", got)
+	}
+	want := map[string]interface{}{"a": 1, "b": 2, "x": "loaded x", "y": "loaded y"}
+	if !reflect.DeepEqual(found, want) {
+		t.Fatalf("expected %v, got %v", want, found)
+	}
+	if v, ok := cache.Get("x"); !ok || v != "loaded x" {
+		t.Fatalf("expected x to be cached, got %v, %v", v, ok)
+	}
+
+	// Everything is cached now but the key the loader did not return.
+	got = nil
+	if _, err := cache.GetOrLoadBatch([]string{"a", "x", "y"}, 0, load); err != nil || got != nil {
+		t.Fatalf("expected no load, got %v, %v", got, err)
+	}
+
+	failure := errors.New("failure")
+	found, err = cache.GetOrLoadBatch([]string{"a", "z"}, 0, func([]string) (map[string]interface{}, error) {
+		return nil, failure
+	})
+	if err != failure || !reflect.DeepEqual(found, map[string]interface{}{"a": 1}) {
+		t.Fatalf("expected the hits with the error, got %v, %v", found, err)
+	}
+	if cache.Has("z") {
+		t.Fatal("failed load must not be cached")
+	}
+}
+
+func TestGetOrLoadBatchCoalesce(t *testing.T) {
+	cache, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go cache.GetWithLoader("shared", 0, func() (interface{}, error) {
+		close(started)
+		<-release
+		return "from loader", nil
+	})
+	<-started
+
+	var missing []string
+	done := make(chan map[string]interface{})
+	go func() {
+		found, _ := cache.GetOrLoadBatch([]string{"shared", "own"}, 0, func(keys []string) (map[string]interface{}, error) {
+			missing = keys
+			return map[string]interface{}{"own": 1}, nil
+		})
+		done <- found
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	found := <-done
+	if !reflect.DeepEqual(missing, []string{"own"}) {
+		t.Fatalf("expected only own to be loaded, got %v", missing)
+	}
+	if found["shared"] != "from loader" || found["own"] != 1 {
+		t.Fatalf("unexpected result %v", found)
+	}
+}
+
+func TestGetWithLoaderParallelKeys(t *testing.T) {
+	cache, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const keys = 20
+	const delay = 50 * time.Millisecond
+	var calls int32
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < keys*3; i = i + 1 {
+		k := "k" + string(rune('a'+i%keys))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cache.GetWithLoader(k, 0, func() (interface{}, error) {
+				atomic.AddInt32(&calls, 1)
+				time.Sleep(delay)
+				return k, nil
+			})
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	if calls != keys {
+		t.Fatalf("expected one load per key, got %d", calls)
+	}
+	// Serialized loads would take keys * delay.
+	if elapsed > 5*delay {
+		t.Fatalf("expected the loads of different keys to run in parallel, took %v", elapsed)
+	}
+}
+
+func TestOnLoad(t *testing.T) {
+	type report struct {
+		key string
+		d   time.Duration
+		err error
+	}
+	var mu sync.Mutex
+	var reports []report
+	clock := newFakeClock()
+	cache, err := New(Config{
+		Clock: clock,
+		OnLoad: func(k string, d time.Duration, err error) {
+			mu.Lock()
+			reports = append(reports, report{k, d, err})
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The loader takes 250ms on the clock, and a second caller waits for
+	// it without reporting a load of its own.
+	started := make(chan struct{})
+	release := make(chan struct{})
+	loader := func() (interface{}, error) {
+		clock.Advance(250 * time.Millisecond)
+		close(started)
+		<-release
+		return 1, nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i = i + 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cache.GetWithLoader("a", 0, loader)
+		}()
+		if i == 0 {
+			<-started
+		}
+	}
+	waitFor(t, func() bool {
+		cache.loadMu.Lock()
+		defer cache.loadMu.Unlock()
+		return cache.loads["a"] != nil && cache.loads["a"].waiters == 1
+	})
+	clock.Advance(time.Second)
+	close(release)
+	wg.Wait()
+
+	failure := errors.New("backend down")
+	cache.GetWithLoader("b", 0, func() (interface{}, error) {
+		clock.Advance(50 * time.Millisecond)
+		return nil, failure
+	})
+
+	want := []report{{"a", 1250 * time.Millisecond, nil}, {"b", 50 * time.Millisecond, failure}}
+	if !reflect.DeepEqual(reports, want) {
+		t.Fatalf("expected %v, got %v", want, reports)
+	}
+	s := cache.Stats()
+	if s.Loads != 2 || s.LoadErrors != 1 || s.LoadTime != 1300*time.Millisecond {
+		t.Fatalf("expected 2 loads, 1 error and 1.3s, got %d, %d and %v", s.Loads, s.LoadErrors, s.LoadTime)
+	}
+	cache.ResetStats()
+	if s := cache.Stats(); s.Loads != 0 || s.LoadErrors != 0 || s.LoadTime != 0 {
+		t.Fatalf("expected the load stats to be reset, got %+v", s)
+	}
+}
+
+func TestOnLoadReadThrough(t *testing.T) {
+	var got time.Duration
+	var gotErr error
+	cache, err := New(Config{
+		Loader: &fakeLoader{},
+		OnLoad: func(k string, d time.Duration, err error) {
+			got = d
+			gotErr = err
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.GetReadThrough("bad"); err == nil {
+		t.Fatal("expected the error of the loader")
+	}
+	// fakeLoader sleeps 10ms.
+	if got < 10*time.Millisecond || gotErr == nil || gotErr.Error() != "bad key" {
+		t.Fatalf("expected at least 10ms and the error of the loader, got %v, %v", got, gotErr)
+	}
+}
@@ -0,0 +1,42 @@
+package objcache
+
+// Level is the severity of a log record.
+type Level int
+
+// The levels, from the least severe.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// Logger receives the log records of the cache: janitor sweeps and
+// capacity evictions at LevelDebug, loader errors at LevelWarn and Store
+// errors at LevelError. It is never called with the lock held.
+type Logger interface {
+	Log(level Level, msg string, fields map[string]interface{})
+}
+
+// logRecord is a record logged under the write lock, waiting for unlock.
+type logRecord struct {
+	level  Level
+	msg    string
+	fields map[string]interface{}
+}
+
+// log sends a record to Config.Logger, if it is set. The caller must not
+// hold the lock.
+func (c *ObjCache) log(level Level, msg string, fields map[string]interface{}) {
+	if c.config.Logger != nil {
+		c.guard(func() { c.config.Logger.Log(level, msg, fields) })
+	}
+}
+
+// logLocked queues a record for unlock to log. The caller must hold the
+// write lock.
+func (c *ObjCache) logLocked(level Level, msg string, fields map[string]interface{}) {
+	if c.config.Logger != nil {
+		c.logs = append(c.logs, logRecord{level: level, msg: msg, fields: fields})
+	}
+}
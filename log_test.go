@@ -0,0 +1,93 @@
+package objcache
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// captureLogger records the log records it receives.
+type captureLogger struct {
+	mu      sync.Mutex
+	records []logRecord
+}
+
+func (l *captureLogger) Log(level Level, msg string, fields map[string]interface{}) {
+	l.mu.Lock()
+	l.records = append(l.records, logRecord{level: level, msg: msg, fields: fields})
+	l.mu.Unlock()
+}
+
+// find returns the first record with msg.
+func (l *captureLogger) find(msg string) (logRecord, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, r := range l.records {
+		if r.msg == msg {
+			return r, true
+		}
+	}
+	return logRecord{}, false
+}
+
+func TestLoggerJanitor(t *testing.T) {
+	logger := &captureLogger{}
+	cache, err := New(Config{Logger: logger, JanitorInterval: 5 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	cache.Set("a", 1, time.Millisecond)
+	waitFor(t, func() bool {
+		r, ok := logger.find("objcache: janitor sweep")
+		return ok && r.fields["expired"] == 1
+	})
+	r, _ := logger.find("objcache: janitor sweep")
+	if r.level != LevelDebug {
+		t.Fatalf("expected LevelDebug, got %d", r.level)
+	}
+}
+
+func TestLoggerLoadError(t *testing.T) {
+	logger := &captureLogger{}
+	cache, err := New(Config{Logger: logger, MaxEntryLimit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	failure := errors.New("failure")
+	cache.GetWithLoader("k", 0, func() (interface{}, error) { return nil, failure })
+	r, ok := logger.find("objcache: load failed")
+	if !ok || r.level != LevelWarn || r.fields["key"] != "k" || r.fields["error"] != failure {
+		t.Fatalf("expected a load failure record, got %+v, %v", r, ok)
+	}
+
+	// Capacity evictions are logged too.
+	cache.Set("a", 1, 0)
+	cache.Set("b", 2, 0)
+	r, ok = logger.find("objcache: evicted")
+	if !ok || r.fields["key"] != "a" {
+		t.Fatalf("expected an eviction record for a, got %+v, %v", r, ok)
+	}
+}
+
+func TestLoggerStoreError(t *testing.T) {
+	logger := &captureLogger{}
+	store := newFakeStore()
+	storeErr := errors.New("store down")
+	store.setErr(storeErr)
+	cache, err := New(Config{Logger: logger, Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	cache.Set("a", 1, 0)
+	r, ok := logger.find("objcache: store failed")
+	if !ok || r.level != LevelError || r.fields["key"] != "a" || r.fields["error"] != storeErr {
+		t.Fatalf("expected a store failure record, got %+v, %v", r, ok)
+	}
+}
@@ -0,0 +1,17 @@
+package objcache
+
+import "fmt"
+
+// mismatch handles the object x of key, which is not of type want, as
+// Config.OnTypeMismatch says. It panics with TypeMismatchPanic and returns
+// whether the caller should fail rather than report a miss. The caller
+// must not hold the lock.
+func (c *ObjCache) mismatch(k string, x interface{}, want string) bool {
+	switch c.config.OnTypeMismatch {
+	case TypeMismatchPanic:
+		panic(fmt.Sprintf("objcache: object of %q is %T, not %s", k, x, want))
+	case TypeMismatchMiss:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,104 @@
+package objcache
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTypeMismatch(t *testing.T) {
+	for _, mode := range []TypeMismatch{TypeMismatchError, TypeMismatchMiss, TypeMismatchPanic} {
+		c, err := New(Config{
+			MaxEntryLimit:  10,
+			Expiration:     time.Minute,
+			OnTypeMismatch: mode,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Set("k", "string", 0)
+
+		panicked := func(fn func()) (p bool) {
+			defer func() { p = recover() != nil }()
+			fn()
+			return false
+		}
+
+		var n int64
+		var b []byte
+		var ok bool
+		if p := panicked(func() { n, err = c.Increment("k", 1) }); p != (mode == TypeMismatchPanic) {
+			t.Fatalf("mode %d: expected Increment to panic: %v, got %v", mode, mode == TypeMismatchPanic, p)
+		}
+		if p := panicked(func() { b, ok = c.GetBytes("k") }); p != (mode == TypeMismatchPanic) {
+			t.Fatalf("mode %d: expected GetBytes to panic: %v, got %v", mode, mode == TypeMismatchPanic, p)
+		}
+		switch mode {
+		case TypeMismatchError:
+			if !errors.Is(err, ErrNotInt64) || n != 0 {
+				t.Fatalf("expected ErrNotInt64, got %d, %v", n, err)
+			}
+		case TypeMismatchMiss:
+			if !errors.Is(err, ErrKeyNotFound) || n != 0 {
+				t.Fatalf("expected ErrKeyNotFound, got %d, %v", n, err)
+			}
+		}
+		if b != nil || ok {
+			t.Fatalf("mode %d: expected a miss from GetBytes, got %v, %v", mode, b, ok)
+		}
+
+		// The lock is released before panicking.
+		if x, ok := c.Get("k"); !ok || x != "string" {
+			t.Fatalf("mode %d: expected the object to stay, got %v, %v", mode, x, ok)
+		}
+		c.Close()
+	}
+}
+
+func TestTypeMismatchSetIfGreater(t *testing.T) {
+	c, err := New(Config{MaxEntryLimit: 10, OnTypeMismatch: TypeMismatchMiss})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.Set("k", "string", 0)
+	if n, ok := c.SetIfGreater("k", 5, 0); !ok || n != 5 {
+		t.Fatalf("expected the string to count as missing, got %d, %v", n, ok)
+	}
+	if x, ok := c.Get("k"); !ok || x != int64(5) {
+		t.Fatalf("expected 5, got %v, %v", x, ok)
+	}
+}
+
+func TestTypeMismatchCounterCache(t *testing.T) {
+	c, err := NewCounterCache(Config{MaxEntryLimit: 10, OnTypeMismatch: TypeMismatchMiss})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.ObjCache().Close()
+
+	c.ObjCache().Set("k", "string", 0)
+	if n, err := c.Increment("k", 2); err != nil || n != 2 {
+		t.Fatalf("expected a new counter, got %d, %v", n, err)
+	}
+	if n, ok := c.Get("k"); !ok || n != 2 {
+		t.Fatalf("expected 2, got %d, %v", n, ok)
+	}
+}
+
+func TestTypeMismatchTyped(t *testing.T) {
+	c, err := NewCache[int](Config{MaxEntryLimit: 10, OnTypeMismatch: TypeMismatchPanic})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.ObjCache().Close()
+
+	c.ObjCache().Set("k", "string", 0)
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected Get to panic")
+		}
+	}()
+	c.Get("k")
+}
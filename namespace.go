@@ -0,0 +1,40 @@
+package objcache
+
+import "time"
+
+// namespaceSep separates the prefix of a Namespace from its keys.
+const namespaceSep = ":"
+
+// Namespace is a view of the cache whose keys are prefixed. Namespaces of
+// one cache share its capacity and LRU list.
+type Namespace struct {
+	cache  *ObjCache
+	prefix string
+}
+
+// Namespace returns a view of the cache whose keys are prefixed with
+// prefix and ":".
+func (c *ObjCache) Namespace(prefix string) *Namespace {
+	return &Namespace{cache: c, prefix: prefix + namespaceSep}
+}
+
+// Set a value for key in the namespace. See ObjCache.Set.
+func (n *Namespace) Set(k string, x interface{}, d time.Duration) error {
+	return n.cache.Set(n.prefix+k, x, d)
+}
+
+// Get the object of key in the namespace.
+func (n *Namespace) Get(k string) (interface{}, bool) {
+	return n.cache.Get(n.prefix + k)
+}
+
+// Del delete an item for some key in the namespace.
+func (n *Namespace) Del(k string) bool {
+	return n.cache.Del(n.prefix + k)
+}
+
+// DeleteNamespace deletes all items of the namespace prefix under one lock
+// and returns how many. It scans all items.
+func (c *ObjCache) DeleteNamespace(prefix string) int {
+	return c.DeletePrefix(prefix + namespaceSep)
+}
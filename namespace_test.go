@@ -0,0 +1,41 @@
+package objcache
+
+import "testing"
+
+func TestNamespace(t *testing.T) {
+	cache, err := New(Config{MaxEntryLimit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users := cache.Namespace("users")
+	sessions := cache.Namespace("sessions")
+	users.Set("1", "alice", 0)
+	sessions.Set("1", "token", 0)
+
+	if v, ok := users.Get("1"); !ok || v != "alice" {
+		t.Fatalf("expected alice, got %v, %v", v, ok)
+	}
+	if v, ok := sessions.Get("1"); !ok || v != "token" {
+		t.Fatalf("expected token, got %v, %v", v, ok)
+	}
+
+	users.Set("2", "bob", 0)
+	cache.Set("usersX", "other", 0)
+	if n := cache.DeleteNamespace("users"); n != 2 {
+		t.Fatalf("expected 2 deleted items, got %d", n)
+	}
+	if _, ok := users.Get("1"); ok {
+		t.Fatal("expected users to be deleted")
+	}
+	if _, ok := sessions.Get("1"); !ok {
+		t.Fatal("expected sessions to be kept")
+	}
+	if !cache.Has("usersX") {
+		t.Fatal("expected a key outside the namespace to be kept")
+	}
+
+	if !sessions.Del("1") || cache.Len() != 1 {
+		t.Fatal("expected Del in the namespace to remove the item")
+	}
+}
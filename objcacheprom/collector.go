@@ -0,0 +1,65 @@
+// Package objcacheprom exports the stats of an ObjCache as Prometheus
+// metrics.
+package objcacheprom
+
+import (
+	objcache "github.com/unbiarirang/obj-cache"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector is a prometheus.Collector for the stats of a cache. The stats
+// are read on each scrape, so it is safe to scrape while the cache is used.
+type Collector struct {
+	cache *objcache.ObjCache
+
+	hits        *prometheus.Desc
+	misses      *prometheus.Desc
+	evictions   *prometheus.Desc
+	expirations *prometheus.Desc
+	items       *prometheus.Desc
+	utilization *prometheus.Desc
+}
+
+// NewCollector returns a Collector for cache. name is set as the "cache"
+// label, so several caches can be registered together.
+func NewCollector(cache *objcache.ObjCache, name string) *Collector {
+	labels := prometheus.Labels{"cache": name}
+	desc := func(metric, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName("objcache", "", metric), help, nil, labels)
+	}
+	return &Collector{
+		cache:       cache,
+		hits:        desc("hits_total", "Number of Get calls that found an item."),
+		misses:      desc("misses_total", "Number of Get calls that found no item."),
+		evictions:   desc("evictions_total", "Number of items evicted for capacity."),
+		expirations: desc("expirations_total", "Number of expired items removed."),
+		items:       desc("items", "Number of items in the cache."),
+		utilization: desc("utilization_ratio", "Items divided by MaxEntryLimit, or 0 if unlimited."),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.evictions
+	ch <- c.expirations
+	ch <- c.items
+	ch <- c.utilization
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	s := c.cache.Stats()
+	utilization := 0.0
+	if s.Capacity > 0 {
+		utilization = float64(s.ItemCount) / float64(s.Capacity)
+	}
+	ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(s.Hits))
+	ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(s.Misses))
+	ch <- prometheus.MustNewConstMetric(c.evictions, prometheus.CounterValue, float64(s.Evictions))
+	ch <- prometheus.MustNewConstMetric(c.expirations, prometheus.CounterValue, float64(s.Expirations))
+	ch <- prometheus.MustNewConstMetric(c.items, prometheus.GaugeValue, float64(s.ItemCount))
+	ch <- prometheus.MustNewConstMetric(c.utilization, prometheus.GaugeValue, utilization)
+}
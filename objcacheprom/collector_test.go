@@ -0,0 +1,54 @@
+package objcacheprom
+
+import (
+	"strings"
+	"testing"
+
+	objcache "github.com/unbiarirang/obj-cache"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector(t *testing.T) {
+	cache, err := objcache.New(objcache.Config{MaxEntryLimit: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		cache.Set(k, 1, 0)
+	}
+	cache.Get("b")
+	cache.Get("c")
+	cache.Get("a")
+
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(NewCollector(cache, "test")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `
+# HELP objcache_evictions_total Number of items evicted for capacity.
+# TYPE objcache_evictions_total counter
+objcache_evictions_total{cache="test"} 1
+# HELP objcache_expirations_total Number of expired items removed.
+# TYPE objcache_expirations_total counter
+objcache_expirations_total{cache="test"} 0
+# HELP objcache_hits_total Number of Get calls that found an item.
+# TYPE objcache_hits_total counter
+objcache_hits_total{cache="test"} 2
+# HELP objcache_items Number of items in the cache.
+# TYPE objcache_items gauge
+objcache_items{cache="test"} 4
+# HELP objcache_misses_total Number of Get calls that found no item.
+# TYPE objcache_misses_total counter
+objcache_misses_total{cache="test"} 1
+# HELP objcache_utilization_ratio Items divided by MaxEntryLimit, or 0 if unlimited.
+# TYPE objcache_utilization_ratio gauge
+objcache_utilization_ratio{cache="test"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(want)); err != nil {
+		t.Fatal(err)
+	}
+}
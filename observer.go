@@ -0,0 +1,40 @@
+package objcache
+
+// Observer is called synchronously by the cache operations, for tracing
+// them. Unlike Events, no call is dropped. It may be called with the
+// lock held, so it must be fast and must not use the cache, and it must
+// be safe for concurrent use.
+type Observer interface {
+	// OnGet is called by Get and MGet for each key, with whether it was
+	// found.
+	OnGet(key string, hit bool)
+	// OnSet is called for each item set.
+	OnSet(key string)
+	// OnDelete is called by Del and GetAndDelete, with whether the key
+	// was deleted.
+	OnDelete(key string, existed bool)
+}
+
+// observeGet calls Config.Observer.OnGet if there is an Observer.
+func (c *ObjCache) observeGet(k string, hit bool) {
+	if c.config.Observer != nil {
+		defer c.recoverCallback()
+		c.config.Observer.OnGet(k, hit)
+	}
+}
+
+// observeSet calls Config.Observer.OnSet if there is an Observer.
+func (c *ObjCache) observeSet(k string) {
+	if c.config.Observer != nil {
+		defer c.recoverCallback()
+		c.config.Observer.OnSet(k)
+	}
+}
+
+// observeDelete calls Config.Observer.OnDelete if there is an Observer.
+func (c *ObjCache) observeDelete(k string, existed bool) {
+	if c.config.Observer != nil {
+		defer c.recoverCallback()
+		c.config.Observer.OnDelete(k, existed)
+	}
+}
@@ -0,0 +1,63 @@
+package objcache
+
+import (
+	"reflect"
+	"testing"
+)
+
+// recorder is an Observer recording its calls.
+type recorder struct {
+	calls []string
+}
+
+func (r *recorder) OnGet(key string, hit bool) {
+	if hit {
+		r.calls = append(r.calls, "get "+key+" hit")
+	} else {
+		r.calls = append(r.calls, "get "+key+" miss")
+	}
+}
+
+func (r *recorder) OnSet(key string) {
+	r.calls = append(r.calls, "set "+key)
+}
+
+func (r *recorder) OnDelete(key string, existed bool) {
+	if existed {
+		r.calls = append(r.calls, "delete "+key)
+	} else {
+		r.calls = append(r.calls, "delete "+key+" missing")
+	}
+}
+
+func TestObserver(t *testing.T) {
+	r := &recorder{}
+	cache, err := New(Config{Observer: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("a", 1, 0)
+	cache.Get("a")
+	cache.Get("b")
+	cache.MGet([]string{"a", "b"})
+	cache.Del("a")
+	cache.Del("a")
+	cache.Set("c", 1, 0)
+	cache.GetAndDelete("c")
+
+	want := []string{
+		"set a",
+		"get a hit",
+		"get b miss",
+		"get a hit",
+		"get b miss",
+		"delete a",
+		"delete a missing",
+		"set c",
+		"delete c",
+	}
+	if !reflect.DeepEqual(r.calls, want) {
+		t.Fatalf("expected %v, got %v", want, r.calls)
+	}
+}
@@ -0,0 +1,281 @@
+package objcache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// savedItem is the gob form of an item. TTL is the remaining time to live
+// when the item was saved, or NoExpiration.
+type savedItem struct {
+	Key    string
+	Object interface{}
+	TTL    time.Duration
+}
+
+// Save writes all live items to w with encoding/gob, from the least to the
+// most recently used. Concrete types stored in the cache must be
+// registered with gob.Register.
+func (c *ObjCache) Save(w io.Writer) error {
+	enc := gob.NewEncoder(w)
+	for _, item := range c.savedItems() {
+		if err := enc.Encode(&item); err != nil {
+			return fmt.Errorf("objcache: save %q: %w", item.Key, err)
+		}
+	}
+	return nil
+}
+
+// savedItems returns the live items in LRU order.
+func (c *ObjCache) savedItems() []savedItem {
+	now := c.now()
+	c.mu.RLock()
+	items := make([]savedItem, 0, c.itemCount)
+	for elem := c.list.Front(); elem != nil; elem = elem.Next() {
+		v := elem.Value.(*pair)
+		if v.expire <= now {
+			continue
+		}
+		ttl := NoExpiration
+		if v.expire != neverExpire {
+			ttl = time.Duration(v.expire - now)
+		}
+		items = append(items, savedItem{
+			Key:    v.key,
+			Object: v.Object,
+			TTL:    ttl,
+		})
+	}
+	c.mu.RUnlock()
+	return items
+}
+
+// Load reads items written by Save from r and merges them into the cache.
+// Existing keys are overwritten, other items are kept. Items keep the
+// remaining TTL they had when saved.
+func (c *ObjCache) Load(r io.Reader) error {
+	_, err := c.LoadStream(r, FormatGob)
+	return err
+}
+
+// Format is the encoding of a stream read by LoadStream.
+type Format int
+
+const (
+	// FormatGob is the encoding of Save.
+	FormatGob Format = iota
+	// FormatJSON is the encoding of ExportJSON.
+	FormatJSON
+)
+
+// LoadStream reads items from r one at a time and sets them like Set, so
+// a large stream is never held in memory and evicts as it is loaded.
+// Expired items and items Set fails for are skipped. The items are
+// neither written to Config.Store nor published to Config.Invalidator.
+// It returns the number of items set. If r cannot be decoded to the end,
+// the items set so far stay and the decode error is returned with their
+// number.
+func (c *ObjCache) LoadStream(r io.Reader, format Format) (int, error) {
+	switch format {
+	case FormatGob:
+		return c.loadGob(r)
+	case FormatJSON:
+		return c.loadJSON(r)
+	}
+	return 0, fmt.Errorf("objcache: load: unknown format %d", format)
+}
+
+// loadGob is LoadStream for FormatGob.
+func (c *ObjCache) loadGob(r io.Reader) (int, error) {
+	dec := gob.NewDecoder(r)
+	n := 0
+	for {
+		var item savedItem
+		if err := dec.Decode(&item); err != nil {
+			if errors.Is(err, io.EOF) {
+				return n, nil
+			}
+			return n, fmt.Errorf("objcache: load: %w", err)
+		}
+		if item.TTL == 0 {
+			continue
+		}
+		c.mu.Lock()
+		_, err := c.setLocal(item.Key, item.Object, c.sizeOf(item.Object), c.expireAt(item.TTL), item.TTL)
+		c.unlock()
+		if err == nil {
+			n = n + 1
+		}
+	}
+}
+
+// loadJSON is LoadStream for FormatJSON. It reads the object of
+// ExportJSON one member at a time.
+func (c *ObjCache) loadJSON(r io.Reader) (int, error) {
+	dec := json.NewDecoder(r)
+	n := 0
+	t, err := dec.Token()
+	if err != nil {
+		return 0, fmt.Errorf("objcache: load: %w", err)
+	}
+	if t != json.Delim('{') {
+		return 0, errors.New("objcache: load: expected a JSON object")
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return n, fmt.Errorf("objcache: load: %w", err)
+		}
+		k := t.(string)
+		var item struct {
+			Value  interface{} `json:"value"`
+			Expire int64       `json:"expireUnixNano"`
+		}
+		if err := dec.Decode(&item); err != nil {
+			return n, fmt.Errorf("objcache: load %q: %w", k, err)
+		}
+		c.mu.Lock()
+		now := c.now()
+		if item.Expire == 0 {
+			_, err = c.setLocal(k, item.Value, c.sizeOf(item.Value), neverExpire, NoExpiration)
+		} else if item.Expire > now {
+			_, err = c.setLocal(k, item.Value, c.sizeOf(item.Value), item.Expire, time.Duration(item.Expire-now))
+		} else {
+			err = ErrPastDeadline
+		}
+		c.unlock()
+		if err == nil {
+			n = n + 1
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return n, fmt.Errorf("objcache: load: %w", err)
+	}
+	return n, nil
+}
+
+// MarshalBinary encodes the live items with their remaining TTLs as Save
+// does, for encoding.BinaryMarshaler. The Config is not encoded.
+func (c *ObjCache) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := c.Save(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary replaces the items of the cache with the items of data
+// written by MarshalBinary, for encoding.BinaryUnmarshaler. The Config of
+// the cache is kept, so it must be made by New. If data cannot be decoded,
+// the cache is not changed. Items Set would reject, like keys longer than
+// Config.MaxKeyLen, are skipped. The items are not written to
+// Config.Store.
+func (c *ObjCache) UnmarshalBinary(data []byte) error {
+	if c.items == nil {
+		return errors.New("objcache: unmarshal: cache not made by New")
+	}
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	var items []savedItem
+	for {
+		var item savedItem
+		if err := dec.Decode(&item); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return fmt.Errorf("objcache: unmarshal: %w", err)
+		}
+		items = append(items, item)
+	}
+
+	c.mu.Lock()
+	if err := c.writable(); err != nil {
+		c.unlock()
+		return err
+	}
+	c.clear()
+	for _, item := range items {
+		if item.TTL != 0 {
+			c.setLocal(item.Key, item.Object, c.sizeOf(item.Object), c.expireAt(item.TTL), item.TTL)
+		}
+	}
+	c.unlock()
+	return nil
+}
+
+// jsonItem is the JSON form of an item. Expire is the expiration time in
+// Unix nanoseconds, or 0 for an item that never expires.
+type jsonItem struct {
+	Value  json.RawMessage `json:"value"`
+	Expire int64           `json:"expireUnixNano"`
+}
+
+// ExportJSON returns all live items as a JSON object mapping each key to
+// its value and expiration time. The keys are sorted, so the output only
+// depends on the items. It fails with the key of the first value that
+// cannot be marshaled.
+func (c *ObjCache) ExportJSON() ([]byte, error) {
+	now := c.now()
+	c.mu.RLock()
+	items := make(map[string]jsonItem, c.itemCount)
+	for elem := c.list.Front(); elem != nil; elem = elem.Next() {
+		v := elem.Value.(*pair)
+		if v.expire < now {
+			continue
+		}
+		value, err := json.Marshal(v.Object)
+		if err != nil {
+			c.mu.RUnlock()
+			return nil, fmt.Errorf("objcache: export %q: %w", v.key, err)
+		}
+		expire := v.expire
+		if expire == neverExpire {
+			expire = 0
+		}
+		items[v.key] = jsonItem{Value: value, Expire: expire}
+	}
+	c.mu.RUnlock()
+	return json.Marshal(items)
+}
+
+// ImportJSON sets the items of data written by ExportJSON, keeping their
+// expiration times. Items that have expired are skipped. Values are
+// decoded as by json.Unmarshal into an interface{}, so structs come back
+// as map[string]interface{}. Items Set would reject, like keys longer
+// than Config.MaxKeyLen, are skipped.
+func (c *ObjCache) ImportJSON(data []byte) error {
+	var items map[string]jsonItem
+	if err := json.Unmarshal(data, &items); err != nil {
+		return fmt.Errorf("objcache: import: %w", err)
+	}
+	values := make(map[string]interface{}, len(items))
+	for k, item := range items {
+		var value interface{}
+		if err := json.Unmarshal(item.Value, &value); err != nil {
+			return fmt.Errorf("objcache: import %q: %w", k, err)
+		}
+		values[k] = value
+	}
+
+	c.mu.Lock()
+	if err := c.writable(); err != nil {
+		c.unlock()
+		return err
+	}
+	now := c.now()
+	for k, item := range items {
+		expire, ttl := item.Expire, time.Duration(item.Expire-now)
+		if expire == 0 {
+			expire, ttl = neverExpire, NoExpiration
+		} else if expire < now {
+			continue
+		}
+		c.setLocal(k, values[k], c.sizeOf(values[k]), expire, ttl)
+	}
+	c.unlock()
+	return nil
+}
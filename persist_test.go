@@ -0,0 +1,319 @@
+package objcache
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/gob"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type savedUser struct {
+	Name string
+	Age  int
+}
+
+func init() {
+	gob.Register(savedUser{})
+}
+
+func TestSaveLoad(t *testing.T) {
+	config := Config{
+		MaxEntryLimit: 10,
+		Expiration:    5 * time.Minute,
+	}
+	cache, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("user", savedUser{Name: "a", Age: 1}, time.Minute)
+	cache.Set("n", 42, 0)
+	cache.Set("expiring", "x", 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	var buf bytes.Buffer
+	if err := cache.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Load(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, ok := loaded.Get("user"); !ok || v != (savedUser{Name: "a", Age: 1}) {
+		t.Fatalf("expected restored user, got %v, %v", v, ok)
+	}
+	if v, ok := loaded.Get("n"); !ok || v != 42 {
+		t.Fatalf("expected restored int, got %v, %v", v, ok)
+	}
+	if loaded.Has("expiring") {
+		t.Fatal("expected expired item to be skipped")
+	}
+	remaining := time.Duration(loaded.items["user"].Value.(*pair).expire - time.Now().UnixNano())
+	if remaining > time.Minute || remaining < 50*time.Second {
+		t.Fatalf("expected remaining TTL near a minute, got %v", remaining)
+	}
+}
+
+func TestSaveUnencodable(t *testing.T) {
+	cache, err := New(Config{
+		MaxEntryLimit: 10,
+		Expiration:    5 * time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("fn", func() {}, 0)
+	if err := cache.Save(&bytes.Buffer{}); err == nil {
+		t.Fatal("expected an error for an unencodable object")
+	}
+}
+
+func TestMarshalBinary(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("user", savedUser{Name: "a", Age: 1}, time.Minute)
+	cache.Set("n", 42, NoExpiration)
+	cache.Set("expiring", "x", time.Second)
+	clock.Advance(2 * time.Second)
+
+	data, err := cache.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var _ encoding.BinaryMarshaler = cache
+	var _ encoding.BinaryUnmarshaler = cache
+	loaded, err := New(Config{Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded.Set("old", 1, 0)
+	if err := loaded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if keys := loaded.Keys(); !reflect.DeepEqual(keys, []string{"user", "n"}) {
+		t.Fatalf("expected user and n, got %v", keys)
+	}
+	if v, ok := loaded.Get("user"); !ok || v != (savedUser{Name: "a", Age: 1}) {
+		t.Fatalf("expected restored user, got %v, %v", v, ok)
+	}
+	if ttl := loaded.TTL("user"); ttl != 58*time.Second {
+		t.Fatalf("expected the remaining TTL, got %v", ttl)
+	}
+	if ttl := loaded.TTL("n"); ttl != NoExpiration {
+		t.Fatalf("expected no expiration, got %v", ttl)
+	}
+
+	if err := loaded.UnmarshalBinary([]byte("garbage")); err == nil {
+		t.Fatal("expected an error for bad data")
+	}
+	if loaded.Len() != 2 {
+		t.Fatal("expected bad data not to change the cache")
+	}
+
+	cache.Set("fn", func() {}, 0)
+	if _, err := cache.MarshalBinary(); err == nil {
+		t.Fatal("expected an error for an unencodable object")
+	}
+}
+
+func TestPersistMaxKeyLen(t *testing.T) {
+	src, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.Set("abc", 1, 0)
+	src.Set("abcdefghij", 2, 0)
+	data, err := src.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported, err := src.ExportJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := New(Config{MaxKeyLen: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if keys := cache.Keys(); !reflect.DeepEqual(keys, []string{"abc"}) {
+		t.Fatalf("expected the long key to be skipped by UnmarshalBinary, got %v", keys)
+	}
+	cache.Flush()
+	if err := cache.ImportJSON(exported); err != nil {
+		t.Fatal(err)
+	}
+	if keys := cache.Keys(); !reflect.DeepEqual(keys, []string{"abc"}) {
+		t.Fatalf("expected the long key to be skipped by ImportJSON, got %v", keys)
+	}
+}
+
+type jsonUser struct {
+	Name    string            `json:"name"`
+	Tags    []string          `json:"tags"`
+	Account map[string]string `json:"account"`
+}
+
+func TestExportImportJSON(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("user", jsonUser{Name: "a", Tags: []string{"x"}, Account: map[string]string{"id": "1"}}, time.Minute)
+	cache.Set("forever", 1, NoExpiration)
+	cache.Set("expiring", 2, time.Second)
+	clock.Advance(2 * time.Second)
+
+	data, err := cache.ExportJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("expiring")) {
+		t.Fatalf("expected the expired item to be skipped, got %s", data)
+	}
+
+	imported, err := New(Config{Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := imported.ImportJSON(data); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{
+		"name":    "a",
+		"tags":    []interface{}{"x"},
+		"account": map[string]interface{}{"id": "1"},
+	}
+	if v, _ := imported.Get("user"); !reflect.DeepEqual(v, want) {
+		t.Fatalf("expected %v, got %v", want, v)
+	}
+	_, want1, _ := cache.GetWithExpiration("user")
+	if _, got, _ := imported.GetWithExpiration("user"); !got.Equal(want1) {
+		t.Fatalf("expected expiration %v, got %v", want1, got)
+	}
+	if _, expire, ok := imported.GetWithExpiration("forever"); !ok || !expire.IsZero() {
+		t.Fatal("expected the item without expiration to be imported")
+	}
+	if imported.Len() != 2 {
+		t.Fatalf("expected 2 items, got %d", imported.Len())
+	}
+}
+
+func TestExportJSONStable(t *testing.T) {
+	clock := newFakeClock()
+	export := func(keys ...string) string {
+		cache, err := New(Config{Clock: clock})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range keys {
+			cache.Set(k, k, NoExpiration)
+		}
+		data, err := cache.ExportJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	want := `{"a":{"value":"a","expireUnixNano":0},"b":{"value":"b","expireUnixNano":0},"c":{"value":"c","expireUnixNano":0}}`
+	if got := export("c", "a", "b"); got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+	if got := export("b", "c", "a"); got != want {
+		t.Fatalf("expected the output not to depend on the order, got %s", got)
+	}
+}
+
+func TestExportJSONError(t *testing.T) {
+	cache, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("bad", make(chan int), 0)
+	if _, err := cache.ExportJSON(); err == nil || !strings.Contains(err.Error(), `"bad"`) {
+		t.Fatalf("expected an error naming the key, got %v", err)
+	}
+}
+
+func TestLoadStream(t *testing.T) {
+	src, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5000; i = i + 1 {
+		src.Set(strconv.Itoa(i), i, time.Hour)
+	}
+	var buf bytes.Buffer
+	if err := src.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data, err := src.ExportJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []Format{FormatGob, FormatJSON} {
+		in := bytes.NewReader(buf.Bytes())
+		if format == FormatJSON {
+			in = bytes.NewReader(data)
+		}
+		dst, err := New(Config{MaxEntryLimit: 1000})
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := dst.LoadStream(in, format)
+		if err != nil || n != 5000 {
+			t.Fatalf("format %d: expected 5000 items loaded, got %d, %v", format, n, err)
+		}
+		if l := dst.Len(); l != 1000 {
+			t.Fatalf("format %d: expected the limit to evict down to 1000, got %d", format, l)
+		}
+	}
+}
+
+func TestLoadStreamTruncated(t *testing.T) {
+	src, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i = i + 1 {
+		src.Set(strconv.Itoa(i), i, time.Hour)
+	}
+	var buf bytes.Buffer
+	src.Save(&buf)
+	data, _ := src.ExportJSON()
+
+	for format, b := range map[Format][]byte{FormatGob: buf.Bytes(), FormatJSON: data} {
+		dst, _ := New(Config{})
+		n, err := dst.LoadStream(bytes.NewReader(b[:len(b)/2]), format)
+		if err == nil {
+			t.Fatalf("format %d: expected an error for a truncated stream", format)
+		}
+		if n == 0 || n >= 100 || dst.Len() != n {
+			t.Fatalf("format %d: expected a partial load, got %d items and %d loaded", format, dst.Len(), n)
+		}
+	}
+}
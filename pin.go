@@ -0,0 +1,55 @@
+package objcache
+
+import "container/heap"
+
+// Pin exempts the item of key from expiration and eviction until Unpin
+// or Del. A pinned item still counts in Len, but not against
+// Config.MaxEntryLimit, and its TTL is NoExpiration. It returns false if
+// the key is not in the cache or has expired, or while the cache is
+// read-only.
+func (c *ObjCache) Pin(k string) bool {
+	c.mu.Lock()
+	elem, ok := c.items[k]
+	if !ok || c.writable() != nil || c.expired(elem.Value.(*pair)) {
+		c.unlock()
+		return false
+	}
+	p := elem.Value.(*pair)
+	if !p.pinned {
+		p.pinned = true
+		c.pinned = c.pinned + 1
+		p.expire = neverExpire
+		heap.Fix(&c.heap, p.index)
+	}
+	c.unlock()
+	return true
+}
+
+// Unpin makes the pinned item of key expire and be evicted again. Its
+// expiration is set from now with the duration it was last set or
+// touched for, or Config.Expiration if that was 0, and other items are
+// evicted if it no longer fits. It returns false if the key is not in
+// the cache or not pinned, or while the cache is read-only.
+func (c *ObjCache) Unpin(k string) bool {
+	c.mu.Lock()
+	elem, ok := c.items[k]
+	if !ok || c.writable() != nil || !elem.Value.(*pair).pinned {
+		c.unlock()
+		return false
+	}
+	p := elem.Value.(*pair)
+	p.pinned = false
+	c.pinned = c.pinned - 1
+	p.expire = c.expireAt(p.ttl)
+	heap.Fix(&c.heap, p.index)
+	for c.overLimit() && c.removeOldest(elem) {
+	}
+	c.unlock()
+	return true
+}
+
+// counted returns the number of items counted against
+// Config.MaxEntryLimit, which are all but the pinned ones.
+func (c *ObjCache) counted() int {
+	return c.itemCount - c.pinned
+}
@@ -0,0 +1,109 @@
+package objcache
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPin(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{MaxEntryLimit: 3, Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.Pin("flag") {
+		t.Fatal("expected Pin of a missing key to return false")
+	}
+	cache.Set("flag", true, time.Second)
+	if !cache.Pin("flag") {
+		t.Fatal("expected Pin to return true")
+	}
+	if ttl := cache.TTL("flag"); ttl != NoExpiration {
+		t.Fatalf("expected the TTL of a pinned item to be NoExpiration, got %v", ttl)
+	}
+
+	// Past its TTL.
+	clock.Advance(time.Minute)
+	cache.DeleteExpired()
+	if _, ok := cache.Get("flag"); !ok {
+		t.Fatal("expected the pinned item to survive its TTL")
+	}
+
+	// Over capacity. The pinned item does not count against the limit.
+	for i := 0; i < 10; i = i + 1 {
+		cache.Set(strconv.Itoa(i), i, 0)
+	}
+	if _, ok := cache.Get("flag"); !ok {
+		t.Fatal("expected the pinned item to survive eviction")
+	}
+	if n := cache.Len(); n != 4 {
+		t.Fatalf("expected 3 items and the pinned one, got %d", n)
+	}
+	for i := 7; i < 10; i = i + 1 {
+		if _, ok := cache.Get(strconv.Itoa(i)); !ok {
+			t.Fatalf("expected %d to be kept", i)
+		}
+	}
+
+	// A Set keeps the item pinned.
+	cache.Set("flag", false, time.Second)
+	clock.Advance(time.Minute)
+	if x, ok := cache.Get("flag"); !ok || x != false {
+		t.Fatalf("expected the pinned item to be set, got %v, %v", x, ok)
+	}
+	if err := cache.CheckConsistency(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnpin(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{MaxEntryLimit: 2, Clock: clock, Expiration: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.Unpin("a") {
+		t.Fatal("expected Unpin of a missing key to return false")
+	}
+	cache.Set("a", 1, time.Second)
+	cache.Set("b", 2, 0)
+	if cache.Unpin("a") {
+		t.Fatal("expected Unpin of an item not pinned to return false")
+	}
+	cache.Pin("a")
+	cache.Pin("b")
+	cache.Set("c", 3, 0)
+	cache.Set("d", 4, 0)
+	clock.Advance(time.Minute)
+
+	// a gets its TTL again from now and d is evicted to make room.
+	if !cache.Unpin("a") {
+		t.Fatal("expected Unpin to return true")
+	}
+	if ttl := cache.TTL("a"); ttl != time.Second {
+		t.Fatalf("expected the TTL of a to be 1s again, got %v", ttl)
+	}
+	if _, ok := cache.Get("c"); ok {
+		t.Fatal("expected c to be evicted when a was unpinned")
+	}
+	if _, ok := cache.Get("d"); !ok {
+		t.Fatal("expected d to be kept")
+	}
+	clock.Advance(2 * time.Second)
+	if _, ok := cache.Get("a"); ok {
+		t.Fatal("expected a to expire after Unpin")
+	}
+
+	// b was set with the default expiration.
+	cache.Unpin("b")
+	if ttl := cache.TTL("b"); ttl != time.Hour {
+		t.Fatalf("expected the TTL of b to be the default, got %v", ttl)
+	}
+
+	cache.Pin("d")
+	cache.Del("d")
+	if err := cache.CheckConsistency(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,38 @@
+package objcache
+
+// Panics of user callbacks are recovered, so a buggy callback can neither
+// crash a background goroutine nor leave the lock held. They are reported
+// to Config.OnCallbackPanic.
+
+// recoverCallback recovers a panic and reports it. It must be deferred
+// directly.
+func (c *ObjCache) recoverCallback() {
+	if r := recover(); r != nil {
+		c.panicked(r)
+	}
+}
+
+// panicked reports the recovered panic r to Config.OnCallbackPanic.
+func (c *ObjCache) panicked(r interface{}) {
+	if c.config.OnCallbackPanic != nil {
+		c.config.OnCallbackPanic(r)
+	}
+}
+
+// guard calls fn, recovering a panic.
+func (c *ObjCache) guard(fn func()) {
+	defer c.recoverCallback()
+	fn()
+}
+
+// call calls the loader fn. A panic is recovered and returned as
+// ErrCallbackPanic.
+func (c *ObjCache) call(fn func() (interface{}, error)) (x interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.panicked(r)
+			x, err = nil, ErrCallbackPanic
+		}
+	}()
+	return fn()
+}
@@ -0,0 +1,115 @@
+package objcache
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// panics records the panics reported to OnCallbackPanic.
+type panics struct {
+	mu     sync.Mutex
+	values []interface{}
+}
+
+func (p *panics) report(r interface{}) {
+	p.mu.Lock()
+	p.values = append(p.values, r)
+	p.mu.Unlock()
+}
+
+func (p *panics) len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.values)
+}
+
+func TestPanickingOnEvicted(t *testing.T) {
+	p := &panics{}
+	cache, err := New(Config{
+		MaxEntryLimit:   1,
+		OnEvicted:       func(k string, v interface{}, r EvictReason) { panic("evicted " + k) },
+		OnCallbackPanic: p.report,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("a", 1, 0)
+	cache.Set("b", 2, 0)
+	cache.Del("b")
+	if p.len() != 2 || p.values[0] != "evicted a" {
+		t.Fatalf("expected the panics to be reported, got %v", p.values)
+	}
+
+	// The lock was released, so the cache is still usable.
+	if err := cache.Set("c", 3, 0); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := cache.Get("c"); !ok || v != 3 {
+		t.Fatalf("expected 3, got %v, %v", v, ok)
+	}
+}
+
+type panickingObserver struct{}
+
+func (panickingObserver) OnGet(key string, hit bool)        { panic("get") }
+func (panickingObserver) OnSet(key string)                  { panic("set") }
+func (panickingObserver) OnDelete(key string, existed bool) { panic("delete") }
+
+func TestPanickingCallbacksUnderLock(t *testing.T) {
+	p := &panics{}
+	cache, err := New(Config{
+		Observer:        panickingObserver{},
+		Sizer:           func(v interface{}) int64 { panic("size") },
+		OnCallbackPanic: p.report,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("a", 1, time.Minute)
+	if v, ok := cache.Get("a"); !ok || v != 1 {
+		t.Fatalf("expected 1, got %v, %v", v, ok)
+	}
+	if !cache.Del("a") {
+		t.Fatal("expected a to be deleted")
+	}
+	if ok := cache.Update("b", func(old interface{}, found bool) (interface{}, bool) {
+		panic("update")
+	}); ok || cache.Has("b") {
+		t.Fatal("expected a panicking Update to change nothing")
+	}
+	cache.Set("c", 1, time.Minute)
+	cache.Range(func(k string, v interface{}) bool { panic("range") })
+	if n := cache.DeleteFunc(func(k string, v interface{}) bool { panic("pred") }); n != 0 {
+		t.Fatalf("expected nothing deleted, got %d", n)
+	}
+	if cache.Len() != 1 {
+		t.Fatalf("expected 1 item, got %d", cache.Len())
+	}
+	if p.len() == 0 {
+		t.Fatal("expected the panics to be reported")
+	}
+}
+
+func TestPanickingLoader(t *testing.T) {
+	p := &panics{}
+	cache, err := New(Config{OnCallbackPanic: p.report})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cache.GetWithLoader("a", time.Minute, func() (interface{}, error) {
+		panic("load")
+	})
+	if err != ErrCallbackPanic {
+		t.Fatalf("expected ErrCallbackPanic, got %v", err)
+	}
+	if p.len() != 1 || p.values[0] != "load" {
+		t.Fatalf("expected the panic to be reported, got %v", p.values)
+	}
+	v, err := cache.GetWithLoader("a", time.Minute, func() (interface{}, error) {
+		return 1, nil
+	})
+	if err != nil || v != 1 {
+		t.Fatalf("expected a later load to work, got %v, %v", v, err)
+	}
+}
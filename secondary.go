@@ -0,0 +1,45 @@
+package objcache
+
+// GetBySecondary returns the object of the key whose object has the
+// secondary key sk given by Config.IndexFunc, like Get. If several
+// objects have sk, the one set last is returned.
+func (c *ObjCache) GetBySecondary(sk string) (interface{}, bool) {
+	c.mu.RLock()
+	k, ok := c.secondary[sk]
+	c.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return c.Get(k)
+}
+
+// index adds the secondary key of p to the index if there is an
+// IndexFunc. The caller must hold the write lock.
+func (c *ObjCache) index(p *pair) {
+	if c.config.IndexFunc == nil {
+		return
+	}
+	var sk string
+	ok := false
+	c.guard(func() { sk, ok = c.config.IndexFunc(p.Object) })
+	if !ok {
+		return
+	}
+	if c.secondary == nil {
+		c.secondary = make(map[string]string)
+	}
+	c.secondary[sk] = p.key
+	p.skey, p.indexed = sk, true
+}
+
+// unindex removes the secondary key of p from the index, unless it
+// already points to another key. The caller must hold the write lock.
+func (c *ObjCache) unindex(p *pair) {
+	if !p.indexed {
+		return
+	}
+	if c.secondary[p.skey] == p.key {
+		delete(c.secondary, p.skey)
+	}
+	p.skey, p.indexed = "", false
+}
@@ -0,0 +1,78 @@
+package objcache
+
+import (
+	"testing"
+	"time"
+)
+
+type user struct {
+	ID    string
+	Email string
+}
+
+func emailIndex(v interface{}) (string, bool) {
+	u, ok := v.(user)
+	if !ok || u.Email == "" {
+		return "", false
+	}
+	return u.Email, true
+}
+
+func TestGetBySecondary(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{Clock: clock, IndexFunc: emailIndex})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("1", user{ID: "1", Email: "a@example.com"}, time.Minute)
+	cache.Set("2", user{ID: "2", Email: "b@example.com"}, time.Second)
+	cache.Set("3", "not a user", time.Minute)
+
+	if v, ok := cache.GetBySecondary("a@example.com"); !ok || v.(user).ID != "1" {
+		t.Fatalf("expected user 1, got %v, %v", v, ok)
+	}
+	if _, ok := cache.GetBySecondary("missing"); ok {
+		t.Fatal("expected a miss")
+	}
+
+	cache.Set("1", user{ID: "1", Email: "c@example.com"}, time.Minute)
+	if _, ok := cache.GetBySecondary("a@example.com"); ok {
+		t.Fatal("expected the old secondary key to be dropped on overwrite")
+	}
+	if v, ok := cache.GetBySecondary("c@example.com"); !ok || v.(user).ID != "1" {
+		t.Fatalf("expected user 1 by the new secondary key, got %v, %v", v, ok)
+	}
+
+	cache.Del("1")
+	if _, ok := cache.GetBySecondary("c@example.com"); ok {
+		t.Fatal("expected the secondary key to be dropped on Del")
+	}
+
+	clock.Advance(2 * time.Second)
+	cache.DeleteExpired()
+	if _, ok := cache.GetBySecondary("b@example.com"); ok {
+		t.Fatal("expected the secondary key to be dropped on expiry")
+	}
+	if len(cache.secondary) != 0 {
+		t.Fatalf("expected an empty index, got %v", cache.secondary)
+	}
+}
+
+func TestSecondaryIndexEviction(t *testing.T) {
+	cache, err := New(Config{MaxEntryLimit: 1, IndexFunc: emailIndex})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("1", user{ID: "1", Email: "a@example.com"}, 0)
+	cache.Set("2", user{ID: "2", Email: "a@example.com"}, 0)
+	if v, ok := cache.GetBySecondary("a@example.com"); !ok || v.(user).ID != "2" {
+		t.Fatalf("expected the last user set, got %v, %v", v, ok)
+	}
+	cache.Set("3", user{ID: "3", Email: "b@example.com"}, 0)
+	if _, ok := cache.GetBySecondary("a@example.com"); ok {
+		t.Fatal("expected the secondary key to be dropped on eviction")
+	}
+	if len(cache.secondary) != 1 {
+		t.Fatalf("expected one secondary key, got %v", cache.secondary)
+	}
+}
@@ -0,0 +1,147 @@
+package objcache
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"time"
+)
+
+// DefaultShards is the number of shards used when Config.Shards is 0.
+const DefaultShards = 16
+
+// ShardedCache spreads keys over several ObjCache shards by the hash of
+// the key, Config.Hasher or FNV-1a. Each shard has its own lock, so
+// operations on keys of different shards do not contend.
+type ShardedCache struct {
+	shards []*ObjCache
+	hash   func(string) uint64
+}
+
+// NewSharded makes a sharded cache with config.Shards shards and returns
+// it. config.MaxEntryLimit and config.InitialCapacity are divided evenly
+// between the shards, and each entry of config.Warm is set in its shard.
+// A *rand.Rand is not safe for concurrent use, so each shard gets its own
+// source seeded from config.Rand.
+// config.Invalidator and config.SourceRefresh are not supported.
+func NewSharded(config Config) (*ShardedCache, error) {
+	if config.Invalidator != nil {
+		return nil, fmt.Errorf("%w: Invalidator with ShardedCache", ErrInvalidConfig)
+	}
+	if config.SourceRefresh.Fetch != nil {
+		return nil, fmt.Errorf("%w: SourceRefresh with ShardedCache", ErrInvalidConfig)
+	}
+	n := config.Shards
+	if n <= 0 {
+		n = DefaultShards
+	}
+
+	shardConfig := config
+	shardConfig.MaxEntryLimit = (config.MaxEntryLimit + n - 1) / n
+	shardConfig.InitialCapacity = (config.InitialCapacity + n - 1) / n
+	shardConfig.Warm = nil
+
+	c := &ShardedCache{
+		shards: make([]*ObjCache, n),
+		hash:   config.Hasher,
+	}
+	if c.hash == nil {
+		c.hash = fnvHash
+	}
+	for i := range c.shards {
+		if config.Rand != nil {
+			shardConfig.Rand = rand.New(rand.NewSource(config.Rand.Int63()))
+		}
+		shard, err := New(shardConfig)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.shards[i] = shard
+	}
+	warm := make(map[*ObjCache][]WarmEntry)
+	for _, e := range config.Warm {
+		shard := c.shard(e.Key)
+		warm[shard] = append(warm[shard], e)
+	}
+	for shard, entries := range warm {
+		shard.Warm(entries)
+	}
+	return c, nil
+}
+
+// fnvHash is the FNV-1a hash of k.
+func fnvHash(k string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(k))
+	return h.Sum64()
+}
+
+func (c *ShardedCache) shard(k string) *ObjCache {
+	return c.shards[c.hash(k)%uint64(len(c.shards))]
+}
+
+// Set a value for key. See ObjCache.Set.
+func (c *ShardedCache) Set(k string, x interface{}, d time.Duration) error {
+	return c.shard(k).Set(k, x, d)
+}
+
+// Get the object of key.
+func (c *ShardedCache) Get(k string) (interface{}, bool) {
+	return c.shard(k).Get(k)
+}
+
+// Del delete an item for some key.
+func (c *ShardedCache) Del(k string) bool {
+	return c.shard(k).Del(k)
+}
+
+// Range calls fn for each live item like ObjCache.Range, one shard after
+// the other, until fn returns false. Only the shard being iterated is
+// locked, so the view is weakly consistent: items set or deleted in other
+// shards during the iteration may or may not be visited.
+func (c *ShardedCache) Range(fn func(key string, value interface{}) bool) {
+	next := true
+	for _, shard := range c.shards {
+		shard.Range(func(k string, v interface{}) bool {
+			next = fn(k, v)
+			return next
+		})
+		if !next {
+			return
+		}
+	}
+}
+
+// Len returns the number of items in all shards.
+func (c *ShardedCache) Len() int {
+	n := 0
+	for _, shard := range c.shards {
+		n = n + shard.Len()
+	}
+	return n
+}
+
+// ShardLens returns the number of items in each shard, to tell whether
+// the keys are spread evenly.
+func (c *ShardedCache) ShardLens() []int {
+	lens := make([]int, len(c.shards))
+	for i, shard := range c.shards {
+		lens[i] = shard.Len()
+	}
+	return lens
+}
+
+// Close closes all shards and returns the first error.
+func (c *ShardedCache) Close() error {
+	var err error
+	for _, shard := range c.shards {
+		if shard == nil {
+			continue
+		}
+		if serr := shard.Close(); err == nil {
+			err = serr
+		}
+	}
+	return err
+}
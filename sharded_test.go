@@ -0,0 +1,198 @@
+package objcache
+
+import (
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShardedCache(t *testing.T) {
+	cache, err := NewSharded(Config{
+		MaxEntryLimit: 1000,
+		Expiration:    5 * time.Minute,
+		Shards:        8,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	for i := 0; i < 100; i = i + 1 {
+		cache.Set(strconv.Itoa(i), i, 0)
+	}
+	if n := cache.Len(); n != 100 {
+		t.Fatalf("expected 100 items, got %d", n)
+	}
+
+	used := 0
+	for _, shard := range cache.shards {
+		if shard.Len() > 0 {
+			used = used + 1
+		}
+	}
+	if used < 2 {
+		t.Fatalf("expected keys spread over shards, %d shards used", used)
+	}
+
+	for i := 0; i < 100; i = i + 1 {
+		if v, ok := cache.Get(strconv.Itoa(i)); !ok || v != i {
+			t.Fatalf("expected %d, got %v, %v", i, v, ok)
+		}
+	}
+	if !cache.Del("1") || cache.Len() != 99 {
+		t.Fatal("expected Del to remove one item")
+	}
+}
+
+func TestShardedHasher(t *testing.T) {
+	cache, err := NewSharded(Config{
+		Shards: 4,
+		Hasher: func(key string) uint64 {
+			n, _ := strconv.Atoi(key)
+			return uint64(n)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	for _, k := range []string{"0", "1", "5", "9", "13", "3"} {
+		cache.Set(k, k, 0)
+	}
+	lens := cache.ShardLens()
+	want := []int{1, 4, 0, 1}
+	for i := range want {
+		if lens[i] != want[i] {
+			t.Fatalf("expected shard lengths %v, got %v", want, lens)
+		}
+	}
+	if v, ok := cache.shards[1].Get("13"); !ok || v != "13" {
+		t.Fatal("expected key 13 in shard 1")
+	}
+}
+
+func TestShardedWarm(t *testing.T) {
+	cache, err := NewSharded(Config{
+		Shards: 4,
+		Warm:   []WarmEntry{{Key: "a", Value: 1}, {Key: "b", Value: 2}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	if n := cache.Len(); n != 2 {
+		t.Fatalf("expected 2 items, got %d", n)
+	}
+	if x, ok := cache.Get("b"); !ok || x != 2 {
+		t.Fatalf("expected 2 for b, got %v, %v", x, ok)
+	}
+}
+
+func TestShardedRand(t *testing.T) {
+	cache, err := NewSharded(Config{
+		Shards:           4,
+		ExpirationJitter: time.Second,
+		Rand:             rand.New(rand.NewSource(1)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	// Run with -race: the shards must not share the source.
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w = w + 1 {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i = i + 1 {
+				cache.Set(strconv.Itoa(w*100+i), i, time.Minute)
+			}
+		}(w)
+	}
+	wg.Wait()
+	if n := cache.Len(); n != 400 {
+		t.Fatalf("expected 400 items, got %d", n)
+	}
+}
+
+func TestShardedRange(t *testing.T) {
+	cache, err := NewSharded(Config{
+		Shards: 2,
+		Hasher: func(key string) uint64 {
+			n, _ := strconv.Atoi(key)
+			return uint64(n)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	for i := 0; i < 10; i = i + 1 {
+		cache.Set(strconv.Itoa(i), i, 0)
+	}
+
+	seen := make(map[string]bool)
+	cache.Range(func(k string, v interface{}) bool {
+		if len(seen) == 0 {
+			// Shard 0 is iterated first, so shard 1 takes writes.
+			done := make(chan struct{})
+			go func() {
+				cache.Set("11", 11, 0)
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("expected a write to another shard not to block")
+			}
+		}
+		seen[k] = true
+		return true
+	})
+	for i := 0; i < 10; i = i + 1 {
+		if !seen[strconv.Itoa(i)] {
+			t.Fatalf("expected key %d to be visited, got %v", i, seen)
+		}
+	}
+
+	n := 0
+	cache.Range(func(k string, v interface{}) bool {
+		n = n + 1
+		return n < 3
+	})
+	if n != 3 {
+		t.Fatalf("expected Range to stop after 3 items, got %d", n)
+	}
+}
+
+func benchmarkParallel(b *testing.B, set func(string, interface{}), get func(string)) {
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			k := strconv.Itoa(i % 1000)
+			if i%10 == 0 {
+				set(k, i)
+			} else {
+				get(k)
+			}
+			i = i + 1
+		}
+	})
+}
+
+func BenchmarkSingleLock(b *testing.B) {
+	cache, _ := New(Config{MaxEntryLimit: 10000, Expiration: 5 * time.Minute})
+	benchmarkParallel(b,
+		func(k string, x interface{}) { cache.Set(k, x, 0) },
+		func(k string) { cache.Get(k) })
+}
+
+func BenchmarkSharded(b *testing.B) {
+	cache, _ := NewSharded(Config{MaxEntryLimit: 10000, Expiration: 5 * time.Minute, Shards: 16})
+	benchmarkParallel(b,
+		func(k string, x interface{}) { cache.Set(k, x, 0) },
+		func(k string) { cache.Get(k) })
+}
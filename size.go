@@ -0,0 +1,94 @@
+package objcache
+
+import "reflect"
+
+// SizeBytes returns an estimate of the memory held by the live items: the
+// length of each key plus the size of its object, given by Config.Sizer
+// or else estimated by reflection. The estimate leaves out the overhead
+// of the cache itself and is only approximate. It walks all items under
+// the read lock, so it is O(n).
+func (c *ObjCache) SizeBytes() int64 {
+	now := c.now()
+	var n int64
+	c.mu.RLock()
+	for elem := c.list.Front(); elem != nil; elem = elem.Next() {
+		v := elem.Value.(*pair)
+		if v.expire < now {
+			continue
+		}
+		n = n + int64(len(v.key))
+		if c.config.Sizer != nil {
+			n = n + c.sizeOf(v.Object)
+		} else {
+			n = n + estimateSize(v.Object)
+		}
+	}
+	c.mu.RUnlock()
+	return n
+}
+
+// estimateSize returns the size of x and of the memory it refers to.
+// Memory referred to twice is counted once.
+func estimateSize(x interface{}) int64 {
+	if x == nil {
+		return 0
+	}
+	v := reflect.ValueOf(x)
+	return int64(v.Type().Size()) + referredSize(v, make(map[uintptr]bool))
+}
+
+// referredSize returns the size of the memory v refers to, without the
+// size of v itself. seen holds the pointers already counted.
+func referredSize(v reflect.Value, seen map[uintptr]bool) int64 {
+	switch v.Kind() {
+	case reflect.String:
+		return int64(v.Len())
+	case reflect.Slice:
+		if v.IsNil() || seen[v.Pointer()] {
+			return 0
+		}
+		seen[v.Pointer()] = true
+		n := int64(v.Cap()) * int64(v.Type().Elem().Size())
+		for i := 0; i < v.Len(); i = i + 1 {
+			n = n + referredSize(v.Index(i), seen)
+		}
+		return n
+	case reflect.Array:
+		var n int64
+		for i := 0; i < v.Len(); i = i + 1 {
+			n = n + referredSize(v.Index(i), seen)
+		}
+		return n
+	case reflect.Map:
+		if v.IsNil() || seen[v.Pointer()] {
+			return 0
+		}
+		seen[v.Pointer()] = true
+		var n int64
+		iter := v.MapRange()
+		for iter.Next() {
+			n = n + int64(iter.Key().Type().Size()) + referredSize(iter.Key(), seen)
+			n = n + int64(iter.Value().Type().Size()) + referredSize(iter.Value(), seen)
+		}
+		return n
+	case reflect.Ptr:
+		if v.IsNil() || seen[v.Pointer()] {
+			return 0
+		}
+		seen[v.Pointer()] = true
+		return int64(v.Type().Elem().Size()) + referredSize(v.Elem(), seen)
+	case reflect.Interface:
+		if v.IsNil() {
+			return 0
+		}
+		return int64(v.Elem().Type().Size()) + referredSize(v.Elem(), seen)
+	case reflect.Struct:
+		var n int64
+		for i := 0; i < v.NumField(); i = i + 1 {
+			n = n + referredSize(v.Field(i), seen)
+		}
+		return n
+	default:
+		return 0
+	}
+}
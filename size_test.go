@@ -0,0 +1,52 @@
+package objcache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSizeBytes(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("blob", make([]byte, 1000), 0)
+	cache.Set("name", "0123456789", 0)
+	cache.Set("expiring", make([]byte, 1000), time.Second)
+	clock.Advance(2 * time.Second)
+
+	// The keys and the data are 1018 bytes, and the slice and string
+	// headers are at most 48 bytes.
+	if n := cache.SizeBytes(); n < 1018 || n > 1018+48 {
+		t.Fatalf("expected about 1018 bytes, got %d", n)
+	}
+}
+
+func TestSizeBytesSizer(t *testing.T) {
+	cache, err := New(Config{
+		Sizer: func(value interface{}) int64 {
+			return int64(len(value.(string)))
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("a", "xx", 0)
+	cache.Set("bb", "yyyy", 0)
+	if n := cache.SizeBytes(); n != 9 {
+		t.Fatalf("expected 9 bytes, got %d", n)
+	}
+}
+
+func TestEstimateSizeShared(t *testing.T) {
+	b := make([]byte, 100)
+	type blobs struct{ A, B []byte }
+	once := estimateSize(blobs{A: b})
+	twice := estimateSize(blobs{A: b, B: b})
+	if once != twice {
+		t.Fatalf("expected shared memory to be counted once, got %d and %d", once, twice)
+	}
+}
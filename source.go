@@ -0,0 +1,30 @@
+package objcache
+
+import "time"
+
+// SourceRefresh rebuilds the whole cache from a source, like a file or an
+// API, every Interval. Each time Fetch succeeds, its entries replace the
+// items of the cache with ReplaceAll. If it fails, the cache keeps its
+// items until the next time.
+type SourceRefresh struct {
+	Interval time.Duration
+	Fetch    func() (map[string]Entry, error)
+}
+
+// refresher calls ReplaceAll with the entries of Config.SourceRefresh
+// every interval until Close is called.
+func (c *ObjCache) refresher(r SourceRefresh) {
+	for {
+		select {
+		case <-c.after(r.Interval):
+		case <-c.done:
+			return
+		}
+		x, err := c.call(func() (interface{}, error) { return r.Fetch() })
+		if err != nil {
+			continue
+		}
+		entries, _ := x.(map[string]Entry)
+		c.ReplaceAll(entries)
+	}
+}
@@ -0,0 +1,87 @@
+package objcache
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSourceRefresh(t *testing.T) {
+	clock := newFakeClock()
+	var calls int32
+	fail := errors.New("source down")
+	fetch := func() (map[string]Entry, error) {
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			return map[string]Entry{"a": {Value: 1}, "b": {Value: 2}}, nil
+		case 2:
+			return nil, fail
+		case 3:
+			panic("source broken")
+		}
+		return map[string]Entry{"c": {Value: 3}}, nil
+	}
+	c, err := New(Config{
+		MaxEntryLimit: 10,
+		Clock:         clock,
+		SourceRefresh: SourceRefresh{Interval: time.Minute, Fetch: fetch},
+		Warm:          []WarmEntry{{Key: "old", Value: 0}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	refresh := func(n int32) {
+		t.Helper()
+		waitFor(t, func() bool { return clock.pending() == 1 })
+		clock.Advance(time.Minute)
+		waitFor(t, func() bool { return atomic.LoadInt32(&calls) == n })
+		// Wait for the items to be replaced and the next timer to be set.
+		waitFor(t, func() bool { return clock.pending() == 1 })
+	}
+
+	if _, ok := c.Get("old"); !ok {
+		t.Fatal("expected the warm item before the first refresh")
+	}
+	refresh(1)
+	if x, ok := c.Get("a"); !ok || x != 1 {
+		t.Fatalf("expected a from the source, got %v, %v", x, ok)
+	}
+	if _, ok := c.Get("old"); ok || c.Len() != 2 {
+		t.Fatalf("expected only the items of the source, got %d", c.Len())
+	}
+
+	refresh(2)
+	refresh(3)
+	if _, ok := c.Get("b"); !ok || c.Len() != 2 {
+		t.Fatalf("expected the items to stay after failed fetches, got %d", c.Len())
+	}
+
+	refresh(4)
+	if _, ok := c.Get("c"); !ok || c.Len() != 1 {
+		t.Fatalf("expected c to replace the items, got %d", c.Len())
+	}
+}
+
+func TestSourceRefreshClose(t *testing.T) {
+	clock := newFakeClock()
+	fetch := func() (map[string]Entry, error) {
+		t.Error("unexpected Fetch after Close")
+		return nil, nil
+	}
+	c, err := New(Config{Clock: clock, SourceRefresh: SourceRefresh{Interval: time.Second, Fetch: fetch}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return clock.pending() == 1 })
+	c.Close()
+	time.Sleep(10 * time.Millisecond)
+	clock.Advance(time.Second)
+	time.Sleep(10 * time.Millisecond)
+
+	if _, err := NewSharded(Config{SourceRefresh: SourceRefresh{Interval: time.Second, Fetch: fetch}}); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("expected ErrInvalidConfig from NewSharded, got %v", err)
+	}
+}
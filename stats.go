@@ -0,0 +1,252 @@
+package objcache
+
+import (
+	"expvar"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultStatsBuckets is the number of buckets used when
+// Config.StatsBuckets is 0.
+const DefaultStatsBuckets = 60
+
+// Stats is a snapshot of the cache counters.
+type Stats struct {
+	Hits        int64
+	Misses      int64
+	Evictions   int64
+	Expirations int64
+	ItemCount   int
+
+	// Capacity is the MaxEntryLimit of the cache, or 0 if unlimited.
+	Capacity int
+
+	// Loads is the number of loader calls, as reported to
+	// Config.OnLoad, LoadErrors how many of them failed and LoadTime
+	// their total duration, so LoadTime / Loads is the average latency.
+	Loads      int64
+	LoadErrors int64
+	LoadTime   time.Duration
+}
+
+// counters are updated atomically, so Get can count under the read lock.
+type counters struct {
+	hits        int64
+	misses      int64
+	evictions   int64
+	expirations int64
+	loads       int64
+	loadErrors  int64
+	loadTime    int64
+}
+
+// bucket counts the Get hits and misses and the evictions of one period of
+// Config.StatsBucket. epoch is the number of the period, the time divided
+// by StatsBucket.
+type bucket struct {
+	epoch     int64
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+// count counts a Get hit or miss.
+func (c *ObjCache) count(hit bool) {
+	if hit {
+		atomic.AddInt64(&c.stats.hits, 1)
+	} else {
+		atomic.AddInt64(&c.stats.misses, 1)
+	}
+	if c.buckets == nil {
+		return
+	}
+	b := c.bucket(c.now())
+	if hit {
+		atomic.AddInt64(&b.hits, 1)
+	} else {
+		atomic.AddInt64(&b.misses, 1)
+	}
+}
+
+// countEviction counts an eviction for capacity.
+func (c *ObjCache) countEviction() {
+	atomic.AddInt64(&c.stats.evictions, 1)
+	if c.buckets != nil {
+		atomic.AddInt64(&c.bucket(c.now()).evictions, 1)
+	}
+}
+
+// bucket returns the bucket for now, cleared first if it still counts an
+// earlier period.
+func (c *ObjCache) bucket(now int64) *bucket {
+	epoch := now / int64(c.config.StatsBucket)
+	b := &c.buckets[epoch%int64(len(c.buckets))]
+	if old := atomic.LoadInt64(&b.epoch); old < epoch && atomic.CompareAndSwapInt64(&b.epoch, old, epoch) {
+		atomic.StoreInt64(&b.hits, 0)
+		atomic.StoreInt64(&b.misses, 0)
+		atomic.StoreInt64(&b.evictions, 0)
+	}
+	return b
+}
+
+// RecentHitRatio returns the ratio of Get hits to all Gets within the
+// last window, counted in buckets of Config.StatsBucket. window is rounded
+// up to whole buckets, at most Config.StatsBuckets. It returns NaN if
+// there were no Gets in the window or StatsBucket is 0. Gets racing with
+// the start of a new bucket may be lost, so the ratio is approximate.
+func (c *ObjCache) RecentHitRatio(window time.Duration) float64 {
+	if c.buckets == nil {
+		return math.NaN()
+	}
+	size := int64(c.config.StatsBucket)
+	n := (int64(window) + size - 1) / size
+	if n > int64(len(c.buckets)) {
+		n = int64(len(c.buckets))
+	}
+	epoch := c.now() / size
+	var hits, misses int64
+	for i := int64(0); i < n; i = i + 1 {
+		b := &c.buckets[(epoch-i)%int64(len(c.buckets))]
+		if atomic.LoadInt64(&b.epoch) == epoch-i {
+			hits = hits + atomic.LoadInt64(&b.hits)
+			misses = misses + atomic.LoadInt64(&b.misses)
+		}
+	}
+	if hits+misses == 0 {
+		return math.NaN()
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+// EvictionRate returns the evictions for capacity per second within the
+// last window, counted in buckets of Config.StatsBucket like
+// RecentHitRatio. The rate is averaged over the whole buckets, including
+// the part of the current one still to come. It returns 0 if StatsBucket
+// is 0.
+func (c *ObjCache) EvictionRate(window time.Duration) float64 {
+	if c.buckets == nil {
+		return 0
+	}
+	size := int64(c.config.StatsBucket)
+	n := (int64(window) + size - 1) / size
+	if n > int64(len(c.buckets)) {
+		n = int64(len(c.buckets))
+	}
+	if n == 0 {
+		return 0
+	}
+	epoch := c.now() / size
+	var evictions int64
+	for i := int64(0); i < n; i = i + 1 {
+		b := &c.buckets[(epoch-i)%int64(len(c.buckets))]
+		if atomic.LoadInt64(&b.epoch) == epoch-i {
+			evictions = evictions + atomic.LoadInt64(&b.evictions)
+		}
+	}
+	return float64(evictions) / time.Duration(n*size).Seconds()
+}
+
+// Utilization returns the number of items divided by MaxEntryLimit, or 0
+// if the number of items is unlimited.
+func (c *ObjCache) Utilization() float64 {
+	c.mu.RLock()
+	n := c.itemCount
+	capacity := c.config.MaxEntryLimit
+	c.mu.RUnlock()
+	if capacity <= 0 {
+		return 0
+	}
+	return float64(n) / float64(capacity)
+}
+
+// Stats returns the counters of the cache.
+func (c *ObjCache) Stats() Stats {
+	c.mu.RLock()
+	n := c.itemCount
+	capacity := c.config.MaxEntryLimit
+	c.mu.RUnlock()
+	if capacity < 0 {
+		capacity = 0
+	}
+	return Stats{
+		Hits:        atomic.LoadInt64(&c.stats.hits),
+		Misses:      atomic.LoadInt64(&c.stats.misses),
+		Evictions:   atomic.LoadInt64(&c.stats.evictions),
+		Expirations: atomic.LoadInt64(&c.stats.expirations),
+		ItemCount:   n,
+		Capacity:    capacity,
+		Loads:       atomic.LoadInt64(&c.stats.loads),
+		LoadErrors:  atomic.LoadInt64(&c.stats.loadErrors),
+		LoadTime:    time.Duration(atomic.LoadInt64(&c.stats.loadTime)),
+	}
+}
+
+// PublishExpvar publishes the Stats of the cache with expvar under name,
+// as a JSON object read on each request of /debug/vars. Like
+// expvar.Publish it panics if name is already used, so each cache needs
+// its own name.
+func (c *ObjCache) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		s := c.Stats()
+		return map[string]int64{
+			"hits":        s.Hits,
+			"misses":      s.Misses,
+			"evictions":   s.Evictions,
+			"expirations": s.Expirations,
+			"items":       int64(s.ItemCount),
+			"capacity":    int64(s.Capacity),
+		}
+	}))
+}
+
+// OldestAge returns how long ago the object of the least recently used
+// live item was set. It returns false if there is none.
+func (c *ObjCache) OldestAge() (time.Duration, bool) {
+	return c.age(true)
+}
+
+// NewestAge returns how long ago the object of the most recently used
+// live item was set. It returns false if there is none.
+func (c *ObjCache) NewestAge() (time.Duration, bool) {
+	return c.age(false)
+}
+
+// age returns the age of the first live item from the front of the LRU
+// list if oldest is true, or else from the back.
+func (c *ObjCache) age(oldest bool) (time.Duration, bool) {
+	now := c.now()
+	c.mu.RLock()
+	elem := c.list.Back()
+	if oldest {
+		elem = c.list.Front()
+	}
+	for elem != nil {
+		v := elem.Value.(*pair)
+		if !c.expired(v) {
+			c.mu.RUnlock()
+			return time.Duration(now - v.created), true
+		}
+		if oldest {
+			elem = elem.Next()
+		} else {
+			elem = elem.Prev()
+		}
+	}
+	c.mu.RUnlock()
+	return 0, false
+}
+
+// ResetStats sets all counters to 0.
+func (c *ObjCache) ResetStats() {
+	atomic.StoreInt64(&c.stats.hits, 0)
+	atomic.StoreInt64(&c.stats.misses, 0)
+	atomic.StoreInt64(&c.stats.evictions, 0)
+	atomic.StoreInt64(&c.stats.expirations, 0)
+	atomic.StoreInt64(&c.stats.loads, 0)
+	atomic.StoreInt64(&c.stats.loadErrors, 0)
+	atomic.StoreInt64(&c.stats.loadTime, 0)
+	for i := range c.buckets {
+		atomic.StoreInt64(&c.buckets[i].epoch, -1)
+	}
+}
@@ -0,0 +1,304 @@
+package objcache
+
+import (
+	"container/heap"
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Store is a backing store the cache writes through to. With Config.Store
+// set, Set and the other writes update the Store before the cache, and
+// leave the cache as it was if the Store fails. Del and the other
+// deletes remove the item first and restore it if the Store fails. The
+// Store is called with the write lock held, so it must not use the
+// cache. Items leaving the cache by eviction or expiration stay in the
+// Store.
+//
+// With Config.WriteBehind the writes are queued instead and flushed to
+// the Store by a background goroutine, which calls the Store without the
+// lock.
+type Store interface {
+	Set(key string, value interface{}) error
+	Delete(key string) error
+}
+
+// setThrough is Config.Store.Set followed by setAt. If the Store fails,
+// the cache is left as it was, with nothing evicted, and nil is returned
+// with the error. While the cache is read-only it returns ErrReadOnly, and
+// after Close ErrCacheClosed. The caller must hold the write lock.
+func (c *ObjCache) setThrough(k string, x interface{}, size int64, expire int64, d time.Duration) (*list.Element, error) {
+	if err := c.settable(k); err != nil {
+		return nil, err
+	}
+	if c.config.Store != nil {
+		if err := c.writeBack(write{key: k, value: x}); err != nil {
+			return nil, err
+		}
+	}
+	elem := c.setAt(k, x, size, expire, d)
+	c.invalidated(k)
+	return elem, nil
+}
+
+// setLocal is setThrough for the memory only: k is checked like Set
+// does, but neither written to Config.Store nor published. The caller
+// must hold the write lock.
+func (c *ObjCache) setLocal(k string, x interface{}, size int64, expire int64, d time.Duration) (*list.Element, error) {
+	if err := c.checkKey(k); err != nil {
+		return nil, err
+	}
+	if err := c.settable(k); err != nil {
+		return nil, err
+	}
+	return c.setAt(k, x, size, expire, d), nil
+}
+
+// settable returns the error of setting k, if the cache is read-only or
+// closed, full with Config.RejectOnFull, or k is not let in by
+// Config.Admission. The caller must hold the write lock.
+func (c *ObjCache) settable(k string) error {
+	if err := c.writable(); err != nil {
+		return err
+	}
+	if c.full(k) {
+		return ErrCacheFull
+	}
+	if !c.admit(k) {
+		return ErrNotAdmitted
+	}
+	return nil
+}
+
+// delThrough removes the item of k, if any, then deletes k from
+// Config.Store. If the Store fails, the item is restored at its place in
+// the LRU list. It returns whether the item was removed and the error of
+// the Store. While the cache is read-only it returns ErrReadOnly, and
+// after Close ErrCacheClosed. The caller must hold the write lock.
+func (c *ObjCache) delThrough(k string) (bool, error) {
+	if err := c.writable(); err != nil {
+		return false, err
+	}
+	elem, ok := c.items[k]
+	var next *list.Element
+	n := len(c.evicted)
+	if ok {
+		next = elem.Next()
+		c.remove(elem, ReasonDeleted)
+	}
+	if c.config.Store == nil {
+		c.invalidated(k)
+		return ok, nil
+	}
+	err := c.writeBack(write{key: k, deleted: true})
+	if err == nil {
+		c.invalidated(k)
+	}
+	if err == nil || !ok {
+		return ok, err
+	}
+
+	c.evicted = c.evicted[:n]
+	// The pair is used again, so it must not be recycled.
+	c.free = c.free[:len(c.free)-1]
+	p := elem.Value.(*pair)
+	if next == nil {
+		elem = c.list.PushBack(p)
+	} else {
+		elem = c.list.InsertBefore(p, next)
+	}
+	c.items[k] = elem
+	c.tag(p)
+	c.intern(p)
+	c.index(p)
+	heap.Push(&c.heap, p)
+	c.itemCount = c.itemCount + 1
+	if p.pinned {
+		c.pinned = c.pinned + 1
+	}
+	c.bytes = c.bytes + p.size
+	c.cost = c.cost + p.cost
+	return false, err
+}
+
+// DefaultFlushInterval is used when Config.FlushInterval is 0.
+const DefaultFlushInterval = time.Second
+
+// maxFlushBackoff limits the wait between flushes after Store errors.
+const maxFlushBackoff = time.Minute
+
+// write is a change waiting to be flushed to the Store with
+// Config.WriteBehind.
+type write struct {
+	key     string
+	value   interface{}
+	deleted bool
+}
+
+// writeBehind is the queue of writes not flushed yet. Writes to the same
+// key are coalesced into the latest one, which keeps the place of the
+// first.
+type writeBehind struct {
+	mu     sync.Mutex
+	keys   []string
+	writes map[string]write
+
+	// flushMu makes flushes run one at a time, so writes to a key reach
+	// the Store in order.
+	flushMu sync.Mutex
+
+	// wake starts a flush early when MaxBatch writes are waiting.
+	wake chan struct{}
+
+	// stopped is closed when the flusher returns.
+	stopped chan struct{}
+}
+
+// writeBack sends w to the Store now, or queues it with
+// Config.WriteBehind. After Close writes are not queued any more. The
+// caller must hold the write lock.
+func (c *ObjCache) writeBack(w write) error {
+	if c.config.WriteBehind && !c.closed() {
+		c.queue(w)
+		return nil
+	}
+	err := c.store(w)
+	if err != nil {
+		c.logLocked(LevelError, "objcache: store failed", map[string]interface{}{"key": w.key, "error": err})
+	}
+	return err
+}
+
+// store sends w to the Store. A panic of the Store is returned as
+// ErrCallbackPanic.
+func (c *ObjCache) store(w write) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.panicked(r)
+			err = ErrCallbackPanic
+		}
+	}()
+	if w.deleted {
+		return c.config.Store.Delete(w.key)
+	}
+	return c.config.Store.Set(w.key, w.value)
+}
+
+// queue adds w to the writes waiting for the flusher.
+func (c *ObjCache) queue(w write) {
+	wb := &c.writeBehind
+	wb.mu.Lock()
+	if _, ok := wb.writes[w.key]; !ok {
+		wb.keys = append(wb.keys, w.key)
+	}
+	wb.writes[w.key] = w
+	full := c.config.MaxBatch > 0 && len(wb.keys) >= c.config.MaxBatch
+	wb.mu.Unlock()
+	if full {
+		select {
+		case wb.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// flush sends all queued writes to the Store in batches of at most
+// Config.MaxBatch. A failed write is passed to Config.OnStoreError and,
+// if retry is true and the key has not been written again since, queued
+// again. It returns the first error.
+func (c *ObjCache) flush(retry bool) error {
+	wb := &c.writeBehind
+	wb.flushMu.Lock()
+	var first error
+	for {
+		wb.mu.Lock()
+		n := len(wb.keys)
+		if c.config.MaxBatch > 0 && n > c.config.MaxBatch {
+			n = c.config.MaxBatch
+		}
+		batch := make([]write, 0, n)
+		for _, k := range wb.keys[:n] {
+			batch = append(batch, wb.writes[k])
+			delete(wb.writes, k)
+		}
+		wb.keys = wb.keys[n:]
+		wb.mu.Unlock()
+		if len(batch) == 0 {
+			break
+		}
+
+		var failed []write
+		for _, w := range batch {
+			err := c.store(w)
+			if err == nil {
+				continue
+			}
+			if first == nil {
+				first = err
+			}
+			c.log(LevelError, "objcache: store failed", map[string]interface{}{"key": w.key, "error": err})
+			if c.config.OnStoreError != nil {
+				c.guard(func() { c.config.OnStoreError(w.key, err) })
+			}
+			failed = append(failed, w)
+		}
+		if len(failed) > 0 {
+			if retry {
+				c.requeue(failed)
+			}
+			break
+		}
+	}
+	wb.flushMu.Unlock()
+	return first
+}
+
+// requeue puts failed writes back at the front of the queue unless their
+// keys have been written again.
+func (c *ObjCache) requeue(failed []write) {
+	wb := &c.writeBehind
+	wb.mu.Lock()
+	keys := make([]string, 0, len(failed)+len(wb.keys))
+	for _, w := range failed {
+		if _, ok := wb.writes[w.key]; ok {
+			continue
+		}
+		wb.writes[w.key] = w
+		keys = append(keys, w.key)
+	}
+	wb.keys = append(keys, wb.keys...)
+	wb.mu.Unlock()
+}
+
+// flusher flushes the queued writes every interval, or earlier when a
+// batch is full, until Close is called. After a failed flush the wait is
+// doubled up to maxFlushBackoff.
+func (c *ObjCache) flusher(interval time.Duration) {
+	defer close(c.writeBehind.stopped)
+	wait := interval
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-c.writeBehind.wake:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		case <-c.done:
+			return
+		}
+		if c.flush(true) != nil {
+			wait = wait * 2
+			if wait > maxFlushBackoff {
+				wait = maxFlushBackoff
+			}
+		} else {
+			wait = interval
+		}
+		timer.Reset(wait)
+	}
+}
@@ -0,0 +1,363 @@
+package objcache
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStore is a Store in a map. It fails with err if err is set, and
+// counts the calls of each key.
+type fakeStore struct {
+	mu    sync.Mutex
+	items map[string]interface{}
+	calls map[string]int
+	err   error
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		items: make(map[string]interface{}),
+		calls: make(map[string]int),
+	}
+}
+
+func (s *fakeStore) Set(key string, value interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls[key] = s.calls[key] + 1
+	if s.err != nil {
+		return s.err
+	}
+	s.items[key] = value
+	return nil
+}
+
+func (s *fakeStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls[key] = s.calls[key] + 1
+	if s.err != nil {
+		return s.err
+	}
+	delete(s.items, key)
+	return nil
+}
+
+// len returns the number of items in s.
+func (s *fakeStore) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.items)
+}
+
+// setErr makes s fail with err.
+func (s *fakeStore) setErr(err error) {
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+}
+
+func TestStoreWriteThrough(t *testing.T) {
+	store := newFakeStore()
+	cache, err := New(Config{Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("a", 1, 0)
+	cache.Add("b", 2, 0)
+	cache.Replace("a", 3, 0)
+	if store.items["a"] != 3 || store.items["b"] != 2 {
+		t.Fatalf("expected writes in the store, got %v", store.items)
+	}
+
+	if !cache.Del("a") {
+		t.Fatal("expected Del to succeed")
+	}
+	if _, ok := store.items["a"]; ok {
+		t.Fatal("expected the key to be deleted from the store")
+	}
+}
+
+func TestStoreClone(t *testing.T) {
+	store := newFakeStore()
+	cache, err := New(Config{Store: store, WriteBehind: true, OnStoreError: func(string, error) {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("a", 1, 0)
+	clone, err := cache.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clone.Set("b", 2, 0)
+	clone.Del("a")
+	clone.Close()
+	cache.Close()
+	if store.len() != 1 || store.items["a"] != 1 {
+		t.Fatalf("expected the writes of the clone to stay out of the store, got %v", store.items)
+	}
+}
+
+func TestStoreErrorKeepsEvicted(t *testing.T) {
+	store := newFakeStore()
+	cache, err := New(Config{Store: store, MaxEntryLimit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("a", 1, 0)
+	cache.Set("b", 2, 0)
+	store.setErr(errors.New("down"))
+	if err := cache.Set("c", 3, 0); err == nil {
+		t.Fatal("expected the error of the store")
+	}
+	if !cache.Has("a") || !cache.Has("b") || cache.Has("c") {
+		t.Fatal("expected the failed Set to evict nothing")
+	}
+	if err := cache.CheckConsistency(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreBulkDeletes(t *testing.T) {
+	store := newFakeStore()
+	cache, err := New(Config{Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("p/1", 1, 0)
+	cache.Set("p/2", 2, 0)
+	cache.SetWithTags("t", 3, 0, "tag")
+	cache.Set("old", 4, 0)
+	cache.Set("q", 5, 0)
+
+	if n := cache.DeletePrefix("p/"); n != 2 {
+		t.Fatalf("expected 2 items deleted, got %d", n)
+	}
+	if n := cache.InvalidateTag("tag"); n != 1 {
+		t.Fatalf("expected 1 item invalidated, got %d", n)
+	}
+	if !cache.Rename("old", "new") {
+		t.Fatal("expected Rename to succeed")
+	}
+	if _, ok := store.items["new"]; !ok || store.len() != 2 {
+		t.Fatalf("expected the store to follow the cache, got %v", store.items)
+	}
+
+	store.setErr(errors.New("down"))
+	if n := cache.DeleteFunc(func(string, interface{}) bool { return true }); n != 0 {
+		t.Fatalf("expected nothing deleted while the store fails, got %d", n)
+	}
+	if cache.Rename("new", "newer") {
+		t.Fatal("expected Rename to fail with the store")
+	}
+	if !cache.Has("new") || !cache.Has("q") {
+		t.Fatal("expected the items to be kept")
+	}
+	if err := cache.CheckConsistency(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// keyFailStore is a fakeStore whose Set fails for key.
+type keyFailStore struct {
+	*fakeStore
+	key string
+}
+
+func (s keyFailStore) Set(key string, value interface{}) error {
+	if key == s.key {
+		return errors.New("down")
+	}
+	return s.fakeStore.Set(key, value)
+}
+
+func TestStoreRenameFailure(t *testing.T) {
+	store := newFakeStore()
+	cache, err := New(Config{Store: keyFailStore{fakeStore: store, key: "new"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("old", 1, 0)
+	store.items["new"] = 2
+
+	if cache.Rename("old", "new") {
+		t.Fatal("expected Rename to fail with the store")
+	}
+	if store.items["old"] != 1 || store.items["new"] != 2 {
+		t.Fatalf("expected the store to be restored, got %v", store.items)
+	}
+	if v, ok := cache.Get("old"); !ok || v != 1 {
+		t.Fatalf("expected old to be kept, got %v, %v", v, ok)
+	}
+}
+
+func TestStoreError(t *testing.T) {
+	store := newFakeStore()
+	var evicted []string
+	cache, err := New(Config{
+		Store: store,
+		OnEvicted: func(key string, value interface{}, reason EvictReason) {
+			evicted = append(evicted, key)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("old", 1, 0)
+
+	failure := errors.New("failure")
+	store.setErr(failure)
+	if err := cache.Set("new", 2, 0); err != failure {
+		t.Fatalf("expected the store error, got %v", err)
+	}
+	if cache.Has("new") || cache.Len() != 1 {
+		t.Fatal("expected no stale entry after a failed store write")
+	}
+
+	if err := cache.Set("old", 2, 0); err != failure {
+		t.Fatalf("expected the store error, got %v", err)
+	}
+	if v, ok := cache.Get("old"); !ok || v != 1 {
+		t.Fatalf("expected the old object to be kept, got %v, %v", v, ok)
+	}
+
+	if cache.Del("old") {
+		t.Fatal("expected Del to fail")
+	}
+	if v, ok := cache.Get("old"); !ok || v != 1 {
+		t.Fatalf("expected the item to be kept, got %v, %v", v, ok)
+	}
+	if len(evicted) != 0 {
+		t.Fatalf("expected no OnEvicted calls, got %v", evicted)
+	}
+}
+
+func TestWriteBehindCoalesce(t *testing.T) {
+	store := newFakeStore()
+	cache, err := New(Config{Store: store, WriteBehind: true, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("a", 1, 0)
+	cache.Set("a", 2, 0)
+	cache.Set("a", 3, 0)
+	cache.Set("b", 1, 0)
+	cache.Set("c", 1, 0)
+	cache.Del("c")
+	if store.len() != 0 {
+		t.Fatal("expected no writes before a flush")
+	}
+
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if store.items["a"] != 3 || store.items["b"] != 1 || len(store.items) != 2 {
+		t.Fatalf("expected the latest writes after Close, got %v", store.items)
+	}
+	if store.calls["a"] != 1 || store.calls["c"] != 1 {
+		t.Fatalf("expected one store call per key, got %v", store.calls)
+	}
+}
+
+func TestWriteBehindMaxBatch(t *testing.T) {
+	store := newFakeStore()
+	cache, err := New(Config{Store: store, WriteBehind: true, FlushInterval: time.Hour, MaxBatch: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	cache.Set("a", 1, 0)
+	cache.Set("b", 2, 0)
+	deadline := time.Now().Add(time.Second)
+	for store.len() != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected a full batch to be flushed early")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWriteBehindError(t *testing.T) {
+	store := newFakeStore()
+	failure := errors.New("failure")
+	store.setErr(failure)
+	var mu sync.Mutex
+	var failed []string
+	cache, err := New(Config{
+		Store:         store,
+		WriteBehind:   true,
+		FlushInterval: time.Millisecond,
+		OnStoreError: func(key string, err error) {
+			mu.Lock()
+			failed = append(failed, key)
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.Set("a", 1, 0); err != nil {
+		t.Fatalf("expected Set not to wait for the store, got %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		mu.Lock()
+		n := len(failed)
+		mu.Unlock()
+		if n >= 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the failed write to be reported and retried")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	store.setErr(nil)
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if store.items["a"] != 1 {
+		t.Fatalf("expected the retried write after Close, got %v", store.items)
+	}
+}
+
+func TestStoreLoad(t *testing.T) {
+	src, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.Set("a", 1, 0)
+	src.Set("b", 2, time.Minute)
+	var buf bytes.Buffer
+	if err := src.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data, err := src.ExportJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := newFakeStore()
+	cache, err := New(Config{Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Load(&buf); err != nil {
+		t.Fatal(err)
+	}
+	cache.Flush()
+	if n, err := cache.LoadStream(bytes.NewReader(data), FormatJSON); n != 2 || err != nil {
+		t.Fatalf("expected 2 items loaded, got %d, %v", n, err)
+	}
+	if n := store.len(); n != 0 {
+		t.Fatalf("expected loading to leave the store alone, got %d items", n)
+	}
+}
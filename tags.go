@@ -0,0 +1,78 @@
+package objcache
+
+import "time"
+
+// SetWithTags sets a value for key like Set and tags it, so it can be
+// deleted with the other items of a tag by InvalidateTag. The tags
+// replace those the key had. Set keeps the tags of an existing key.
+func (c *ObjCache) SetWithTags(k string, x interface{}, d time.Duration, tags ...string) error {
+	if err := c.checkKey(k); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	elem, err := c.setThrough(k, x, c.sizeOf(x), c.expireAt(d), d)
+	if err != nil {
+		c.unlock()
+		return err
+	}
+	p := elem.Value.(*pair)
+	c.untag(p)
+	p.tags = append([]string(nil), tags...)
+	c.tag(p)
+	c.unlock()
+	return nil
+}
+
+// InvalidateTag deletes all items tagged with tag, from Config.Store too
+// like Del, and returns how many. OnEvicted is called for them. It only
+// visits the items of the tag.
+func (c *ObjCache) InvalidateTag(tag string) int {
+	c.mu.Lock()
+	if c.writable() != nil {
+		c.unlock()
+		return 0
+	}
+	keys := make([]string, 0, len(c.tags[tag]))
+	for k := range c.tags[tag] {
+		keys = append(keys, k)
+	}
+	n := 0
+	for _, k := range keys {
+		if ok, err := c.delThrough(k); ok && err == nil {
+			n = n + 1
+		}
+	}
+	c.unlock()
+	return n
+}
+
+// tag adds p to the index of its tags. The caller must hold the write
+// lock.
+func (c *ObjCache) tag(p *pair) {
+	if len(p.tags) == 0 {
+		return
+	}
+	if c.tags == nil {
+		c.tags = make(map[string]map[string]struct{})
+	}
+	for _, t := range p.tags {
+		keys, ok := c.tags[t]
+		if !ok {
+			keys = make(map[string]struct{})
+			c.tags[t] = keys
+		}
+		keys[p.key] = struct{}{}
+	}
+}
+
+// untag removes p from the index of its tags. Tags left without keys are
+// dropped. The caller must hold the write lock.
+func (c *ObjCache) untag(p *pair) {
+	for _, t := range p.tags {
+		keys := c.tags[t]
+		delete(keys, p.key)
+		if len(keys) == 0 {
+			delete(c.tags, t)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package objcache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInvalidateTag(t *testing.T) {
+	clock := newFakeClock()
+	var evicted []string
+	cache, err := New(Config{
+		Clock:     clock,
+		OnEvicted: func(k string, v interface{}, r EvictReason) { evicted = append(evicted, k) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.SetWithTags("user:1", 1, time.Minute, "users", "team:a")
+	cache.SetWithTags("user:2", 2, time.Minute, "users", "team:b")
+	cache.SetWithTags("team:a", "a", time.Minute, "team:a")
+	cache.Set("other", 3, time.Minute)
+
+	if n := cache.InvalidateTag("team:a"); n != 2 {
+		t.Fatalf("expected 2 items to be invalidated, got %d", n)
+	}
+	if cache.Has("user:1") || cache.Has("team:a") {
+		t.Fatal("expected the items tagged team:a to be deleted")
+	}
+	if !cache.Has("user:2") || !cache.Has("other") {
+		t.Fatal("expected the other items to be kept")
+	}
+	if len(evicted) != 2 {
+		t.Fatalf("expected OnEvicted for 2 items, got %v", evicted)
+	}
+	if n := cache.InvalidateTag("team:a"); n != 0 {
+		t.Fatalf("expected nothing left to invalidate, got %d", n)
+	}
+
+	// Set keeps the tags, SetWithTags replaces them.
+	cache.Set("user:2", 20, time.Minute)
+	cache.SetWithTags("other", 30, time.Minute, "users")
+	if n := cache.InvalidateTag("users"); n != 2 {
+		t.Fatalf("expected 2 users to be invalidated, got %d", n)
+	}
+	if cache.Len() != 0 {
+		t.Fatalf("expected an empty cache, got %d items", cache.Len())
+	}
+	if len(cache.tags) != 0 {
+		t.Fatalf("expected an empty tag index, got %v", cache.tags)
+	}
+}
+
+func TestTagIndexCleanup(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{Clock: clock, MaxEntryLimit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.SetWithTags("a", 1, time.Second, "short")
+	cache.SetWithTags("b", 2, time.Minute, "long")
+	cache.SetWithTags("c", 3, time.Minute, "long")
+	if _, ok := cache.tags["short"]; ok {
+		t.Fatal("expected the evicted item to leave the tag index")
+	}
+
+	clock.Advance(2 * time.Minute)
+	cache.DeleteExpired()
+	if len(cache.tags) != 0 {
+		t.Fatalf("expected expired items to leave the tag index, got %v", cache.tags)
+	}
+
+	cache.SetWithTags("d", 4, time.Minute, "t")
+	if !cache.Rename("d", "e") {
+		t.Fatal("expected d to be renamed")
+	}
+	if n := cache.InvalidateTag("t"); n != 1 || cache.Has("e") {
+		t.Fatalf("expected the renamed item to keep its tag, got %d", n)
+	}
+}
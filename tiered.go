@@ -0,0 +1,125 @@
+package objcache
+
+import "time"
+
+// TieredCache is a small hot cache in front of a larger cold cache. Items
+// are set in the hot tier. Items evicted from the hot tier for capacity
+// are demoted to the cold tier, and a Get hit in the cold tier promotes
+// the item back to the hot tier. Items keep their expiration time when
+// they move between the tiers.
+type TieredCache struct {
+	hot  *ObjCache
+	cold *ObjCache
+}
+
+// NewTiered makes a tiered cache with a config for each tier and returns
+// it. The OnEvicted of the hot tier is not called for demoted items.
+func NewTiered(hot, cold Config) (*TieredCache, error) {
+	h, err := New(hot)
+	if err != nil {
+		return nil, err
+	}
+	c, err := New(cold)
+	if err != nil {
+		h.Close()
+		return nil, err
+	}
+	h.demote = func(e eviction) {
+		c.mu.Lock()
+		c.setLocal(e.key, e.value, c.sizeOf(e.value), e.expire, e.ttl)
+		c.unlock()
+	}
+	return &TieredCache{hot: h, cold: c}, nil
+}
+
+// Set a value for key in the hot tier. See ObjCache.Set. The old object
+// is deleted from the cold tier only if the hot tier takes the new one.
+func (c *TieredCache) Set(k string, x interface{}, d time.Duration) error {
+	if err := c.hot.Set(k, x, d); err != nil {
+		return err
+	}
+	c.cold.Del(k)
+	return nil
+}
+
+// Get the object of key from the hot tier, or else from the cold tier.
+// If the key was set in the hot tier since the miss, the object of the
+// cold tier is stale, so it is dropped instead of promoted. If the hot
+// tier does not take the object, like while it is read-only, it stays in
+// the cold tier.
+func (c *TieredCache) Get(k string) (interface{}, bool) {
+	if x, ok := c.hot.Get(k); ok {
+		return x, true
+	}
+	v, ok := c.cold.peek(k)
+	if !ok {
+		return nil, false
+	}
+	c.hot.mu.Lock()
+	if elem, ok := c.hot.items[k]; ok && !c.hot.expired(elem.Value.(*pair)) {
+		c.hot.unlock()
+		c.cold.drop(v)
+		return c.hot.Get(k)
+	}
+	_, err := c.hot.setLocal(k, v.Object, c.hot.sizeOf(v.Object), v.expire, v.ttl)
+	c.hot.unlock()
+	if err != nil {
+		return c.cold.copyOut(v.Object), true
+	}
+	c.cold.drop(v)
+	return c.hot.copyOut(v.Object), true
+}
+
+// Del delete an item for some key from both tiers.
+func (c *TieredCache) Del(k string) bool {
+	hot := c.hot.Del(k)
+	cold := c.cold.Del(k)
+	return hot || cold
+}
+
+// Len returns the number of items in both tiers.
+func (c *TieredCache) Len() int {
+	return c.hot.Len() + c.cold.Len()
+}
+
+// Close closes both tiers and returns the first error.
+func (c *TieredCache) Close() error {
+	err := c.hot.Close()
+	if cerr := c.cold.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// peek returns a copy of the live item of k, without counting it as a
+// read. It misses after Close.
+func (c *ObjCache) peek(k string) (pair, bool) {
+	c.mu.RLock()
+	elem, ok := c.items[k]
+	if !ok || c.closed() || c.expired(elem.Value.(*pair)) {
+		c.mu.RUnlock()
+		return pair{}, false
+	}
+	p := elem.Value.(*pair)
+	v := pair{
+		Object:  p.Object,
+		key:     p.key,
+		expire:  p.expire,
+		ttl:     p.ttl,
+		version: p.version,
+	}
+	c.mu.RUnlock()
+	return v, true
+}
+
+// drop removes the item of v.key if it is still the one peek returned as
+// v, without calling OnEvicted. It does nothing while the cache is
+// read-only or closed.
+func (c *ObjCache) drop(v pair) {
+	c.mu.Lock()
+	elem, ok := c.items[v.key]
+	if ok && c.writable() == nil && elem.Value.(*pair).version == v.version {
+		c.remove(elem, reasonMoved)
+	}
+	c.unlock()
+}
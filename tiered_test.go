@@ -0,0 +1,154 @@
+package objcache
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTieredCache(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := NewTiered(
+		Config{MaxEntryLimit: 2, Clock: clock},
+		Config{MaxEntryLimit: 10, Clock: clock},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	cache.Set("a", 1, time.Minute)
+	cache.Set("b", 2, 0)
+	cache.Set("c", 3, 0)
+	if cache.hot.Has("a") || !cache.cold.Has("a") {
+		t.Fatal("expected the hot eviction to demote a to the cold tier")
+	}
+	if n := cache.Len(); n != 3 {
+		t.Fatalf("expected no item to be lost, got %d items", n)
+	}
+
+	if v, ok := cache.Get("a"); !ok || v != 1 {
+		t.Fatalf("expected a from the cold tier, got %v, %v", v, ok)
+	}
+	if !cache.hot.Has("a") || cache.cold.Has("a") {
+		t.Fatal("expected the cold hit to promote a to the hot tier")
+	}
+	if _, expire, _ := cache.hot.GetWithExpiration("a"); expire.Sub(clock.Now()) != time.Minute {
+		t.Fatalf("expected the promoted item to keep its expiration, got %v", expire.Sub(clock.Now()))
+	}
+	if !cache.cold.Has("b") {
+		t.Fatal("expected the promotion to demote b")
+	}
+
+	if !cache.Del("b") || cache.Len() != 2 {
+		t.Fatal("expected Del to remove b from the cold tier")
+	}
+}
+
+// hookClock is a Clock calling hook once on its next Now.
+type hookClock struct {
+	mu   sync.Mutex
+	hook func()
+}
+
+func (c *hookClock) Now() time.Time {
+	c.mu.Lock()
+	hook := c.hook
+	c.hook = nil
+	c.mu.Unlock()
+	if hook != nil {
+		hook()
+	}
+	return time.Unix(1000, 0)
+}
+
+func TestTieredPromoteRace(t *testing.T) {
+	clock := &hookClock{}
+	cache, err := NewTiered(Config{MaxEntryLimit: 1}, Config{Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	cache.Set("a", "old", 0)
+	cache.Set("b", 2, 0)
+	if !cache.cold.Has("a") {
+		t.Fatal("expected a to be demoted")
+	}
+
+	// a is set in the hot tier after Get misses it there, while the cold
+	// tier looks it up.
+	clock.mu.Lock()
+	// Deleting b first keeps it from being demoted under the cold lock.
+	clock.hook = func() {
+		cache.hot.Del("b")
+		cache.hot.Set("a", "new", 0)
+	}
+	clock.mu.Unlock()
+	if v, ok := cache.Get("a"); !ok || v != "new" {
+		t.Fatalf("expected the new object of a, got %v, %v", v, ok)
+	}
+	if v, _ := cache.hot.Get("a"); v != "new" {
+		t.Fatalf("expected the promotion not to overwrite a, got %v", v)
+	}
+}
+
+func TestTieredSetFailure(t *testing.T) {
+	cache, err := NewTiered(Config{MaxEntryLimit: 1}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	cache.Set("a", 1, 0)
+	cache.Set("b", 2, 0)
+	cache.hot.SetReadOnly(true)
+	if err := cache.Set("a", 3, 0); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+	if v, ok := cache.cold.Get("a"); !ok || v != 1 {
+		t.Fatalf("expected a to stay in the cold tier, got %v, %v", v, ok)
+	}
+}
+
+func TestTieredReadOnlyHot(t *testing.T) {
+	cache, err := NewTiered(Config{MaxEntryLimit: 1}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	cache.Set("a", 1, 0)
+	cache.Set("b", 2, 0)
+	cache.hot.SetReadOnly(true)
+
+	if v, ok := cache.Get("a"); !ok || v != 1 {
+		t.Fatalf("expected a from the cold tier, got %v, %v", v, ok)
+	}
+	if cache.hot.Has("a") || !cache.hot.Has("b") {
+		t.Fatal("expected the read-only hot tier not to change")
+	}
+	if !cache.cold.Has("a") {
+		t.Fatal("expected a to stay in the cold tier")
+	}
+}
+
+func TestTieredReadOnlyCold(t *testing.T) {
+	cache, err := NewTiered(Config{MaxEntryLimit: 1}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	cache.Set("a", 1, 0)
+	cache.Set("b", 2, 0)
+	cache.cold.SetReadOnly(true)
+
+	cache.Set("c", 3, 0)
+	if cache.cold.Has("b") {
+		t.Fatal("expected b not to be demoted to the read-only cold tier")
+	}
+	if v, ok := cache.Get("a"); !ok || v != 1 {
+		t.Fatalf("expected a from the cold tier, got %v, %v", v, ok)
+	}
+	if !cache.hot.Has("a") || !cache.cold.Has("a") {
+		t.Fatal("expected a to be promoted and kept in the read-only cold tier")
+	}
+}
@@ -0,0 +1,51 @@
+package objcache
+
+import (
+	"reflect"
+	"time"
+)
+
+// Cache is a type-safe wrapper of ObjCache for objects of type V.
+type Cache[V any] struct {
+	cache *ObjCache
+}
+
+// NewCache makes a typed cache and returns it.
+func NewCache[V any](config Config) (*Cache[V], error) {
+	cache, err := New(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Cache[V]{cache: cache}, nil
+}
+
+// Set a value for key. See ObjCache.Set.
+func (c *Cache[V]) Set(k string, v V, d time.Duration) error {
+	return c.cache.Set(k, v, d)
+}
+
+// Get the value of key. It returns the zero value of V if the key is not
+// in the cache, or if its object was set on the ObjCache and is not a V.
+// See Config.OnTypeMismatch.
+func (c *Cache[V]) Get(k string) (V, bool) {
+	x, ok := c.cache.Get(k)
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	v, ok := x.(V)
+	if !ok {
+		c.cache.mismatch(k, x, reflect.TypeOf((*V)(nil)).Elem().String())
+	}
+	return v, ok
+}
+
+// Del delete an item for some key.
+func (c *Cache[V]) Del(k string) bool {
+	return c.cache.Del(k)
+}
+
+// ObjCache returns the underlying cache.
+func (c *Cache[V]) ObjCache() *ObjCache {
+	return c.cache
+}
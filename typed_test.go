@@ -0,0 +1,41 @@
+package objcache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTypedCache(t *testing.T) {
+	config := Config{
+		MaxEntryLimit: 10,
+		Expiration:    5 * time.Minute,
+	}
+
+	structs, err := NewCache[ForTest](config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	structs.Set("a", ForTest{value: "a"}, 0)
+	if v, ok := structs.Get("a"); !ok || v.value != "a" {
+		t.Fatalf("expected stored struct, got %+v, %v", v, ok)
+	}
+	if v, ok := structs.Get("missing"); ok || v != (ForTest{}) {
+		t.Fatalf("expected zero value on miss, got %+v, %v", v, ok)
+	}
+
+	ints, err := NewCache[int](config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ints.Set("one", 1, 0)
+	n, ok := ints.Get("one")
+	if !ok || n+1 != 2 {
+		t.Fatalf("expected 1, got %d, %v", n, ok)
+	}
+	if !ints.Del("one") {
+		t.Fatal("expected Del to remove the key")
+	}
+	if n, ok := ints.Get("one"); ok || n != 0 {
+		t.Fatalf("expected zero value after Del, got %d, %v", n, ok)
+	}
+}
@@ -0,0 +1,116 @@
+package objcache
+
+import (
+	"context"
+	"time"
+)
+
+// water checks whether the item count has crossed Config.HighWaterMark
+// since the last check and returns the callback to call for it, or nil.
+// The caller must hold the write lock.
+func (c *ObjCache) water() func() {
+	limit := c.config.MaxEntryLimit
+	if c.config.HighWaterMark <= 0 || limit <= 0 {
+		return nil
+	}
+	n := c.itemCount
+	high := float64(n) >= c.config.HighWaterMark*float64(limit)
+	if high == c.aboveHigh {
+		return nil
+	}
+	c.aboveHigh = high
+	fn := c.config.OnLowWater
+	if high {
+		fn = c.config.OnHighWater
+	}
+	if fn == nil {
+		return nil
+	}
+	return func() { fn(n, limit) }
+}
+
+// lowWater returns the item count eviction goes down to when it makes
+// room for many items at once, Config.LowWaterMark of MaxEntryLimit.
+func (c *ObjCache) lowWater() int {
+	mark := c.config.LowWaterMark
+	if mark == 0 {
+		mark = 1
+	}
+	return int(mark * float64(c.config.MaxEntryLimit))
+}
+
+// full reports whether Config.RejectOnFull rejects setting k because it
+// is a new key and the cache is full. Expired items are removed first to
+// make room. The caller must hold the write lock.
+func (c *ObjCache) full(k string) bool {
+	limit := c.config.MaxEntryLimit
+	if !c.config.RejectOnFull || limit <= 0 || c.counted() < limit {
+		return false
+	}
+	if _, ok := c.items[k]; ok {
+		return false
+	}
+	c.removeExpired()
+	return c.counted() >= limit
+}
+
+// makeRoom evicts items in one loop so that n new items fit within
+// Config.MaxEntryLimit, down to the low-water mark. The caller must hold
+// the write lock.
+func (c *ObjCache) makeRoom(n int) {
+	limit := c.config.MaxEntryLimit
+	if limit <= 0 || c.counted()+n <= limit || c.config.RejectOnFull {
+		return
+	}
+	target := c.lowWater() - n
+	for c.counted() > target && c.removeOldest(nil) {
+	}
+}
+
+// WaitForSpace blocks until the cache holds fewer than
+// Config.MaxEntryLimit items, after removing the expired ones, or until
+// ctx is done, and then returns ctx.Err(). Waiters are woken when an item
+// is deleted, evicted or expires, not by polling. Without MaxEntryLimit
+// it returns nil at once.
+func (c *ObjCache) WaitForSpace(ctx context.Context) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		c.mu.Lock()
+		c.removeExpired()
+		limit := c.config.MaxEntryLimit
+		if limit <= 0 || c.counted() < limit {
+			c.unlock()
+			return nil
+		}
+		if c.space == nil {
+			c.space = make(chan struct{})
+		}
+		space := c.space
+		// Nothing removes the next item to expire without the janitor,
+		// so wake up when it does.
+		var expire <-chan time.Time
+		if len(c.heap) > 0 && c.heap[0].expire != neverExpire && !c.readOnly {
+			at := addExpire(c.heap[0].expire, c.config.StaleGrace)
+			expire = c.after(time.Duration(at-c.now()) + time.Nanosecond)
+		}
+		c.unlock()
+
+		select {
+		case <-space:
+		case <-expire:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// notifySpace wakes the callers of WaitForSpace after items have been
+// removed. The caller must hold the write lock.
+func (c *ObjCache) notifySpace() {
+	if c.space != nil {
+		close(c.space)
+		c.space = nil
+	}
+}
@@ -0,0 +1,204 @@
+package objcache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHighWaterMark(t *testing.T) {
+	var highs, lows []int
+	cache, err := New(Config{
+		MaxEntryLimit: 10,
+		HighWaterMark: 0.8,
+		OnHighWater: func(itemCount, limit int) {
+			if limit != 10 {
+				t.Errorf("expected limit 10, got %d", limit)
+			}
+			highs = append(highs, itemCount)
+		},
+		OnLowWater: func(itemCount, limit int) {
+			lows = append(lows, itemCount)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 20; i = i + 1 {
+		cache.Set(strconv.Itoa(i), i, 0)
+	}
+	if len(highs) != 1 || highs[0] != 8 {
+		t.Fatalf("expected one high-water call at 8 items, got %v", highs)
+	}
+	if len(lows) != 0 {
+		t.Fatalf("expected no low-water call, got %v", lows)
+	}
+
+	for i := 10; i < 14; i = i + 1 {
+		cache.Del(strconv.Itoa(i))
+	}
+	if len(lows) != 1 || lows[0] != 7 {
+		t.Fatalf("expected one low-water call at 7 items, got %v", lows)
+	}
+
+	cache.Set("a", 1, 0)
+	cache.Set("b", 1, 0)
+	if len(highs) != 2 || highs[1] != 8 {
+		t.Fatalf("expected a second high-water call at 8 items, got %v", highs)
+	}
+	if len(lows) != 1 {
+		t.Fatalf("expected still one low-water call, got %v", lows)
+	}
+}
+
+func TestHighWaterMarkInvalid(t *testing.T) {
+	if _, err := New(Config{HighWaterMark: 1.5}); err == nil {
+		t.Fatal("expected an error for HighWaterMark above 1")
+	}
+}
+
+func TestMSetLowWaterMark(t *testing.T) {
+	cache, err := New(Config{MaxEntryLimit: 100, LowWaterMark: 0.8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i = i + 1 {
+		cache.Set(strconv.Itoa(i), i, 0)
+	}
+
+	batch := make(map[string]interface{})
+	for i := 100; i < 150; i = i + 1 {
+		batch[strconv.Itoa(i)] = i
+	}
+	cache.MSet(batch, 0)
+
+	if cache.Len() != 80 {
+		t.Fatalf("expected the cache to settle at 80 items, got %d", cache.Len())
+	}
+	for i := 0; i < 70; i = i + 1 {
+		if cache.Has(strconv.Itoa(i)) {
+			t.Fatalf("expected the oldest item %d to be evicted", i)
+		}
+	}
+	for i := 70; i < 150; i = i + 1 {
+		if !cache.Has(strconv.Itoa(i)) {
+			t.Fatalf("expected item %d to be kept", i)
+		}
+	}
+
+	// A batch that fits evicts nothing.
+	cache.MSet(map[string]interface{}{"a": 1, "b": 2}, 0)
+	if cache.Len() != 82 {
+		t.Fatalf("expected 82 items, got %d", cache.Len())
+	}
+}
+
+func TestSetLowWaterMark(t *testing.T) {
+	cache, err := New(Config{MaxEntryLimit: 10, LowWaterMark: 0.8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i = i + 1 {
+		cache.Set(strconv.Itoa(i), i, 0)
+	}
+	if cache.Len() != 10 {
+		t.Fatalf("expected a full cache, got %d items", cache.Len())
+	}
+
+	cache.Set("new", 0, 0)
+	if cache.Len() != 8 {
+		t.Fatalf("expected one Set to evict down to 8 items, got %d", cache.Len())
+	}
+	for i := 0; i < 3; i = i + 1 {
+		if cache.Has(strconv.Itoa(i)) {
+			t.Fatalf("expected the oldest item %d to be evicted", i)
+		}
+	}
+
+	// The next Sets fit without evicting.
+	cache.Set("a", 0, 0)
+	cache.Set("b", 0, 0)
+	if cache.Len() != 10 || !cache.Has("3") {
+		t.Fatalf("expected 10 items with no eviction, got %d", cache.Len())
+	}
+}
+
+func TestWaitForSpace(t *testing.T) {
+	clock := newFakeClock()
+	cache, err := New(Config{MaxEntryLimit: 2, Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	if err := cache.WaitForSpace(context.Background()); err != nil {
+		t.Fatalf("expected room in an empty cache, got %v", err)
+	}
+	cache.Set("a", 1, -1)
+	cache.Set("b", 2, -1)
+
+	wait := func() chan error {
+		done := make(chan error, 1)
+		go func() { done <- cache.WaitForSpace(context.Background()) }()
+		select {
+		case err := <-done:
+			t.Fatalf("expected WaitForSpace to block, got %v", err)
+		case <-time.After(20 * time.Millisecond):
+		}
+		return done
+	}
+
+	done := wait()
+	cache.Del("a")
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected WaitForSpace to return after Del")
+	}
+
+	// An item expiring makes room without the janitor.
+	cache.Set("c", 3, time.Second)
+	done = wait()
+	waitFor(t, func() bool { return clock.pending() == 1 })
+	clock.Advance(2 * time.Second)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected WaitForSpace to return after the expiration")
+	}
+}
+
+func TestWaitForSpaceCancel(t *testing.T) {
+	cache, err := New(Config{MaxEntryLimit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	cache.Set("a", 1, -1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- cache.WaitForSpace(ctx) }()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected WaitForSpace to return after cancel")
+	}
+	if cache.Len() != 1 {
+		t.Fatalf("expected 1 item, got %d", cache.Len())
+	}
+}